@@ -1779,6 +1779,25 @@ func Call(ctx context.Context, varmode uint8, ident *[]Variable, csloc uint32, r
 	// counters per loop type
 	var loops = make([]s_loop, MAX_LOOPS)
 
+	// FOREACH sources still open when the call ends (RETURN, an error or a
+	// throw inside the loop) are closed here
+	defer func() {
+		for d := depth; d > 0; d-- {
+			if src, isSource := loops[d].iterOverArray.(loopSource); isSource {
+				src.loopClose()
+			}
+		}
+	}()
+
+	// WITH LOCK blocks entered and not yet left, innermost last. Whatever
+	// ends this call releases any still held.
+	var lock_blocks []lockBlock
+	defer func() {
+		for i := len(lock_blocks) - 1; i >= 0; i-- {
+			lock_blocks[i].l.release(lock_blocks[i].write)
+		}
+	}()
+
 	// assign self from calling object
 	if method {
 		bin := bind_int(ifs, "self")
@@ -1944,6 +1963,16 @@ tco_reentry:
 		// return a custom error code. also, having this cond check every
 		// iteration slows down execution.
 
+		// a jump out of a WITH LOCK block releases its lock
+		for len(lock_blocks) > 0 {
+			lb := lock_blocks[len(lock_blocks)-1]
+			if parser.pc > lb.start && parser.pc <= lb.end {
+				break
+			}
+			lb.l.release(lb.write)
+			lock_blocks = lock_blocks[:len(lock_blocks)-1]
+		}
+
 		if parser.pc >= finalline || endFunc || sig_int {
 			break
 		}
@@ -2983,6 +3012,7 @@ tco_reentry:
 				}
 
 				var l int
+				var streamFirst any
 				switch lv := we.result.(type) {
 				case string:
 					l = len(lv)
@@ -3090,6 +3120,18 @@ tco_reentry:
 					l = len(lv)
 				case []any:
 					l = len(lv)
+				case loopSource:
+					// fetch the first value now; an exhausted source skips the loop body
+					first, more, err := lv.loopNext()
+					if err != nil {
+						parser.report(inbound.SourceLine, sf("error reading from FOREACH source\n%+v\n", err))
+						finish(false, ERR_EVAL)
+						break
+					}
+					if more {
+						l = 1
+						streamFirst = first
+					}
 				default:
 					pf("Unknown loop type [%T]\n", lv)
 				}
@@ -3556,6 +3598,11 @@ tco_reentry:
 						condEndPos = len(we.result.(map[string]ResourceSnapshot)) - 1
 					}
 
+				case loopSource:
+					vset(nil, ifs, ident, "key_"+fid, 0)
+					vset(&inbound.Tokens[1], ifs, ident, fid, streamFirst)
+					condEndPos = 0
+
 				case []any:
 
 					if len(we.result.([]any)) > 0 {
@@ -3832,6 +3879,7 @@ tco_reentry:
 			}
 
 			var loopEnd bool
+			var streamNext any
 
 			// perform cond action and check condition
 
@@ -3850,6 +3898,20 @@ tco_reentry:
 
 					it_type := (*thisLoop).itType
 
+					// on-demand sources extend the range one value at a time
+					if src, isSource := (*thisLoop).iterOverArray.(loopSource); isSource {
+						next, more, err := src.loopNext()
+						if err != nil {
+							parser.report(inbound.SourceLine, sf("error reading from FOREACH source\n%+v\n", err))
+							finish(false, ERR_EVAL)
+							break
+						}
+						if more {
+							(*thisLoop).condEnd = (*thisLoop).counter
+							streamNext = next
+						}
+					}
+
 					if (*thisLoop).counter > (*thisLoop).condEnd {
 						loopEnd = true
 					} else {
@@ -3975,6 +4037,9 @@ tco_reentry:
 						case []any:
 							vset(nil, ifs, ident, (*thisLoop).keyVar, (*thisLoop).counter)
 							vset(nil, ifs, ident, (*thisLoop).loopVar, (*thisLoop).iterOverArray.([]any)[(*thisLoop).counter])
+						case loopSource:
+							vset(nil, ifs, ident, (*thisLoop).keyVar, (*thisLoop).counter)
+							vset(nil, ifs, ident, (*thisLoop).loopVar, streamNext)
 						default:
 							// @note: should put a proper exit in here.
 							pv, _ := vget(nil, ifs, ident, sf("%v", (*thisLoop).iterOverArray.([]float64)[(*thisLoop).counter]))
@@ -4076,6 +4141,11 @@ tco_reentry:
 			}
 
 			if loopEnd {
+				// an on-demand source may be left part read, e.g. by BREAK
+				if src, isSource := (*thisLoop).iterOverArray.(loopSource); isSource {
+					src.loopClose()
+					(*thisLoop).iterOverArray = nil
+				}
 				// leave the loop
 				depth -= 1
 				lastConstruct = lastConstruct[:depth]
@@ -5673,6 +5743,18 @@ tco_reentry:
 
 					// set tco flag if required, and perform.
 					if !skip_reentry {
+						// the tail call leaves any WITH LOCK blocks
+						for i := len(lock_blocks) - 1; i >= 0; i-- {
+							lock_blocks[i].l.release(lock_blocks[i].write)
+						}
+						lock_blocks = lock_blocks[:0]
+						// and any FOREACH loops, whose sources the deferred close would miss once depth is reset
+						for d := depth; d > 0; d-- {
+							if src, isSource := loops[d].iterOverArray.(loopSource); isSource {
+								src.loopClose()
+							}
+							loops[d].iterOverArray = nil
+						}
 						wccount = 0
 						depth = 0
 						parser.pc = -1
@@ -6889,6 +6971,60 @@ tco_reentry:
 
 		case C_With:
 
+			// WITH LOCK expr [, mode]
+			if inbound.TokenCount > 2 && inbound.Tokens[1].tokType == Identifier && inbound.Tokens[1].tokText == "lock" &&
+				findDelim(inbound.Tokens, C_As, 2) == -1 {
+
+				found, distance, _ := lookahead(source_base, parser.pc, 0, 0, C_Endwith, []int64{C_With}, []int64{C_Endwith})
+				if !found {
+					parser.report(inbound.SourceLine, "WITH LOCK without an ENDWITH.")
+					finish(false, ERR_SYNTAX)
+					break
+				}
+
+				lockToks := inbound.Tokens[2:]
+				var modeToks []Token
+				if commaAt := findDelim(inbound.Tokens, O_Comma, 2); commaAt != -1 {
+					lockToks = inbound.Tokens[2:commaAt]
+					modeToks = inbound.Tokens[commaAt+1:]
+				}
+				// the body is skipped if the lock cannot be taken
+				endAt := parser.pc + distance
+				we = parser.wrappedEval(ifs, ident, ifs, ident, lockToks)
+				if we.evalError {
+					parser.pc = endAt
+					parser.report(inbound.SourceLine, sf("could not evaluate WITH LOCK lock\n%+v\n", we.errVal))
+					finish(false, ERR_EVAL)
+					break
+				}
+				largs := []any{we.result}
+				if len(modeToks) > 0 {
+					we = parser.wrappedEval(ifs, ident, ifs, ident, modeToks)
+					if we.evalError {
+						parser.pc = endAt
+						parser.report(inbound.SourceLine, sf("could not evaluate WITH LOCK mode\n%+v\n", we.errVal))
+						finish(false, ERR_EVAL)
+						break
+					}
+					largs = append(largs, we.result)
+				}
+				l, err := lockArg("WITH LOCK", largs[0])
+				var write bool
+				if err == nil {
+					write, err = lockMode("WITH LOCK", l, largs, 1)
+				}
+				if err != nil {
+					parser.pc = endAt
+					parser.report(inbound.SourceLine, err.Error())
+					finish(false, ERR_EVAL)
+					break
+				}
+
+				l.acquire(write, -1)
+				lock_blocks = append(lock_blocks, lockBlock{l: l, write: write, start: parser.pc, end: endAt})
+				continue
+			}
+
 			// WITH STRUCT|ENUM name
 			if inbound.TokenCount == 3 {
 				with_error := false
@@ -6995,6 +7131,12 @@ tco_reentry:
 				continue
 			}
 
+			if n := len(lock_blocks); n > 0 && lock_blocks[n-1].end == parser.pc {
+				lock_blocks[n-1].l.release(lock_blocks[n-1].write)
+				lock_blocks = lock_blocks[:n-1]
+				continue
+			}
+
 			if !inside_with {
 				parser.report(inbound.SourceLine, "ENDWITH without a WITH.")
 				finish(false, ERR_SYNTAX)
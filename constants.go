@@ -13,6 +13,7 @@ const identGrowthSize = 4        // how many extra spaces to add when ident need
 const gnfsModulus = 48000        // used by calltable to set max size, mainly impacts recursion
 const globseq_disposal_freq = 64 // sets the number of call allocations per calltable cleanup operation
 const MAX_CLIENTS = 800          // maximum lib-net concurrent listener clients for http server
+const WEB_MAX_BODY = 4 << 20     // default request body limit for web route handlers
 
 const SPACE_CAP = gnfsModulus // initial instance and source functions cap
 const CALL_CAP = 200          // calltable (open calls) start capacity. scales up.
@@ -3,12 +3,14 @@ module za
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/GRbit/go-pcre v1.0.1
 	github.com/VictoriaMetrics/metrics v1.43.2
 	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b
 	github.com/fsnotify/fsnotify v1.10.1
 	github.com/go-sql-driver/mysql v1.10.0
 	github.com/itchyny/gojq v0.12.19
+	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.44
 	github.com/pkg/term v1.1.0
 	golang.org/x/sys v0.44.0
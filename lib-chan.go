@@ -0,0 +1,366 @@
+//go:build !test
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/*
+   channels for passing values between async tasks.
+
+   · a zaChannel wraps a Go channel of any. closing is signalled on a separate
+     done channel, so a send racing a close returns an error instead of
+     panicking, and receivers still drain values buffered before the close.
+   · timeouts are in seconds: omitted waits forever, 0 never waits.
+   · FOREACH over a channel receives until it is closed and empty.
+*/
+
+type zaChannel struct {
+    id       int64
+    c        chan any
+    done     chan struct{}
+    once     sync.Once
+    sent     int64
+    received int64
+}
+
+var zaChannelSeq int64
+
+var errChanClosed = errors.New("channel is closed")
+var errChanTimeout = errors.New("timeout")
+
+func newZaChannel(capacity int) *zaChannel {
+    return &zaChannel{
+        id:   atomic.AddInt64(&zaChannelSeq, 1),
+        c:    make(chan any, capacity),
+        done: make(chan struct{}),
+    }
+}
+
+func (ch *zaChannel) String() string {
+    return fmt.Sprintf("chan#%d(%d/%d)", ch.id, len(ch.c), cap(ch.c))
+}
+
+func (ch *zaChannel) isClosed() bool {
+    select {
+    case <-ch.done:
+        return true
+    default:
+        return false
+    }
+}
+
+// close reports false if the channel was already closed.
+func (ch *zaChannel) close() bool {
+    closed := false
+    ch.once.Do(func() {
+        close(ch.done)
+        closed = true
+    })
+    return closed
+}
+
+// chanTimer returns a channel that fires after timeout, or nil to wait forever.
+// A zero timeout is handled by callers as a non-blocking attempt.
+func chanTimer(timeout time.Duration) (<-chan time.Time, func()) {
+    if timeout < 0 {
+        return nil, func() {}
+    }
+    t := time.NewTimer(timeout)
+    return t.C, func() { t.Stop() }
+}
+
+func (ch *zaChannel) send(v any, timeout time.Duration) error {
+    if ch.isClosed() {
+        return errChanClosed
+    }
+    if timeout == 0 {
+        select {
+        case ch.c <- v:
+            atomic.AddInt64(&ch.sent, 1)
+            return nil
+        default:
+            return errChanTimeout
+        }
+    }
+    expired, stop := chanTimer(timeout)
+    defer stop()
+    select {
+    case ch.c <- v:
+        atomic.AddInt64(&ch.sent, 1)
+        return nil
+    case <-ch.done:
+        return errChanClosed
+    case <-expired:
+        return errChanTimeout
+    }
+}
+
+// drain takes a value buffered before the channel was closed.
+func (ch *zaChannel) drain() (any, error) {
+    select {
+    case v := <-ch.c:
+        atomic.AddInt64(&ch.received, 1)
+        return v, nil
+    default:
+        return nil, errChanClosed
+    }
+}
+
+func (ch *zaChannel) receive(timeout time.Duration) (any, error) {
+    select {
+    case v := <-ch.c:
+        atomic.AddInt64(&ch.received, 1)
+        return v, nil
+    default:
+    }
+    if ch.isClosed() {
+        return ch.drain()
+    }
+    if timeout == 0 {
+        return nil, errChanTimeout
+    }
+    expired, stop := chanTimer(timeout)
+    defer stop()
+    select {
+    case v := <-ch.c:
+        atomic.AddInt64(&ch.received, 1)
+        return v, nil
+    case <-ch.done:
+        return ch.drain()
+    case <-expired:
+        return nil, errChanTimeout
+    }
+}
+
+// loopNext allows FOREACH to receive from a channel until it is closed.
+func (ch *zaChannel) loopNext() (any, bool, error) {
+    v, err := ch.receive(-1)
+    if err == errChanClosed {
+        return nil, false, nil
+    }
+    return v, err == nil, err
+}
+
+// loopClose does nothing: leaving a FOREACH does not close the channel, which
+// other tasks may still be using.
+func (ch *zaChannel) loopClose() {}
+
+func (ch *zaChannel) toMap() map[string]any {
+    return map[string]any{
+        "id": int(ch.id), "length": len(ch.c), "capacity": cap(ch.c), "closed": ch.isClosed(),
+        "sent": int(atomic.LoadInt64(&ch.sent)), "received": int(atomic.LoadInt64(&ch.received)),
+    }
+}
+
+// chanResult is the map returned by chan_receive() and chan_select().
+func chanResult(index int, v any, err error) map[string]any {
+    m := map[string]any{"index": index, "value": v, "ok": err == nil, "error": ""}
+    switch err {
+    case nil:
+    case errChanClosed:
+        m["error"] = "closed"
+    default:
+        m["error"] = err.Error()
+    }
+    return m
+}
+
+// chanSeconds converts an optional timeout argument, -1 meaning wait forever.
+func chanSeconds(fname string, args []any, pos int) (time.Duration, error) {
+    if len(args) <= pos {
+        return -1, nil
+    }
+    f, invalid := GetAsFloat(args[pos])
+    if invalid || f < 0 {
+        return 0, fmt.Errorf("%s: timeout must be zero or more seconds", fname)
+    }
+    return time.Duration(f * float64(time.Second)), nil
+}
+
+// chanSelect waits on several send and receive cases, like Go's select.
+// Each case is map(.recv ch) or map(.send ch, .value v). Returns the index of
+// the case that completed, or -1 on timeout.
+func chanSelect(cases []any, timeout time.Duration) (map[string]any, error) {
+    type caseInfo struct {
+        ch   *zaChannel
+        send bool
+        pos  int // index in the caller's list
+    }
+    var sel []reflect.SelectCase
+    var info []caseInfo
+    for i, c := range cases {
+        m, ok := c.(map[string]any)
+        if !ok {
+            return nil, fmt.Errorf("chan_select: case %d must be a map with .recv or .send", i)
+        }
+        if ch, ok := m["recv"].(*zaChannel); ok {
+            sel = append(sel,
+                reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.c)},
+                reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.done)})
+            info = append(info, caseInfo{ch, false, i}, caseInfo{ch, false, i})
+            continue
+        }
+        if ch, ok := m["send"].(*zaChannel); ok {
+            if ch.isClosed() {
+                return chanResult(i, nil, errChanClosed), nil
+            }
+            val := m["value"]
+            v := reflect.ValueOf(&val).Elem()
+            sel = append(sel,
+                reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.c), Send: v},
+                reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.done)})
+            info = append(info, caseInfo{ch, true, i}, caseInfo{ch, true, i})
+            continue
+        }
+        return nil, fmt.Errorf("chan_select: case %d must be a map with .recv or .send channel", i)
+    }
+    switch {
+    case timeout == 0:
+        sel = append(sel, reflect.SelectCase{Dir: reflect.SelectDefault})
+    case timeout > 0:
+        t := time.NewTimer(timeout)
+        defer t.Stop()
+        sel = append(sel, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.C)})
+    }
+
+    chosen, recv, _ := reflect.Select(sel)
+    if chosen >= len(info) {
+        return chanResult(-1, nil, errChanTimeout), nil
+    }
+    ci := info[chosen]
+    closedCase := chosen%2 == 1
+    switch {
+    case ci.send && closedCase:
+        return chanResult(ci.pos, nil, errChanClosed), nil
+    case ci.send:
+        atomic.AddInt64(&ci.ch.sent, 1)
+        return chanResult(ci.pos, nil, nil), nil
+    case closedCase:
+        v, err := ci.ch.drain()
+        return chanResult(ci.pos, v, err), nil
+    }
+    atomic.AddInt64(&ci.ch.received, 1)
+    return chanResult(ci.pos, recv.Interface(), nil), nil
+}
+
+func buildChanLib() {
+
+    features["chan"] = Feature{version: 1, category: "concurrency"}
+    categories["chan"] = []string{"chan_new", "chan_send", "chan_receive", "chan_close", "chan_closed", "chan_info", "chan_select"}
+
+    slhelp["chan_new"] = LibHelp{in: "[capacity]", out: "channel",
+        action: "Creates a channel for passing values between async tasks. With [#i1]capacity[#i0] 0 (the default) a send waits for a receiver.\n" +
+            "[#SOL]FOREACH over a channel receives values until it is closed and empty."}
+    stdlib["chan_new"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_new", args, 2,
+            "0",
+            "1", "int"); !ok {
+            return nil, err
+        }
+        capacity := 0
+        if len(args) == 1 {
+            capacity = args[0].(int)
+        }
+        if capacity < 0 {
+            return nil, errors.New("chan_new: capacity cannot be negative")
+        }
+        return newZaChannel(capacity), nil
+    }
+
+    slhelp["chan_send"] = LibHelp{in: "channel, value, [timeout_seconds]", out: "bool",
+        action: "Sends [#i1]value[#i0] on [#i1]channel[#i0], waiting up to [#i1]timeout_seconds[#i0] (default forever, 0 to not wait) for room.\n" +
+            "[#SOL]Returns false on timeout. Sending on a closed channel is an error."}
+    stdlib["chan_send"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_send", args, 2,
+            "2", "*main.zaChannel", "any",
+            "3", "*main.zaChannel", "any", "number"); !ok {
+            return nil, err
+        }
+        timeout, err := chanSeconds("chan_send", args, 2)
+        if err != nil {
+            return nil, err
+        }
+        switch err := args[0].(*zaChannel).send(args[1], timeout); err {
+        case nil:
+            return true, nil
+        case errChanTimeout:
+            return false, nil
+        default:
+            return false, fmt.Errorf("chan_send: %v", err)
+        }
+    }
+
+    slhelp["chan_receive"] = LibHelp{in: "channel, [timeout_seconds]", out: "map",
+        action: "Receives a value from [#i1]channel[#i0], waiting up to [#i1]timeout_seconds[#i0] (default forever, 0 to not wait).\n" +
+            "[#SOL]Returns map with .value, .ok and .error (\"\", \"timeout\", or \"closed\" once the channel is closed and empty)."}
+    stdlib["chan_receive"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_receive", args, 2,
+            "1", "*main.zaChannel",
+            "2", "*main.zaChannel", "number"); !ok {
+            return nil, err
+        }
+        timeout, err := chanSeconds("chan_receive", args, 1)
+        if err != nil {
+            return nil, err
+        }
+        v, err := args[0].(*zaChannel).receive(timeout)
+        res := chanResult(0, v, err)
+        delete(res, "index")
+        return res, nil
+    }
+
+    slhelp["chan_close"] = LibHelp{in: "channel", out: "bool",
+        action: "Closes [#i1]channel[#i0]. Buffered values can still be received; further sends fail and waiting senders and receivers wake.\n" +
+            "[#SOL]Returns false if it was already closed. A closed channel is a simple way to tell workers to stop."}
+    stdlib["chan_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_close", args, 1, "1", "*main.zaChannel"); !ok {
+            return nil, err
+        }
+        return args[0].(*zaChannel).close(), nil
+    }
+
+    slhelp["chan_closed"] = LibHelp{in: "channel", out: "bool", action: "Returns true once [#i1]channel[#i0] has been closed."}
+    stdlib["chan_closed"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_closed", args, 1, "1", "*main.zaChannel"); !ok {
+            return nil, err
+        }
+        return args[0].(*zaChannel).isClosed(), nil
+    }
+
+    slhelp["chan_info"] = LibHelp{in: "channel", out: "map", action: "Returns map with .id, .length (buffered values), .capacity, .closed, .sent and .received."}
+    stdlib["chan_info"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_info", args, 1, "1", "*main.zaChannel"); !ok {
+            return nil, err
+        }
+        return args[0].(*zaChannel).toMap(), nil
+    }
+
+    slhelp["chan_select"] = LibHelp{in: "cases_list, [timeout_seconds]", out: "map",
+        action: "Waits until one of several channel operations can proceed, then performs it. Each case is map(.recv channel) or\n" +
+            "[#SOL]map(.send channel, .value v). Returns map with .index (position of the case, -1 on timeout), .value (for receives),\n" +
+            "[#SOL].ok and .error (\"timeout\" or \"closed\"). A receive case on a closed channel is always ready.\n" +
+            "[#SOL][#i1]timeout_seconds[#i0] defaults to forever; 0 returns at once if nothing is ready."}
+    stdlib["chan_select"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("chan_select", args, 2,
+            "1", "[]interface {}",
+            "2", "[]interface {}", "number"); !ok {
+            return nil, err
+        }
+        timeout, err := chanSeconds("chan_select", args, 1)
+        if err != nil {
+            return nil, err
+        }
+        if len(args[0].([]any)) == 0 && timeout < 0 {
+            return nil, errors.New("chan_select: no cases and no timeout would wait forever")
+        }
+        return chanSelect(args[0].([]any), timeout)
+    }
+
+}
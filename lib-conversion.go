@@ -21,6 +21,7 @@ import (
     "strconv"
     "strings"
     str "strings"
+    "sync"
     "unsafe"
 
     "github.com/itchyny/gojq"
@@ -1270,7 +1271,7 @@ func buildConversionLib() {
     categories["conversion"] = []string{
         "byte", "as_int", "as_int64", "as_bigi", "as_bigf", "as_float", "as_float32", "as_bool", "as_string", "maxuint", "char", "asc", "as_uint",
         "is_number", "base64e", "base64d", "hex_encode", "hex_decode", "url_encode", "url_decode",
-        "json_decode", "json_encode", "json_format", "json_query", "pp",
+        "json_decode", "json_encode", "json_format", "json_query", "json_validate", "pp",
         "write_struct", "read_struct",
         "btoi", "itob", "dtoo", "otod", "s2m", "m2s", "f2n", "to_typed", "table", "md2ansi", "human_size", "format_currency",
         "str", "type", "typeof",
@@ -1608,51 +1609,92 @@ func buildConversionLib() {
         return string(pj.Bytes()), nil
     }
 
-    slhelp["json_query"] = LibHelp{in: "input_string,query_string[,map_bool]", out: "string",
-        action: "Returns the result of processing [#i1]input_string[#i0] using the gojq library.\n" +
-            "[#SOL][#i1]query_string[#i0] is a jq-like query to operate with. If [#i1]map_bool[#i0] is false (default)\n" +
-            "[#SOL]then a string is returned, otherwise an iterable list is returned."}
+    slhelp["json_query"] = LibHelp{in: "input,query_string[,map_bool|options]", out: "string|[]any",
+        action: "Runs the jq program [#i1]query_string[#i0] (pipes, select(), map(), object construction, slicing,\n" +
+            "[#SOL]keys, length, .., string interpolation, etc.) over [#i1]input[#i0], which may be a JSON string or a Za map/array.\n" +
+            "[#SOL]With a JSON string input and [#i1]map_bool[#i0] false (default) the results are returned as a string, one per line;\n" +
+            "[#SOL]otherwise an iterable list of results is returned. Za map/array input returns a list by default.\n" +
+            "[#SOL]Options: map(.list bool, .vars map(.name value)) where each var is available in the query as $name."}
     stdlib["json_query"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("json_query", args, 2,
-            "2", "string", "string",
-            "3", "string", "string", "bool"); !ok {
+            "2", "any", "string",
+            "3", "any", "string", "any"); !ok {
             return nil, err
         }
 
-        var complex bool
+        var input any
+        complex := true
+        if js, isString := args[0].(string); isString {
+            // decode any JSON value, keeping integers exact for gojq
+            dec := json.NewDecoder(str.NewReader(js))
+            dec.UseNumber()
+            if err := dec.Decode(&input); err != nil {
+                return "", errors.New("could not convert JSON in json_query()")
+            }
+            input = jqNormalise(input)
+            complex = false
+        } else {
+            input = jqNormalise(args[0])
+        }
+
+        var varNames []string
+        var varValues []any
         if len(args) == 3 {
-            switch args[2].(type) {
+            switch opt := args[2].(type) {
             case bool:
-                complex = args[2].(bool)
+                complex = opt
+            case map[string]any:
+                for k, v := range opt {
+                    switch k {
+                    case "list":
+                        b, isBool := v.(bool)
+                        if !isBool {
+                            return nil, errors.New("json_query() option 'list' must be a boolean")
+                        }
+                        complex = b
+                    case "vars":
+                        vars, isMap := v.(map[string]any)
+                        if !isMap {
+                            return nil, errors.New("json_query() option 'vars' must be a map")
+                        }
+                        for name := range vars {
+                            varNames = append(varNames, name)
+                        }
+                        sort.Strings(varNames)
+                        for _, name := range varNames {
+                            varValues = append(varValues, jqNormalise(vars[name]))
+                        }
+                    default:
+                        return nil, errors.New(sf("unknown json_query() option '%s'", k))
+                    }
+                }
             default:
-                return nil, errors.New("argument 3 must be a boolean when present in json_query()")
+                return nil, errors.New("argument 3 must be a boolean or options map when present in json_query()")
             }
         }
 
-        // first parse query string
-        q, e := gojq.Parse(args[1].(string))
-        if e != nil {
-            return "", errors.New("invalid query string in json_query()")
-        }
-
-        // then decode json to map suitable for gojq.Run
-        var iv map[string]any
-        dec := json.NewDecoder(str.NewReader(args[0].(string)))
-        if err := dec.Decode(&iv); err != nil {
-            return "", errors.New("could not convert JSON in json_query()")
+        code, err := jqCompile(args[1].(string), varNames)
+        if err != nil {
+            return "", errors.New(sf("invalid query string in json_query(): %v", err))
         }
 
         // process query
         var newstring str.Builder
-        var retlist []any
+        retlist := []any{}
 
-        iter := q.Run(iv)
+        iter := code.Run(input, varValues...)
 
         for {
             v, ok := iter.Next()
             if !ok {
                 break
             }
+            if e, isErr := v.(error); isErr {
+                if h, isHalt := e.(*gojq.HaltError); isHalt && h.Value() == nil {
+                    break
+                }
+                return nil, errors.New(sf("json_query(): %v", e))
+            }
             if complex {
                 retlist = append(retlist, v)
             } else {
@@ -1667,6 +1709,40 @@ func buildConversionLib() {
 
     }
 
+    slhelp["json_validate"] = LibHelp{in: "value_or_string,schema", out: "[]string",
+        action: "Validates [#i1]value[#i0] (or a JSON string) against a JSON Schema (a map or JSON string).\n" +
+            "[#SOL]Returns an empty array when valid, otherwise one \"path: message\" string per problem, e.g. \"$.server.port: expected integer, got string\".\n" +
+            "[#SOL]Supports a draft-07/2020-12 subset: type, enum, const, properties, required, additionalProperties, patternProperties,\n" +
+            "[#SOL]items, prefixItems, contains, min/max bounds, pattern, format, multipleOf, allOf/anyOf/oneOf/not, if/then/else\n" +
+            "[#SOL]and local $ref (#/$defs/..., #/definitions/..., anchors)."}
+    stdlib["json_validate"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("json_validate", args, 1, "2", "any", "any"); !ok {
+            return nil, err
+        }
+        value, schema := args[0], args[1]
+        if s, isStr := value.(string); isStr {
+            dec := json.NewDecoder(str.NewReader(s))
+            dec.UseNumber()
+            if err := dec.Decode(&value); err != nil {
+                return nil, errors.New(sf("could not decode JSON value in json_validate(): %v", err))
+            }
+        }
+        if s, isStr := schema.(string); isStr {
+            if err := json.Unmarshal([]byte(s), &schema); err != nil {
+                return nil, errors.New(sf("could not decode JSON schema in json_validate(): %v", err))
+            }
+        }
+        problems, err := jsonValidate(value, schema)
+        if err != nil {
+            return nil, errors.New(sf("json_validate(): %v", err))
+        }
+        result := make([]any, len(problems))
+        for i, p := range problems {
+            result[i] = p
+        }
+        return result, nil
+    }
+
     slhelp["pp"] = LibHelp{in: "map|slice, [max_depth], [indent_string]", out: "string", action: "Pretty print a map or slice with optional indentation, depth limit, and colour-coded section headings."}
     stdlib["pp"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("pp", args, 3,
@@ -2126,3 +2202,101 @@ func buildConversionLib() {
     }
 
 }
+
+// jqNormalise copies a Za value into the types gojq operates on: maps, []any,
+// int, float64, *big.Int, string, bool and nil.
+func jqNormalise(v any) any {
+    switch t := v.(type) {
+    case nil, bool, string, int, float64, *big.Int:
+        return t
+    case json.Number:
+        if i, err := t.Int64(); err == nil {
+            return int(i)
+        }
+        if b, ok := new(big.Int).SetString(t.String(), 10); ok {
+            return b
+        }
+        f, _ := t.Float64()
+        return f
+    case map[string]any:
+        m := make(map[string]any, len(t))
+        for k, e := range t {
+            m[k] = jqNormalise(e)
+        }
+        return m
+    case []any:
+        a := make([]any, len(t))
+        for i, e := range t {
+            a[i] = jqNormalise(e)
+        }
+        return a
+    case *big.Float:
+        f, _ := t.Float64()
+        return f
+    case float32:
+        return float64(t)
+    case uint64:
+        if t > math.MaxInt64 {
+            return new(big.Int).SetUint64(t)
+        }
+        return int(t)
+    }
+    rv := reflect.ValueOf(v)
+    switch rv.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return int(rv.Int())
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+        return int(rv.Uint())
+    case reflect.Slice, reflect.Array:
+        a := make([]any, rv.Len())
+        for i := range a {
+            a[i] = jqNormalise(rv.Index(i).Interface())
+        }
+        return a
+    case reflect.Map:
+        m := make(map[string]any, rv.Len())
+        iter := rv.MapRange()
+        for iter.Next() {
+            m[sf("%v", iter.Key().Interface())] = jqNormalise(iter.Value().Interface())
+        }
+        return m
+    case reflect.Struct:
+        return jqNormalise(s2m(v))
+    case reflect.Pointer:
+        if rv.IsNil() {
+            return nil
+        }
+        return jqNormalise(rv.Elem().Interface())
+    }
+    return sf("%v", v)
+}
+
+var jqCache = make(map[string]*gojq.Code)
+var jqCacheLock sync.Mutex
+
+// jqCompile parses and compiles a jq program, reusing earlier compilations.
+func jqCompile(query string, varNames []string) (*gojq.Code, error) {
+    key := query + "\x00" + str.Join(varNames, ",")
+    jqCacheLock.Lock()
+    defer jqCacheLock.Unlock()
+    if code, found := jqCache[key]; found {
+        return code, nil
+    }
+    q, err := gojq.Parse(query)
+    if err != nil {
+        return nil, err
+    }
+    vars := make([]string, len(varNames))
+    for i, n := range varNames {
+        vars[i] = "$" + n
+    }
+    code, err := gojq.Compile(q, gojq.WithVariables(vars))
+    if err != nil {
+        return nil, err
+    }
+    if len(jqCache) >= 256 {
+        jqCache = make(map[string]*gojq.Code)
+    }
+    jqCache[key] = code
+    return code, nil
+}
@@ -4,6 +4,7 @@ package main
 
 import (
     "fmt"
+    "reflect"
     "strconv"
     "strings"
     "time"
@@ -12,7 +13,9 @@ import (
 func buildCronLib() {
 
     features["cron"] = Feature{version: 1, category: "date"}
-    categories["cron"] = []string{"cron_parse", "quartz_to_cron", "cron_next", "cron_validate"}
+    categories["cron"] = []string{"cron_parse", "quartz_to_cron", "cron_next", "cron_validate",
+        "cron_schedule", "cron_cancel", "cron_status", "cron_jobs", "cron_wait",
+    }
 
     slhelp["cron_parse"] = LibHelp{in: "cron_schedule", out: "any", action: "Parse cron schedule and return structured description of what it means."}
     stdlib["cron_parse"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
@@ -90,6 +93,100 @@ func buildCronLib() {
         return isValid, nil
     }
 
+    slhelp["cron_schedule"] = LibHelp{in: "cron_schedule, function_name, [args_list, [options_map]]", out: "job_id",
+        action: "Runs the Za function [#i1]function_name[#i0] with [#i1]args_list[#i0] in the background whenever [#i1]cron_schedule[#i0] fires. Returns a job id.\n" +
+            "[#SOL]Schedules take the five cron fields, @hourly, @daily, @weekly, @monthly, @yearly, or \"@every <duration>\" (e.g. \"@every 90s\").\n" +
+            "[#SOL]Options: .name (label), .timezone (e.g. \"Europe/London\", default local), .overlap (skip, queue or allow: what to do\n" +
+            "[#SOL]when a run is still going at the next fire, default skip) and .missed (skip or run_once: whether fires missed while the\n" +
+            "[#SOL]process was stopped or suspended trigger one catch-up run, default skip)."}
+    stdlib["cron_schedule"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("cron_schedule", args, 3,
+            "2", "string", "string",
+            "3", "string", "string", "any",
+            "4", "string", "string", "any", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        fn, err := qualifyFnName(ns, args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("cron_schedule: %v", err)
+        }
+        var fargs []any
+        if len(args) > 2 {
+            rv := reflect.ValueOf(args[2])
+            if rv.Kind() != reflect.Slice {
+                return nil, fmt.Errorf("cron_schedule: args must be a list")
+            }
+            for i := 0; i < rv.Len(); i++ {
+                fargs = append(fargs, rv.Index(i).Interface())
+            }
+        }
+        var opts map[string]any
+        if len(args) == 4 {
+            opts = args[3].(map[string]any)
+        }
+        j, err := newCronJob(args[0].(string), fn, evalfs, fargs, opts)
+        if err != nil {
+            return nil, err
+        }
+        if err := j.start(); err != nil {
+            return nil, fmt.Errorf("cron_schedule: %v", err)
+        }
+        return j.id, nil
+    }
+
+    slhelp["cron_cancel"] = LibHelp{in: "job_id", out: "bool", action: "Stops future runs of a scheduled job. A run in progress is allowed to finish. Returns false if the job was not active."}
+    stdlib["cron_cancel"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("cron_cancel", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        j, err := cronLookup("cron_cancel", args[0].(string))
+        if err != nil {
+            return false, nil
+        }
+        return j.stop(), nil
+    }
+
+    slhelp["cron_status"] = LibHelp{in: "job_id", out: "map", action: "Returns the status of a scheduled job: .id .name .spec .function .timezone .overlap .missed_policy .active\n" +
+        "[#SOL].next_run and .last_run (epoch nanoseconds, 0 if none), .last_duration_ms, .last_error, .running, .queued,\n" +
+        "[#SOL].runs, .failures, .skipped (overlapping fires dropped) and .missed (fires missed while stopped or suspended)."}
+    stdlib["cron_status"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("cron_status", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        j, err := cronLookup("cron_status", args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+        return j.toMap(), nil
+    }
+
+    slhelp["cron_jobs"] = LibHelp{in: "", out: "list", action: "Returns a list of cron_status() maps for all active scheduled jobs."}
+    stdlib["cron_jobs"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("cron_jobs", args, 0); !ok {
+            return nil, err
+        }
+        return cronList(), nil
+    }
+
+    slhelp["cron_wait"] = LibHelp{in: "[timeout_seconds]", out: "bool", action: "Blocks until every scheduled job has been cancelled, or the optional timeout passes.\n" +
+        "[#SOL]Returns true if no jobs remain. Use it at the end of a supervisor script to keep the jobs running."}
+    stdlib["cron_wait"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("cron_wait", args, 2,
+            "0",
+            "1", "number"); !ok {
+            return nil, err
+        }
+        var timeout time.Duration
+        if len(args) == 1 {
+            f, _ := GetAsFloat(args[0])
+            if f <= 0 {
+                return nil, fmt.Errorf("cron_wait: timeout must be positive")
+            }
+            timeout = time.Duration(f * float64(time.Second))
+        }
+        return cronWait(timeout), nil
+    }
+
 }
 
 // Convert Quartz format to cron format
@@ -0,0 +1,360 @@
+//go:build !test
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/*
+   in-process cron scheduler.
+
+   · each job owns a goroutine that sleeps until its next fire time, works out
+     whether the fire is on time or was missed (the process was stopped or the
+     host suspended), and dispatches the Za function on its own goroutine.
+   · overlap decides what happens when a fire arrives while the previous run
+     is still going: skip it, queue it behind the running call, or allow a
+     concurrent run.
+   · missed fires are either skipped or collapsed into a single catch-up run.
+   · schedules are evaluated in the job's timezone, so "0 9 * * *" means 09:00
+     there, across DST changes.
+*/
+
+// cronMacros maps the usual @ shorthands to their five field form.
+var cronMacros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+type cronJob struct {
+    id       string
+    name     string
+    spec     string
+    fn       string
+    args     []any
+    evalfs   uint32
+    every    time.Duration // set for "@every <duration>" schedules
+    loc      *time.Location
+    overlap  string // skip, queue or allow
+    missed   string // skip or run_once
+    cancel   chan struct{}
+    mu       sync.Mutex
+    active   bool
+    next     time.Time
+    lastRun  time.Time
+    lastDur  time.Duration
+    lastErr  string
+    running  int
+    queued   int
+    runs     int
+    failures int
+    skipped  int
+    missedN  int
+}
+
+var (
+    cronJobs   = make(map[string]*cronJob)
+    cronLock   sync.Mutex
+    cronJobSeq int64
+    cronIdle   = sync.NewCond(&cronLock) // signalled as jobs finish, for cron_wait()
+)
+
+// cronSpec normalises a schedule, returning the five field form or an @every interval.
+func cronSpec(spec string) (string, time.Duration, error) {
+    spec = strings.TrimSpace(spec)
+    if m, found := cronMacros[strings.ToLower(spec)]; found {
+        return m, 0, nil
+    }
+    if strings.HasPrefix(strings.ToLower(spec), "@every ") {
+        d, err := time.ParseDuration(strings.TrimSpace(spec[7:]))
+        if err != nil {
+            return "", 0, fmt.Errorf("invalid @every interval: %v", err)
+        }
+        if d < 100*time.Millisecond {
+            return "", 0, fmt.Errorf("@every interval must be at least 100ms")
+        }
+        return "", d, nil
+    }
+    if _, err := validateCronSchedule(spec); err != nil {
+        return "", 0, err
+    }
+    return spec, 0, nil
+}
+
+func newCronJob(spec, fn string, evalfs uint32, args []any, opts map[string]any) (*cronJob, error) {
+    j := &cronJob{spec: spec, fn: fn, args: args, evalfs: evalfs, loc: time.Local, overlap: "skip", missed: "skip", cancel: make(chan struct{})}
+    for k, v := range opts {
+        s, isString := v.(string)
+        if !isString {
+            return nil, fmt.Errorf("cron_schedule option '%s' must be a string", k)
+        }
+        switch k {
+        case "name":
+            j.name = s
+        case "overlap":
+            if s != "skip" && s != "queue" && s != "allow" {
+                return nil, fmt.Errorf("cron_schedule option 'overlap' must be skip, queue or allow")
+            }
+            j.overlap = s
+        case "missed":
+            if s != "skip" && s != "run_once" {
+                return nil, fmt.Errorf("cron_schedule option 'missed' must be skip or run_once")
+            }
+            j.missed = s
+        case "timezone":
+            loc, err := time.LoadLocation(s)
+            if err != nil {
+                return nil, fmt.Errorf("cron_schedule: unknown timezone '%s'", s)
+            }
+            j.loc = loc
+        default:
+            return nil, fmt.Errorf("cron_schedule: unknown option '%s'", k)
+        }
+    }
+    var err error
+    if j.spec, j.every, err = cronSpec(spec); err != nil {
+        return nil, fmt.Errorf("cron_schedule: %v", err)
+    }
+    if j.every > 0 {
+        j.spec = spec
+    }
+    j.id = fmt.Sprintf("cron_%d", atomic.AddInt64(&cronJobSeq, 1))
+    if j.name == "" {
+        j.name = j.id
+    }
+    return j, nil
+}
+
+// nextAfter returns the first fire time after t.
+func (j *cronJob) nextAfter(t time.Time) (time.Time, error) {
+    if j.every > 0 {
+        return t.Add(j.every), nil
+    }
+    return parseCronSchedule(j.spec, t.In(j.loc))
+}
+
+// grace is how late a fire may be before it counts as missed.
+func (j *cronJob) grace() time.Duration {
+    if j.every > 0 && j.every/2 < time.Minute {
+        return j.every / 2
+    }
+    return time.Minute
+}
+
+func (j *cronJob) start() error {
+    next, err := j.nextAfter(time.Now())
+    if err != nil {
+        return err
+    }
+    j.next = next
+    j.active = true
+    cronLock.Lock()
+    cronJobs[j.id] = j
+    cronLock.Unlock()
+    go j.loop()
+    return nil
+}
+
+func (j *cronJob) loop() {
+    for {
+        j.mu.Lock()
+        due := j.next
+        j.mu.Unlock()
+        timer := time.NewTimer(time.Until(due))
+        select {
+        case <-j.cancel:
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+
+        run, err := j.fired(due, time.Now())
+        if err != nil {
+            j.mu.Lock()
+            j.lastErr = err.Error()
+            j.mu.Unlock()
+            j.stop()
+            return
+        }
+        if run {
+            j.dispatch()
+        }
+    }
+}
+
+// fired advances the schedule after the fire due at due was noticed at now,
+// counting missed fires, and reports whether the job should run.
+func (j *cronJob) fired(due, now time.Time) (bool, error) {
+    next, err := j.nextAfter(now)
+    if err != nil {
+        return false, err
+    }
+    // compare wall clocks: the monotonic clock stops while the host is suspended
+    late := now.Round(0).Sub(due.Round(0)) > j.grace()
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.next = next
+    if late {
+        // count every fire time that passed unnoticed
+        for t := due; !t.After(now); {
+            j.missedN++
+            if t, err = j.nextAfter(t); err != nil {
+                break
+            }
+        }
+    }
+    return !late || j.missed == "run_once", nil
+}
+
+// dispatch starts a run, or applies the overlap policy if one is in progress.
+func (j *cronJob) dispatch() {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if j.running > 0 {
+        switch j.overlap {
+        case "skip":
+            j.skipped++
+            return
+        case "queue":
+            j.queued++
+            return
+        }
+    }
+    j.running++
+    go j.run()
+}
+
+func (j *cronJob) run() {
+    for {
+        start := time.Now()
+        err := j.call()
+        j.mu.Lock()
+        j.runs++
+        j.lastRun = start
+        j.lastDur = time.Since(start)
+        j.lastErr = ""
+        if err != nil {
+            j.failures++
+            j.lastErr = err.Error()
+        }
+        if j.queued > 0 && j.active {
+            j.queued--
+            j.mu.Unlock()
+            continue
+        }
+        j.running--
+        j.mu.Unlock()
+        cronLock.Lock()
+        cronIdle.Broadcast()
+        cronLock.Unlock()
+        return
+    }
+}
+
+// call runs the job function, turning a panic in the handler into an error.
+func (j *cronJob) call() (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    _, err = zaCallFn(j.fn, j.evalfs, ciAsyn, j.args...)
+    return err
+}
+
+// stop cancels future fires. A run already in progress is left to finish.
+func (j *cronJob) stop() bool {
+    j.mu.Lock()
+    if !j.active {
+        j.mu.Unlock()
+        return false
+    }
+    j.active = false
+    j.queued = 0
+    close(j.cancel)
+    j.mu.Unlock()
+    cronLock.Lock()
+    delete(cronJobs, j.id)
+    cronIdle.Broadcast()
+    cronLock.Unlock()
+    return true
+}
+
+func (j *cronJob) toMap() map[string]any {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    m := map[string]any{
+        "id": j.id, "name": j.name, "spec": j.spec, "function": j.fn, "timezone": j.loc.String(),
+        "overlap": j.overlap, "missed_policy": j.missed, "active": j.active,
+        "next_run": 0, "last_run": 0, "last_duration_ms": msecs(j.lastDur), "last_error": j.lastErr,
+        "running": j.running > 0, "queued": j.queued, "runs": j.runs, "failures": j.failures,
+        "skipped": j.skipped, "missed": j.missedN,
+    }
+    if j.active {
+        m["next_run"] = int(j.next.UnixNano())
+    }
+    if !j.lastRun.IsZero() {
+        m["last_run"] = int(j.lastRun.UnixNano())
+    }
+    return m
+}
+
+func cronLookup(fname, id string) (*cronJob, error) {
+    cronLock.Lock()
+    j, found := cronJobs[id]
+    cronLock.Unlock()
+    if !found {
+        return nil, fmt.Errorf("%s: unknown cron job '%s'", fname, id)
+    }
+    return j, nil
+}
+
+// cronList returns the status of every active job, ordered by id.
+func cronList() []any {
+    cronLock.Lock()
+    jobs := make([]*cronJob, 0, len(cronJobs))
+    for _, j := range cronJobs {
+        jobs = append(jobs, j)
+    }
+    cronLock.Unlock()
+    sort.Slice(jobs, func(a, b int) bool {
+        return len(jobs[a].id) < len(jobs[b].id) || (len(jobs[a].id) == len(jobs[b].id) && jobs[a].id < jobs[b].id)
+    })
+    list := make([]any, 0, len(jobs))
+    for _, j := range jobs {
+        list = append(list, j.toMap())
+    }
+    return list
+}
+
+// cronWait blocks until no jobs remain, or the timeout passes (0 waits forever).
+func cronWait(timeout time.Duration) bool {
+    if timeout > 0 {
+        t := time.AfterFunc(timeout, func() {
+            cronLock.Lock()
+            cronIdle.Broadcast()
+            cronLock.Unlock()
+        })
+        defer t.Stop()
+    }
+    deadline := time.Now().Add(timeout)
+    cronLock.Lock()
+    defer cronLock.Unlock()
+    for len(cronJobs) > 0 {
+        if timeout > 0 && !time.Now().Before(deadline) {
+            return false
+        }
+        cronIdle.Wait()
+    }
+    return true
+}
@@ -0,0 +1,693 @@
+//go:build !test
+
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "reflect"
+    "sort"
+    "strings"
+    "unicode/utf8"
+)
+
+// csvOptions holds the parsed options map shared by the csv_* functions.
+type csvOptions struct {
+    delim       rune
+    quote       rune
+    comment     rune // 0 when comment lines are not recognised
+    header      bool
+    columns     []string
+    infer       bool
+    trim        bool
+    skip        int
+    limit       int
+    into        string
+    source      string // csv_read only: "file", "text" or "" to decide by the argument
+    alwaysQuote bool
+    crlf        bool
+}
+
+// csvParser reads delimited records with a configurable delimiter and quote character.
+type csvParser struct {
+    r       *bufio.Reader
+    opts    csvOptions
+    line    int // current input line, for error messages
+    recLine int // line on which the current record started
+}
+
+// csvReader is the handle returned by csv_open and used internally by csv_read.
+type csvReader struct {
+    p       *csvParser
+    file    *os.File
+    header  []string
+    target  *structTarget
+    records int
+    closed  bool
+}
+
+func parseCsvOptions(fname string, arg any) (csvOptions, error) {
+    opts := csvOptions{delim: ',', quote: '"', header: true, limit: -1}
+    if arg == nil {
+        return opts, nil
+    }
+    for k, v := range arg.(map[string]any) {
+        switch k {
+        case "delimiter", "separator", "quote", "comment":
+            s, ok := v.(string)
+            if !ok || utf8.RuneCountInString(s) > 1 || (s == "" && k != "comment") {
+                return opts, fmt.Errorf("%s option '%s' must be a single character", fname, k)
+            }
+            r, _ := utf8.DecodeRuneInString(s)
+            switch k {
+            case "delimiter", "separator":
+                opts.delim = r
+            case "quote":
+                opts.quote = r
+            case "comment":
+                if s == "" {
+                    r = 0
+                }
+                opts.comment = r
+            }
+        case "tsv":
+            b, ok := v.(bool)
+            if !ok {
+                return opts, fmt.Errorf("%s option 'tsv' must be a boolean", fname)
+            }
+            if b {
+                opts.delim = '\t'
+            }
+        case "header", "infer", "trim", "always_quote", "crlf":
+            b, ok := v.(bool)
+            if !ok {
+                return opts, fmt.Errorf("%s option '%s' must be a boolean", fname, k)
+            }
+            switch k {
+            case "header":
+                opts.header = b
+            case "infer":
+                opts.infer = b
+            case "trim":
+                opts.trim = b
+            case "always_quote":
+                opts.alwaysQuote = b
+            case "crlf":
+                opts.crlf = b
+            }
+        case "skip", "limit":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 0 {
+                return opts, fmt.Errorf("%s option '%s' must be a non-negative number", fname, k)
+            }
+            if k == "skip" {
+                opts.skip = n
+            } else {
+                opts.limit = n
+            }
+        case "columns":
+            cols, ok := v.([]any)
+            if !ok {
+                if sc, isStrings := v.([]string); isStrings {
+                    for _, c := range sc {
+                        cols = append(cols, c)
+                    }
+                } else {
+                    return opts, fmt.Errorf("%s option 'columns' must be an array of names", fname)
+                }
+            }
+            for _, c := range cols {
+                opts.columns = append(opts.columns, GetAsString(c))
+            }
+        case "into":
+            s, ok := v.(string)
+            if !ok || s == "" {
+                return opts, fmt.Errorf("%s option 'into' must be a struct name", fname)
+            }
+            opts.into = s
+        case "source":
+            s, ok := v.(string)
+            if fname != "csv_read" {
+                return opts, fmt.Errorf("unknown %s option '%s'", fname, k)
+            }
+            if !ok || (s != "file" && s != "text") {
+                return opts, fmt.Errorf("%s option 'source' must be \"file\" or \"text\"", fname)
+            }
+            opts.source = s
+        default:
+            return opts, fmt.Errorf("unknown %s option '%s'", fname, k)
+        }
+    }
+    if opts.delim == opts.quote {
+        return opts, fmt.Errorf("%s delimiter and quote character must differ", fname)
+    }
+    return opts, nil
+}
+
+// record returns the next record, or io.EOF once the input is exhausted.
+// Blank lines and comment lines are skipped.
+func (p *csvParser) record() ([]string, error) {
+    for {
+        p.line++
+        p.recLine = p.line
+        first, _, err := p.r.ReadRune()
+        if err == io.EOF {
+            return nil, io.EOF
+        }
+        if err != nil {
+            return nil, err
+        }
+        if first == '\n' {
+            continue
+        }
+        if first == '\r' {
+            if n, _, err := p.r.ReadRune(); err == nil && n != '\n' {
+                p.r.UnreadRune()
+            }
+            continue
+        }
+        if p.opts.comment != 0 && first == p.opts.comment {
+            if _, err := p.r.ReadString('\n'); err != nil && err != io.EOF {
+                return nil, err
+            }
+            continue
+        }
+        p.r.UnreadRune()
+        return p.fields()
+    }
+}
+
+// fields parses the fields of one record, which may span lines inside quotes.
+func (p *csvParser) fields() ([]string, error) {
+    var fields []string
+    var field strings.Builder
+    quoted, inQuotes, atStart := false, false, true
+
+    finish := func() {
+        f := field.String()
+        if p.opts.trim && !quoted {
+            f = strings.TrimSpace(f)
+        }
+        fields = append(fields, f)
+        field.Reset()
+        quoted, atStart = false, true
+    }
+
+    for {
+        c, _, err := p.r.ReadRune()
+        if err == io.EOF {
+            if inQuotes {
+                return nil, fmt.Errorf("unterminated quoted field in record starting on line %d", p.recLine)
+            }
+            finish()
+            return fields, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        if inQuotes {
+            switch c {
+            case p.opts.quote:
+                next, _, err := p.r.ReadRune()
+                if err == nil && next == p.opts.quote {
+                    field.WriteRune(c) // doubled quote is a literal quote
+                    continue
+                }
+                if err == nil {
+                    p.r.UnreadRune()
+                }
+                inQuotes = false
+            case '\n':
+                p.line++
+                field.WriteRune(c)
+            default:
+                field.WriteRune(c)
+            }
+            continue
+        }
+
+        switch {
+        case c == p.opts.delim:
+            finish()
+        case c == '\n':
+            finish()
+            return fields, nil
+        case c == '\r':
+            next, _, err := p.r.ReadRune()
+            if err == nil && next != '\n' {
+                p.r.UnreadRune()
+            }
+            finish()
+            return fields, nil
+        case c == p.opts.quote && (atStart || (p.opts.trim && strings.TrimSpace(field.String()) == "")):
+            field.Reset()
+            quoted, inQuotes, atStart = true, true, false
+        case quoted:
+            // text after a closing quote; keep it rather than fail
+            if !(p.opts.trim && (c == ' ' || c == '\t')) {
+                field.WriteRune(c)
+            }
+        default:
+            field.WriteRune(c)
+            atStart = false
+        }
+    }
+}
+
+// newCsvReader prepares a reader over r, consuming skipped lines and the header.
+func newCsvReader(ns string, r io.Reader, opts csvOptions) (*csvReader, error) {
+    c := &csvReader{p: &csvParser{r: bufio.NewReader(r), opts: opts}}
+    for i := 0; i < opts.skip; i++ {
+        c.p.line++
+        if _, err := c.p.r.ReadString('\n'); err != nil {
+            if err == io.EOF {
+                break
+            }
+            return nil, err
+        }
+    }
+
+    switch {
+    case len(opts.columns) > 0:
+        c.header = opts.columns
+        if opts.header {
+            // discard the file's own header line in favour of the supplied names
+            if _, err := c.p.record(); err != nil && err != io.EOF {
+                return nil, err
+            }
+        }
+    case opts.header:
+        h, err := c.p.record()
+        if err != nil && err != io.EOF {
+            return nil, err
+        }
+        c.header = h
+    }
+
+    if opts.into != "" {
+        if c.header == nil {
+            return nil, errors.New("csv .into requires a header line or .columns")
+        }
+        t, err := resolveStructTarget(ns, opts.into)
+        if err != nil {
+            return nil, err
+        }
+        if err := t.bind(c.header); err != nil {
+            return nil, err
+        }
+        c.target = t
+    }
+    return c, nil
+}
+
+func (c *csvReader) close() error {
+    if c.closed {
+        return nil
+    }
+    c.closed = true
+    if c.file != nil {
+        return c.file.Close()
+    }
+    return nil
+}
+
+// next returns the following record as a map, struct or array, or false at the end of input.
+func (c *csvReader) next() (any, bool, error) {
+    if c.closed || (c.p.opts.limit >= 0 && c.records >= c.p.opts.limit) {
+        c.close()
+        return nil, false, nil
+    }
+    rec, err := c.p.record()
+    if err == io.EOF {
+        c.close()
+        return nil, false, nil
+    }
+    if err != nil {
+        c.close()
+        return nil, false, err
+    }
+    c.records++
+
+    if c.header == nil {
+        row := make([]any, len(rec))
+        for i, f := range rec {
+            row[i] = c.value(f)
+        }
+        return row, true, nil
+    }
+
+    if len(rec) != len(c.header) {
+        c.close()
+        return nil, false, fmt.Errorf("record on line %d has %d fields, expected %d", c.p.recLine, len(rec), len(c.header))
+    }
+
+    if c.target != nil {
+        row, err := c.target.buildFrom(func(i int) (string, bool) {
+            return rec[i], rec[i] != ""
+        })
+        if err != nil {
+            c.close()
+            return nil, false, fmt.Errorf("line %d: %v", c.p.recLine, err)
+        }
+        return row, true, nil
+    }
+
+    row := make(map[string]any, len(rec))
+    for i, f := range rec {
+        row[c.header[i]] = c.value(f)
+    }
+    return row, true, nil
+}
+
+func (c *csvReader) value(f string) any {
+    if c.p.opts.infer {
+        return inferScalar(f)
+    }
+    return f
+}
+
+// loopNext allows a csv reader to be consumed directly by FOREACH.
+func (c *csvReader) loopNext() (any, bool, error) {
+    return c.next()
+}
+
+// loopClose closes the reader, and any file it owns, when FOREACH ends.
+func (c *csvReader) loopClose() {
+    c.close()
+}
+
+// csvEncode writes data (array of maps, structs or arrays) as delimited text.
+func csvEncode(w io.Writer, data any, opts csvOptions) (int, error) {
+    var rows []any
+    switch d := data.(type) {
+    case []any:
+        rows = d
+    case []map[string]any:
+        for _, m := range d {
+            rows = append(rows, m)
+        }
+    default:
+        rv := reflect.ValueOf(data)
+        if rv.Kind() != reflect.Slice {
+            return 0, fmt.Errorf("csv data must be an array (got %T)", data)
+        }
+        for i := 0; i < rv.Len(); i++ {
+            rows = append(rows, rv.Index(i).Interface())
+        }
+    }
+
+    bw := bufio.NewWriter(w)
+    eol := "\n"
+    if opts.crlf {
+        eol = "\r\n"
+    }
+    writeRecord := func(fields []string) error {
+        for i, f := range fields {
+            if i > 0 {
+                bw.WriteRune(opts.delim)
+            }
+            bw.WriteString(csvQuote(f, opts))
+        }
+        _, err := bw.WriteString(eol)
+        return err
+    }
+
+    // columns come from the first row that is not nil
+    var first any
+    for _, row := range rows {
+        if row != nil {
+            first = row
+            break
+        }
+    }
+    columns := opts.columns
+    if len(columns) == 0 && first != nil {
+        switch first := first.(type) {
+        case map[string]any:
+            for k := range first {
+                columns = append(columns, k)
+            }
+            sort.Strings(columns)
+        default:
+            if reflect.TypeOf(first).Kind() == reflect.Struct {
+                rt := reflect.TypeOf(first)
+                for i := 0; i < rt.NumField(); i++ {
+                    columns = append(columns, rt.Field(i).Name)
+                }
+            }
+        }
+    }
+    if opts.header && len(columns) > 0 {
+        if err := writeRecord(columns); err != nil {
+            return 0, err
+        }
+    }
+
+    for n, row := range rows {
+        var fields []string
+        switch r := row.(type) {
+        case nil:
+            // a nil row is written as an empty record
+            fields = make([]string, len(columns))
+        case map[string]any:
+            if len(columns) == 0 {
+                return 0, errors.New("csv map rows need a header; use .columns")
+            }
+            for _, col := range columns {
+                if v, found := r[col]; found && v != nil {
+                    fields = append(fields, GetAsString(v))
+                } else {
+                    fields = append(fields, "")
+                }
+            }
+        case []any:
+            for _, v := range r {
+                if v == nil {
+                    fields = append(fields, "")
+                } else {
+                    fields = append(fields, GetAsString(v))
+                }
+            }
+        case []string:
+            fields = r
+        default:
+            rv := reflect.ValueOf(row)
+            switch rv.Kind() {
+            case reflect.Struct:
+                m := s2m(row)
+                for _, col := range columns {
+                    if v, found := m[col]; found {
+                        fields = append(fields, GetAsString(v))
+                    } else {
+                        fields = append(fields, "")
+                    }
+                }
+            case reflect.Slice:
+                for i := 0; i < rv.Len(); i++ {
+                    fields = append(fields, GetAsString(rv.Index(i).Interface()))
+                }
+            default:
+                return 0, fmt.Errorf("csv row %d has unsupported type %T", n, row)
+            }
+        }
+        if err := writeRecord(fields); err != nil {
+            return 0, err
+        }
+    }
+    return len(rows), bw.Flush()
+}
+
+// csvQuote quotes a field when it contains the delimiter, quote, line breaks
+// or surrounding spaces, or when every field is to be quoted.
+func csvQuote(f string, opts csvOptions) string {
+    q := string(opts.quote)
+    if opts.alwaysQuote || f != strings.TrimSpace(f) ||
+        strings.ContainsRune(f, opts.delim) || strings.Contains(f, q) || strings.ContainsAny(f, "\r\n") {
+        return q + strings.ReplaceAll(f, q, q+q) + q
+    }
+    return f
+}
+
+// csvReadAll reads every remaining record from src.
+func csvReadAll(fname, ns string, src io.Reader, opts csvOptions) ([]any, error) {
+    c, err := newCsvReader(ns, src, opts)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", fname, err)
+    }
+    result := []any{}
+    for {
+        row, more, err := c.next()
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", fname, err)
+        }
+        if !more {
+            break
+        }
+        result = append(result, row)
+    }
+    return result, nil
+}
+
+func buildCsvLib() {
+
+    features["csv"] = Feature{version: 1, category: "data"}
+    categories["csv"] = []string{"csv_read", "csv_open", "csv_fetch", "csv_close", "csv_write", "csv_string"}
+
+    slhelp["csv_read"] = LibHelp{in: "path_or_string[,options]", out: "[]any",
+        action: "Reads CSV from the file [#i1]path[#i0], or parses [#i1]string[#i0] when it contains a line break. With a header line,\n[#SOL]" +
+            "records are returned as maps (or structs with [#i1].into[#i0]); with [#i1].header false[#i0] as arrays. Options: map(.delimiter \",\",\n[#SOL]" +
+            ".quote \"\\\"\", .comment \"#\", .tsv true, .header true, .columns [names], .infer false, .trim false, .skip 0, .limit n,\n[#SOL]" +
+            ".into \"struct_name\", .source \"file\"|\"text\" to override the path or text choice). A missing file is an error."}
+    stdlib["csv_read"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_read", args, 2,
+            "2", "string", "map",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        var optArg any
+        if len(args) == 2 {
+            optArg = args[1]
+        }
+        opts, err := parseCsvOptions("csv_read", optArg)
+        if err != nil {
+            return nil, err
+        }
+        arg := args[0].(string)
+        if opts.source == "text" || (opts.source == "" && strings.ContainsAny(arg, "\r\n")) {
+            return csvReadAll("csv_read", ns, strings.NewReader(arg), opts)
+        }
+        f, err := os.Open(arg)
+        if err != nil {
+            return nil, fmt.Errorf("csv_read: %v", err)
+        }
+        defer f.Close()
+        return csvReadAll("csv_read", ns, f, opts)
+    }
+
+    slhelp["csv_open"] = LibHelp{in: "path[,options]", out: "reader",
+        action: "Opens the CSV file [#i1]path[#i0] for streaming. Records are read with csv_fetch() or by iterating the\n[#SOL]" +
+            "[#i1]reader[#i0] with FOREACH. Options are as for csv_read()."}
+    stdlib["csv_open"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_open", args, 2,
+            "2", "string", "map",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        var optArg any
+        if len(args) == 2 {
+            optArg = args[1]
+        }
+        opts, err := parseCsvOptions("csv_open", optArg)
+        if err != nil {
+            return nil, err
+        }
+        f, err := os.Open(args[0].(string))
+        if err != nil {
+            return nil, fmt.Errorf("csv_open: %v", err)
+        }
+        c, err := newCsvReader(ns, f, opts)
+        if err != nil {
+            f.Close()
+            return nil, fmt.Errorf("csv_open: %v", err)
+        }
+        c.file = f
+        return c, nil
+    }
+
+    slhelp["csv_fetch"] = LibHelp{in: "reader[,n]", out: "[]any",
+        action: "Returns an array of up to [#i1]n[#i0] (default 1) further records from [#i1]reader[#i0]. An empty array indicates the end of input."}
+    stdlib["csv_fetch"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_fetch", args, 2,
+            "2", "*main.csvReader", "int",
+            "1", "*main.csvReader"); !ok {
+            return nil, err
+        }
+        c := args[0].(*csvReader)
+        n := 1
+        if len(args) == 2 {
+            n = args[1].(int)
+            if n < 1 {
+                return nil, errors.New("csv_fetch record count must be positive")
+            }
+        }
+        result := make([]any, 0, n)
+        for len(result) < n {
+            row, more, err := c.next()
+            if err != nil {
+                return nil, fmt.Errorf("csv_fetch: %v", err)
+            }
+            if !more {
+                break
+            }
+            result = append(result, row)
+        }
+        return result, nil
+    }
+
+    slhelp["csv_close"] = LibHelp{in: "reader", out: "", action: "Closes a CSV [#i1]reader[#i0] opened with csv_open()."}
+    stdlib["csv_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_close", args, 1, "1", "*main.csvReader"); !ok {
+            return nil, err
+        }
+        return nil, args[0].(*csvReader).close()
+    }
+
+    slhelp["csv_write"] = LibHelp{in: "path,data[,options]", out: "int",
+        action: "Writes [#i1]data[#i0] (an array of maps, structs or arrays) to the file [#i1]path[#i0] as CSV and returns the record count.\n[#SOL]" +
+            "Fields are quoted where needed. Options: map(.delimiter \",\", .quote \"\\\"\", .tsv true, .header true, .columns [order],\n[#SOL]" +
+            ".always_quote false, .crlf false). Map columns default to sorted key order."}
+    stdlib["csv_write"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_write", args, 2,
+            "3", "string", "any", "map",
+            "2", "string", "any"); !ok {
+            return nil, err
+        }
+        var optArg any
+        if len(args) == 3 {
+            optArg = args[2]
+        }
+        opts, err := parseCsvOptions("csv_write", optArg)
+        if err != nil {
+            return nil, err
+        }
+        f, err := os.Create(args[0].(string))
+        if err != nil {
+            return nil, fmt.Errorf("csv_write: %v", err)
+        }
+        n, err := csvEncode(f, args[1], opts)
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+        if err != nil {
+            return nil, fmt.Errorf("csv_write: %v", err)
+        }
+        return n, nil
+    }
+
+    slhelp["csv_string"] = LibHelp{in: "data[,options]", out: "string",
+        action: "Returns [#i1]data[#i0] formatted as CSV text. Options are as for csv_write()."}
+    stdlib["csv_string"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("csv_string", args, 2,
+            "2", "any", "map",
+            "1", "any"); !ok {
+            return nil, err
+        }
+        var optArg any
+        if len(args) == 2 {
+            optArg = args[1]
+        }
+        opts, err := parseCsvOptions("csv_string", optArg)
+        if err != nil {
+            return nil, err
+        }
+        var sb strings.Builder
+        if _, err := csvEncode(&sb, args[0], opts); err != nil {
+            return nil, fmt.Errorf("csv_string: %v", err)
+        }
+        return sb.String(), nil
+    }
+}
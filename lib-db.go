@@ -3,28 +3,41 @@
 package main
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
     "errors"
     "fmt"
+    "math/big"
+    "net/url"
     "os"
+    "reflect"
     "strconv"
     "strings"
+    "sync"
+    "time"
 
     _ "github.com/go-sql-driver/mysql"
+    _ "github.com/lib/pq"
     "gopkg.in/yaml.v3"
 )
 
 func buildDbLib() {
 
     features["db"] = Feature{version: 1, category: "db"}
-    categories["db"] = []string{"db_init", "db_query", "db_close"} // ,"db_prepared_query"}
+    categories["db"] = []string{
+        "db_init", "db_open", "db_query", "db_close", "db_exec",
+        "db_prepare", "db_stmt_query", "db_stmt_exec", "db_stmt_close",
+        "db_begin", "db_commit", "db_rollback",
+        "db_cursor", "db_fetch", "db_cursor_close",
+        "db_migrate", "db_migrate_down", "db_migrate_status",
+    }
 
     // open a db connection
     slhelp["db_init"] = LibHelp{in: "string", out: "handle",
         action: "Returns a database connection [#i1]handle[#i0], with a default schema of [#i1]string[#i0] based on\n[#SOL]" +
             "inbound environmental variables. (ZA_DB_HOST, ZA_DB_ENGINE, ZA_DB_PORT, ZA_DB_USER, ZA_DB_PASS.)\n[#SOL]" +
-            "Only 'mysql' is currently supported as an engine type."}
+            "Supported engine types are 'mysql', 'postgres' and 'sqlite3'."}
     stdlib["db_init"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("db_init", args, 1, "1", "string"); !ok {
             return nil, err
@@ -50,9 +63,17 @@ func buildDbLib() {
             if !(ex_host || ex_port || ex_user || ex_pass) {
                 return nil, errors.New("Error: Missing DB details at startup.")
             }
-            dbh, err = sql.Open(dbeng, dbuser+":"+dbpass+"@tcp("+dbhost+":"+dbport+")/"+schema)
+            dbh, err = dbOpenDriver(dbeng, dbuser+":"+dbpass+"@tcp("+dbhost+":"+dbport+")/"+schema)
+        case "postgres", "postgresql", "pg":
+            if !(ex_host || ex_port || ex_user || ex_pass) {
+                return nil, errors.New("Error: Missing DB details at startup.")
+            }
+            dsn := url.URL{Scheme: "postgres", User: url.UserPassword(dbuser, dbpass), Host: dbhost + ":" + dbport, Path: "/" + schema}
+            dbh, err = dbOpenDriver("postgres", dsn.String())
         case "sqlite3":
-            dbh, err = sql.Open(dbeng, schema) // schema will be path or uri
+            dbh, err = dbOpenDriver(dbeng, schema) // schema will be path or uri
+        default:
+            return nil, fmt.Errorf("Error: unsupported DB engine '%s'.", dbeng)
         }
         if err != nil {
             return nil, err
@@ -62,109 +83,89 @@ func buildDbLib() {
 
     }
 
+    // open a db connection from an explicit dsn
+    slhelp["db_open"] = LibHelp{in: "driver,dsn[,options]", out: "handle",
+        action: "Returns a database connection [#i1]handle[#i0] for [#i1]driver[#i0] ('mysql', 'postgres' or 'sqlite3') using\n[#SOL]" +
+            "the connection string [#i1]dsn[#i0]. Options: map(.max_open 10, .max_idle 2, .max_lifetime 300,\n[#SOL]" +
+            ".max_idle_time 60, .ping true). Lifetimes are in seconds. With [#i1].ping[#i0] the connection is verified before return."}
+    stdlib["db_open"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_open", args, 2,
+            "3", "string", "string", "map",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+
+        driver, err := dbDriverName(args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+
+        dbh, err := dbOpenDriver(driver, args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("db_open failed: %v", err)
+        }
+
+        if len(args) == 3 {
+            if err = dbApplyPoolOptions(dbh, args[2].(map[string]any)); err != nil {
+                dbh.Close()
+                return nil, err
+            }
+        }
+
+        return dbh, nil
+    }
+
     // close a db connection
     slhelp["db_close"] = LibHelp{in: "handle", out: "", action: "Closes the database connection."}
     stdlib["db_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("db_close", args, 1, "1", "*sql.DB"); !ok {
             return nil, err
         }
+        dbDrivers.Delete(args[0])
         args[0].(*sql.DB).Close()
         return nil, nil
     }
 
-    slhelp["db_query"] = LibHelp{in: "handle,query[,options]", out: "string", action: `Database query with optional map configuration. Options: map(.params [values], .separator "|", .timeout 30, .fetch_size 1000, .limit 100, .format "string|json|csv|tsv|table|map|array|yaml|xml|jsonl")`}
+    slhelp["db_query"] = LibHelp{in: "handle,query[,options]", out: "string", action: `Database query with optional map configuration. [#i1]handle[#i0] may be a connection or a transaction. Options: map(.params [values], .separator "|", .timeout 30, .fetch_size 1000, .limit 100, .format "string|json|csv|tsv|table|map|array|yaml|xml|jsonl", .into "struct_name"). With .into, rows are returned as an array of instances of the named STRUCT, matching columns to fields by name.`}
     stdlib["db_query"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("db_query", args, 3,
-            "3", "*sql.DB", "string", "map",
-            "3", "*sql.DB", "string", "string",
-            "2", "*sql.DB", "string"); !ok {
+            "3", "any", "string", "map",
+            "3", "any", "string", "string",
+            "2", "any", "string"); !ok {
             return nil, err
         }
 
-        var q string
-        var dbh *sql.DB
-        var options map[string]interface{}
-
-        dbh = args[0].(*sql.DB)
-        q = args[1].(string)
+        dbh, err := dbQuerierArg("db_query", args[0])
+        if err != nil {
+            return nil, err
+        }
+        q := args[1].(string)
 
-        // Parse options if provided
+        var opts dbQueryOptions
         if len(args) == 3 {
-            switch v := args[2].(type) {
-            case map[string]interface{}:
-                options = v
-            case map[any]any:
-                options = make(map[string]interface{})
-                for key, val := range v {
-                    if ks, ok := key.(string); ok {
-                        options[ks] = val
-                    }
-                }
-            case string:
-                // Backward compatibility: treat string as separator
-                options = map[string]interface{}{"separator": v}
-            default:
-                return nil, errors.New("db_query third argument must be map (options) or string (separator)")
-            }
+            opts, err = parseDbQueryOptions("db_query", args[2])
+        } else {
+            opts, err = parseDbQueryOptions("db_query", nil)
         }
-
-        // Set defaults
-        fsep := "|"
-        limit := -1
-        format := "string" // Default format
-        var params []interface{}
-
-        // Extract options
-        if options != nil {
-            if sep, exists := options["separator"]; exists {
-                if sepStr, ok := sep.(string); ok {
-                    fsep = sepStr
-                }
-            }
-            if l, exists := options["limit"]; exists {
-                if lInt, ok := l.(int); ok {
-                    limit = lInt
-                } else if lFloat, ok := l.(float64); ok {
-                    limit = int(lFloat)
-                }
-            }
-            if f, exists := options["format"]; exists {
-                if fStr, ok := f.(string); ok {
-                    // Validate format option
-                    validFormats := []string{"string", "json", "csv", "tsv", "table", "map", "array", "yaml", "xml", "jsonl"}
-                    valid := false
-                    for _, vf := range validFormats {
-                        if fStr == vf {
-                            valid = true
-                            break
-                        }
-                    }
-                    if !valid {
-                        return nil, fmt.Errorf("invalid format '%s'. Valid formats: %v", fStr, validFormats)
-                    }
-                    format = fStr
-                }
-            }
-            if p, exists := options["params"]; exists {
-                if pSlice, ok := p.([]interface{}); ok {
-                    params = pSlice
-                } else if pArray, ok := p.([]any); ok {
-                    params = make([]interface{}, len(pArray))
-                    for i, v := range pArray {
-                        params[i] = v
-                    }
-                }
+        if err != nil {
+            return nil, err
+        }
+        if opts.into != "" {
+            if opts.target, err = resolveStructTarget(ns, opts.into); err != nil {
+                return nil, err
             }
         }
 
         // Test connection
-        if err := dbh.Ping(); err != nil {
-            return nil, fmt.Errorf("database connection failed: %v", err)
+        if db, ok := dbh.(*sql.DB); ok {
+            if err := db.Ping(); err != nil {
+                return nil, fmt.Errorf("database connection failed: %v", err)
+            }
         }
 
         // Execute query with or without parameters
         var rows *sql.Rows
-        if len(params) > 0 {
+        if len(opts.params) > 0 {
             // Use prepared statement
             stmt, err := dbh.Prepare(q)
             if err != nil {
@@ -172,7 +173,7 @@ func buildDbLib() {
             }
             defer stmt.Close()
 
-            rows, err = stmt.Query(params...)
+            rows, err = stmt.Query(opts.params...)
             if err != nil {
                 return nil, fmt.Errorf("failed to execute prepared statement: %v", err)
             }
@@ -185,44 +186,826 @@ func buildDbLib() {
         }
         defer rows.Close()
 
-        // Get column information
-        _, err = rows.ColumnTypes()
+        return formatRows(rows, opts)
+    }
+
+    // statements which do not return rows
+    slhelp["db_exec"] = LibHelp{in: "handle,statement[,params]", out: "map",
+        action: "Executes [#i1]statement[#i0] (with optional [#i1]params[#i0] array) against a connection or transaction [#i1]handle[#i0].\n[#SOL]" +
+            "Returns map(.rows_affected, .last_insert_id). [#i1].last_insert_id[#i0] is -1 where the driver does not support it."}
+    stdlib["db_exec"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_exec", args, 2,
+            "3", "any", "string", "[]any",
+            "2", "any", "string"); !ok {
+            return nil, err
+        }
+        dbh, err := dbQuerierArg("db_exec", args[0])
+        if err != nil {
+            return nil, err
+        }
+        var params []any
+        if len(args) == 3 {
+            params = args[2].([]any)
+        }
+        res, err := dbh.Exec(args[1].(string), params...)
+        if err != nil {
+            return nil, fmt.Errorf("failed to execute statement: %v", err)
+        }
+        return execResultMap(res), nil
+    }
+
+    // prepared statements
+    slhelp["db_prepare"] = LibHelp{in: "handle,query", out: "statement",
+        action: "Prepares [#i1]query[#i0] on a connection or transaction [#i1]handle[#i0] and returns a [#i1]statement[#i0] handle\n[#SOL]" +
+            "for use with db_stmt_query(), db_stmt_exec() and db_stmt_close()."}
+    stdlib["db_prepare"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_prepare", args, 1, "2", "any", "string"); !ok {
+            return nil, err
+        }
+        dbh, err := dbQuerierArg("db_prepare", args[0])
+        if err != nil {
+            return nil, err
+        }
+        stmt, err := dbh.Prepare(args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("failed to prepare statement: %v", err)
+        }
+        return stmt, nil
+    }
+
+    slhelp["db_stmt_query"] = LibHelp{in: "statement[,params[,options]]", out: "string",
+        action: "Runs a prepared [#i1]statement[#i0] with the optional [#i1]params[#i0] array. [#i1]options[#i0] are as for db_query(), except .params."}
+    stdlib["db_stmt_query"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_stmt_query", args, 3,
+            "3", "*sql.Stmt", "[]any", "map",
+            "2", "*sql.Stmt", "[]any",
+            "1", "*sql.Stmt"); !ok {
+            return nil, err
+        }
+        var params []any
+        if len(args) > 1 {
+            params = args[1].([]any)
+        }
+        var opts dbQueryOptions
+        if len(args) == 3 {
+            opts, err = parseDbQueryOptions("db_stmt_query", args[2])
+        } else {
+            opts, err = parseDbQueryOptions("db_stmt_query", nil)
+        }
+        if err != nil {
+            return nil, err
+        }
+        if opts.into != "" {
+            if opts.target, err = resolveStructTarget(ns, opts.into); err != nil {
+                return nil, err
+            }
+        }
+        rows, err := args[0].(*sql.Stmt).Query(params...)
+        if err != nil {
+            return nil, fmt.Errorf("failed to execute prepared statement: %v", err)
+        }
+        defer rows.Close()
+        return formatRows(rows, opts)
+    }
+
+    slhelp["db_stmt_exec"] = LibHelp{in: "statement[,params]", out: "map",
+        action: "Executes a prepared [#i1]statement[#i0] which returns no rows. Result is as for db_exec()."}
+    stdlib["db_stmt_exec"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_stmt_exec", args, 2,
+            "2", "*sql.Stmt", "[]any",
+            "1", "*sql.Stmt"); !ok {
+            return nil, err
+        }
+        var params []any
+        if len(args) == 2 {
+            params = args[1].([]any)
+        }
+        res, err := args[0].(*sql.Stmt).Exec(params...)
         if err != nil {
-            return nil, fmt.Errorf("failed to get column types: %v", err)
+            return nil, fmt.Errorf("failed to execute prepared statement: %v", err)
         }
+        return execResultMap(res), nil
+    }
 
-        // Get column names
-        columnNames, err := rows.Columns()
+    slhelp["db_stmt_close"] = LibHelp{in: "statement", out: "", action: "Releases a prepared [#i1]statement[#i0]."}
+    stdlib["db_stmt_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_stmt_close", args, 1, "1", "*sql.Stmt"); !ok {
+            return nil, err
+        }
+        return nil, args[0].(*sql.Stmt).Close()
+    }
+
+    // transactions
+    slhelp["db_begin"] = LibHelp{in: "handle[,options]", out: "transaction",
+        action: "Starts a transaction on connection [#i1]handle[#i0]. The returned [#i1]transaction[#i0] handle may be used in place of\n[#SOL]" +
+            "a connection handle with db_query(), db_exec() and db_prepare(). Options: map(.isolation \"read_committed\", .read_only true)\n[#SOL]" +
+            "Isolation levels: default, read_uncommitted, read_committed, repeatable_read, serializable."}
+    stdlib["db_begin"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_begin", args, 2,
+            "2", "*sql.DB", "map",
+            "1", "*sql.DB"); !ok {
+            return nil, err
+        }
+        txo := &sql.TxOptions{}
+        if len(args) == 2 {
+            for k, v := range args[1].(map[string]any) {
+                switch k {
+                case "isolation":
+                    s, ok := v.(string)
+                    if !ok {
+                        return nil, errors.New("db_begin option 'isolation' must be a string")
+                    }
+                    if txo.Isolation, err = dbIsolationLevel(s); err != nil {
+                        return nil, err
+                    }
+                case "read_only":
+                    b, ok := v.(bool)
+                    if !ok {
+                        return nil, errors.New("db_begin option 'read_only' must be a boolean")
+                    }
+                    txo.ReadOnly = b
+                default:
+                    return nil, fmt.Errorf("unknown db_begin option '%s'", k)
+                }
+            }
+        }
+        tx, err := args[0].(*sql.DB).BeginTx(context.Background(), txo)
         if err != nil {
+            return nil, fmt.Errorf("failed to begin transaction: %v", err)
+        }
+        return tx, nil
+    }
+
+    // streaming cursors
+    slhelp["db_cursor"] = LibHelp{in: "handle,query[,params[,options]]", out: "cursor",
+        action: "Executes [#i1]query[#i0] on a connection or transaction [#i1]handle[#i0] and returns a [#i1]cursor[#i0] over the result rows.\n[#SOL]" +
+            "Rows are read on demand with db_fetch() or by iterating the cursor with FOREACH. Options: map(.format \"map|array\", .into \"struct_name\")\n[#SOL]" +
+            "The cursor is closed automatically once exhausted, or explicitly with db_cursor_close()."}
+    stdlib["db_cursor"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_cursor", args, 3,
+            "4", "any", "string", "[]any", "map",
+            "3", "any", "string", "[]any",
+            "2", "any", "string"); !ok {
+            return nil, err
+        }
+        dbh, err := dbQuerierArg("db_cursor", args[0])
+        if err != nil {
+            return nil, err
+        }
+        c := &dbCursor{format: "map"}
+        if len(args) == 4 {
+            for k, v := range args[3].(map[string]any) {
+                switch k {
+                case "format":
+                    f, ok := v.(string)
+                    if !ok || (f != "map" && f != "array") {
+                        return nil, errors.New("db_cursor option 'format' must be \"map\" or \"array\"")
+                    }
+                    c.format = f
+                case "into":
+                    name, ok := v.(string)
+                    if !ok {
+                        return nil, errors.New("db_cursor option 'into' must be a struct name")
+                    }
+                    if c.target, err = resolveStructTarget(ns, name); err != nil {
+                        return nil, err
+                    }
+                default:
+                    return nil, fmt.Errorf("unknown db_cursor option '%s'", k)
+                }
+            }
+        }
+
+        if len(args) > 2 && len(args[2].([]any)) > 0 {
+            c.stmt, err = dbh.Prepare(args[1].(string))
+            if err != nil {
+                return nil, fmt.Errorf("failed to prepare statement: %v", err)
+            }
+            c.rows, err = c.stmt.Query(args[2].([]any)...)
+        } else {
+            c.rows, err = dbh.Query(args[1].(string))
+        }
+        if err != nil {
+            if c.stmt != nil {
+                c.stmt.Close()
+            }
+            return nil, fmt.Errorf("failed to execute query: %v", err)
+        }
+
+        c.columns, err = c.rows.Columns()
+        if err != nil {
+            c.close()
             return nil, fmt.Errorf("failed to get column names: %v", err)
         }
+        c.vals = make([]any, len(c.columns))
+        for i := range c.vals {
+            c.vals[i] = new(sql.RawBytes)
+        }
+        if c.target != nil {
+            if err = c.target.bind(c.columns); err != nil {
+                c.close()
+                return nil, err
+            }
+        }
+        return c, nil
+    }
+
+    slhelp["db_fetch"] = LibHelp{in: "cursor[,n]", out: "[]rows",
+        action: "Returns an array of up to [#i1]n[#i0] (default 1) further rows from [#i1]cursor[#i0]. An empty array indicates the end of the results."}
+    stdlib["db_fetch"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_fetch", args, 2,
+            "2", "*main.dbCursor", "int",
+            "1", "*main.dbCursor"); !ok {
+            return nil, err
+        }
+        c := args[0].(*dbCursor)
+        n := 1
+        if len(args) == 2 {
+            n = args[1].(int)
+            if n < 1 {
+                return nil, errors.New("db_fetch row count must be positive")
+            }
+        }
+        result := make([]any, 0, n)
+        for len(result) < n {
+            row, more, err := c.next()
+            if err != nil {
+                return nil, err
+            }
+            if !more {
+                break
+            }
+            result = append(result, row)
+        }
+        return result, nil
+    }
+
+    slhelp["db_cursor_close"] = LibHelp{in: "cursor", out: "", action: "Closes [#i1]cursor[#i0] and releases its result set."}
+    stdlib["db_cursor_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_cursor_close", args, 1, "1", "*main.dbCursor"); !ok {
+            return nil, err
+        }
+        return nil, args[0].(*dbCursor).close()
+    }
+
+    // schema migrations
+    slhelp["db_migrate"] = LibHelp{in: "handle,dir[,options]", out: "map",
+        action: "Applies pending migrations from [#i1]dir[#i0] in version order, each inside its own transaction.\n[#SOL]" +
+            "MySQL commits implicitly on DDL (CREATE, ALTER, DROP ...), so there a failed migration may be left partly applied.\n[#SOL]" +
+            "Files are named [#i1]<version>_<name>.up.sql[#i0] (or [#i1]<version>_<name>.sql[#i0]) with an optional [#i1]<version>_<name>.down.sql[#i0].\n[#SOL]" +
+            "Options: map(.to version, .steps n, .table \"schema_migrations\"). Returns map(.applied [versions], .version current)."}
+    stdlib["db_migrate"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_migrate", args, 2,
+            "3", "*sql.DB", "string", "map",
+            "2", "*sql.DB", "string"); !ok {
+            return nil, err
+        }
+        opts, err := parseDbMigrateOptions("db_migrate", args)
+        if err != nil {
+            return nil, err
+        }
+        return migrateUp(args[0].(*sql.DB), args[1].(string), opts)
+    }
 
-        // Process results based on format
-        switch format {
-        case "json":
-            return formatJSON(rows, columnNames, limit)
-        case "csv":
-            return formatCSV(rows, columnNames, limit)
-        case "tsv":
-            return formatTSV(rows, columnNames, limit)
-        case "table":
-            return formatTable(rows, columnNames, limit)
-        case "map":
-            return formatMap(rows, columnNames, limit)
-        case "array":
-            return formatArray(rows, columnNames, limit)
-        case "yaml":
-            return formatYAML(rows, columnNames, limit)
-        case "xml":
-            return formatXML(rows, columnNames, limit)
-        case "jsonl":
-            return formatJSONL(rows, columnNames, limit)
-        case "string":
-            fallthrough
+    slhelp["db_migrate_down"] = LibHelp{in: "handle,dir[,options]", out: "map",
+        action: "Reverts applied migrations using their down files. Without options the latest migration is reverted.\n[#SOL]" +
+            "Options: map(.to version, .steps n, .table \"schema_migrations\"). [#i1].to 0[#i0] reverts everything.\n[#SOL]" +
+            "Returns map(.reverted [versions], .version current)."}
+    stdlib["db_migrate_down"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_migrate_down", args, 2,
+            "3", "*sql.DB", "string", "map",
+            "2", "*sql.DB", "string"); !ok {
+            return nil, err
+        }
+        opts, err := parseDbMigrateOptions("db_migrate_down", args)
+        if err != nil {
+            return nil, err
+        }
+        return migrateDown(args[0].(*sql.DB), args[1].(string), opts)
+    }
+
+    slhelp["db_migrate_status"] = LibHelp{in: "handle,dir[,options]", out: "[]map",
+        action: "Lists migrations from [#i1]dir[#i0] and the bookkeeping table. Each entry is\n[#SOL]" +
+            "map(.version, .name, .applied, .applied_at, .reversible, .missing). Options: map(.table \"schema_migrations\")."}
+    stdlib["db_migrate_status"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_migrate_status", args, 2,
+            "3", "*sql.DB", "string", "map",
+            "2", "*sql.DB", "string"); !ok {
+            return nil, err
+        }
+        opts, err := parseDbMigrateOptions("db_migrate_status", args)
+        if err != nil {
+            return nil, err
+        }
+        return migrateStatus(args[0].(*sql.DB), args[1].(string), opts)
+    }
+
+    slhelp["db_commit"] = LibHelp{in: "transaction", out: "", action: "Commits [#i1]transaction[#i0]."}
+    stdlib["db_commit"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_commit", args, 1, "1", "*sql.Tx"); !ok {
+            return nil, err
+        }
+        if err := args[0].(*sql.Tx).Commit(); err != nil {
+            return nil, fmt.Errorf("failed to commit transaction: %v", err)
+        }
+        return nil, nil
+    }
+
+    slhelp["db_rollback"] = LibHelp{in: "transaction", out: "", action: "Rolls back [#i1]transaction[#i0]."}
+    stdlib["db_rollback"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("db_rollback", args, 1, "1", "*sql.Tx"); !ok {
+            return nil, err
+        }
+        if err := args[0].(*sql.Tx).Rollback(); err != nil {
+            return nil, fmt.Errorf("failed to roll back transaction: %v", err)
+        }
+        return nil, nil
+    }
+}
+
+// dbCursor streams rows from an open result set, one row at a time.
+type dbCursor struct {
+    rows    *sql.Rows
+    stmt    *sql.Stmt // set when the cursor owns a prepared statement
+    columns []string
+    vals    []any
+    format  string          // "map" or "array"
+    target  *structTarget // set when rows are mapped onto a struct
+    fetched int
+    closed  bool
+}
+
+func (c *dbCursor) close() error {
+    if c.closed {
+        return nil
+    }
+    c.closed = true
+    err := c.rows.Close()
+    if c.stmt != nil {
+        c.stmt.Close()
+    }
+    return err
+}
+
+// next returns the following row, or false once the result set is exhausted.
+// The cursor closes itself on exhaustion.
+func (c *dbCursor) next() (any, bool, error) {
+    if c.closed {
+        return nil, false, nil
+    }
+    if !c.rows.Next() {
+        err := c.rows.Err()
+        c.close()
+        if err != nil {
+            return nil, false, fmt.Errorf("error iterating rows: %v", err)
+        }
+        return nil, false, nil
+    }
+    if err := c.rows.Scan(c.vals...); err != nil {
+        c.close()
+        return nil, false, fmt.Errorf("failed to scan row: %v", err)
+    }
+    c.fetched++
+
+    if c.target != nil {
+        row, err := c.target.build(c.vals)
+        if err != nil {
+            c.close()
+            return nil, false, err
+        }
+        return row, true, nil
+    }
+    if c.format == "array" {
+        row := make([]any, len(c.columns))
+        for i, val := range c.vals {
+            row[i] = dbTypedValue(*val.(*sql.RawBytes))
+        }
+        return row, true, nil
+    }
+    row := make(map[string]any, len(c.columns))
+    for i, val := range c.vals {
+        row[c.columns[i]] = dbTypedValue(*val.(*sql.RawBytes))
+    }
+    return row, true, nil
+}
+
+// loopNext allows a cursor to be consumed directly by FOREACH.
+func (c *dbCursor) loopNext() (any, bool, error) {
+    return c.next()
+}
+
+// loopClose releases the cursor, and its pooled connection, when FOREACH ends.
+func (c *dbCursor) loopClose() {
+    c.close()
+}
+
+// dbTypedValue converts a raw column value to int, float, bool or string,
+// as for the map and array output formats.
+func dbTypedValue(raw sql.RawBytes) any {
+    return inferScalar(string(raw))
+}
+
+// inferScalar converts text to int, float or bool where it parses as one,
+// otherwise the string is returned unchanged.
+func inferScalar(strVal string) any {
+    if intVal, err := strconv.Atoi(strVal); err == nil {
+        return intVal
+    } else if floatVal, err := strconv.ParseFloat(strVal, 64); err == nil {
+        return floatVal
+    } else if strVal == "true" || strVal == "false" {
+        return strVal == "true"
+    }
+    return strVal
+}
+
+// structTarget maps named columns (db results, csv headers) onto the fields of a Za STRUCT.
+type structTarget struct {
+    name     string
+    def      []any // structmaps layout: name, type, hasdefault, default (x4 per field)
+    colField []int // structmaps field offset for each result column
+    columns  []string
+}
+
+// resolveStructTarget finds the definition for the struct named by an .into option.
+func resolveStructTarget(ns string, name string) (*structTarget, error) {
+    candidates := []string{name}
+    if !strings.Contains(name, "::") {
+        candidates = []string{ns + "::" + name}
+        if resolved := uc_match_struct(name); resolved != "" {
+            candidates = append(candidates, resolved+"::"+name)
+        }
+        candidates = append(candidates, "main::"+name)
+    }
+    structmapslock.RLock()
+    defer structmapslock.RUnlock()
+    for _, c := range candidates {
+        if def, found := structmaps[c]; found {
+            return &structTarget{name: name, def: def}, nil
+        }
+    }
+    return nil, fmt.Errorf("unknown struct '%s' in .into option", name)
+}
+
+// bind matches result columns to struct fields by case-insensitive name.
+func (t *structTarget) bind(columns []string) error {
+    t.columns = columns
+    t.colField = make([]int, len(columns))
+    for i, col := range columns {
+        t.colField[i] = -1
+        for f := 0; f < len(t.def); f += 4 {
+            if strings.EqualFold(t.def[f].(string), col) {
+                t.colField[i] = f
+                break
+            }
+        }
+        if t.colField[i] == -1 {
+            return fmt.Errorf("column '%s' has no matching field in struct '%s'", col, t.name)
+        }
+    }
+    return nil
+}
+
+// build creates a struct instance from one scanned row. NULL columns leave
+// the field at its declared default.
+func (t *structTarget) build(vals []any) (any, error) {
+    return t.buildFrom(func(i int) (string, bool) {
+        raw := *vals[i].(*sql.RawBytes)
+        return string(raw), raw != nil
+    })
+}
+
+// buildFrom creates a struct instance, fetching each bound column's text with
+// value(). Columns reported as absent leave the field at its declared default.
+func (t *structTarget) buildFrom(value func(i int) (string, bool)) (any, error) {
+    sv := make([]any, len(t.def))
+    copy(sv, t.def)
+    for i := range t.columns {
+        raw, present := value(i)
+        if !present {
+            continue
+        }
+        f := t.colField[i]
+        fieldType := sv[f+1].(string)
+        v, err := coerceToField(raw, fieldType)
+        if err != nil {
+            return nil, fmt.Errorf("column '%s' value %q cannot be stored in %s field '%s' of struct '%s'",
+                t.columns[i], raw, fieldType, sv[f].(string), t.name)
+        }
+        sv[f+2] = true
+        sv[f+3] = v
+    }
+    var inst Variable
+    if err := fillStruct(&inst, sv, Typemap, false, []string{}); err != nil {
+        return nil, fmt.Errorf("cannot build struct '%s': %v", t.name, err)
+    }
+    return inst.IValue, nil
+}
+
+// coerceToField converts column text to the Za type named by fieldType.
+func coerceToField(raw string, fieldType string) (any, error) {
+    switch fieldType {
+    case "any", "mixed":
+        return inferScalar(raw), nil
+    case "string":
+        return raw, nil
+    case "bigi":
+        if _, ok := new(big.Int).SetString(strings.TrimSpace(raw), 10); !ok {
+            return nil, errors.New("not an integer")
+        }
+        return GetAsBigInt(strings.TrimSpace(raw)), nil
+    case "bigf":
+        if _, ok := new(big.Float).SetString(strings.TrimSpace(raw)); !ok {
+            return nil, errors.New("not a number")
+        }
+        return GetAsBigFloat(strings.TrimSpace(raw)), nil
+    }
+
+    typ, known := Typemap[fieldType]
+    if !known || typ == nil {
+        return nil, fmt.Errorf("unsupported field type %s", fieldType)
+    }
+    raw = strings.TrimSpace(raw)
+    switch typ.Kind() {
+    case reflect.Bool:
+        b, err := strconv.ParseBool(raw)
+        if err != nil {
+            return nil, err
+        }
+        return b, nil
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        // parsing at the field's width rejects values it cannot hold
+        n, err := strconv.ParseInt(raw, 10, typ.Bits())
+        if err != nil {
+            return nil, err
+        }
+        return reflect.ValueOf(n).Convert(typ).Interface(), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(raw, 10, typ.Bits())
+        if err != nil {
+            return nil, err
+        }
+        return reflect.ValueOf(n).Convert(typ).Interface(), nil
+    case reflect.Float32, reflect.Float64:
+        f, err := strconv.ParseFloat(raw, typ.Bits())
+        if err != nil {
+            return nil, err
+        }
+        return reflect.ValueOf(f).Convert(typ).Interface(), nil
+    }
+    return nil, fmt.Errorf("unsupported field type %s", fieldType)
+}
+
+// formatStructs renders rows as an array of struct instances.
+func formatStructs(rows *sql.Rows, columnNames []string, target *structTarget, limit int) (any, error) {
+    if err := target.bind(columnNames); err != nil {
+        return nil, err
+    }
+    result := []any{}
+
+    vals := make([]any, len(columnNames))
+    for i := range columnNames {
+        vals[i] = new(sql.RawBytes)
+    }
+
+    for rows.Next() {
+        if err := rows.Scan(vals...); err != nil {
+            return nil, fmt.Errorf("failed to scan row: %v", err)
+        }
+        row, err := target.build(vals)
+        if err != nil {
+            return nil, err
+        }
+        result = append(result, row)
+
+        if limit > 0 && len(result) >= limit {
+            break
+        }
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating rows: %v", err)
+    }
+
+    return result, nil
+}
+
+// dbQuerier is satisfied by both connection (*sql.DB) and transaction (*sql.Tx) handles.
+type dbQuerier interface {
+    Query(query string, args ...any) (*sql.Rows, error)
+    Exec(query string, args ...any) (sql.Result, error)
+    Prepare(query string) (*sql.Stmt, error)
+}
+
+func dbQuerierArg(fname string, v any) (dbQuerier, error) {
+    switch h := v.(type) {
+    case *sql.DB:
+        return h, nil
+    case *sql.Tx:
+        return h, nil
+    }
+    return nil, fmt.Errorf("%s expects a database or transaction handle (got %T)", fname, v)
+}
+
+func execResultMap(res sql.Result) map[string]any {
+    affected, err := res.RowsAffected()
+    if err != nil {
+        affected = -1
+    }
+    lastID, err := res.LastInsertId()
+    if err != nil {
+        lastID = -1
+    }
+    return map[string]any{"rows_affected": int(affected), "last_insert_id": int(lastID)}
+}
+
+func dbIsolationLevel(s string) (sql.IsolationLevel, error) {
+    switch strings.ToLower(s) {
+    case "default", "":
+        return sql.LevelDefault, nil
+    case "read_uncommitted":
+        return sql.LevelReadUncommitted, nil
+    case "read_committed":
+        return sql.LevelReadCommitted, nil
+    case "repeatable_read":
+        return sql.LevelRepeatableRead, nil
+    case "serializable":
+        return sql.LevelSerializable, nil
+    }
+    return sql.LevelDefault, fmt.Errorf("unknown isolation level '%s'", s)
+}
+
+// dbQueryOptions holds the parsed db_query style options map.
+type dbQueryOptions struct {
+    separator string
+    limit     int
+    format    string
+    params    []any
+    into      string         // name of a Za STRUCT to map rows onto
+    target    *structTarget // resolved form of into
+}
+
+func parseDbQueryOptions(fname string, arg any) (dbQueryOptions, error) {
+    // Set defaults
+    opts := dbQueryOptions{separator: "|", limit: -1, format: "string"}
+
+    var options map[string]any
+    switch v := arg.(type) {
+    case nil:
+        return opts, nil
+    case map[string]any:
+        options = v
+    case string:
+        // Backward compatibility: treat string as separator
+        opts.separator = v
+        return opts, nil
+    default:
+        return opts, fmt.Errorf("%s options must be map (options) or string (separator)", fname)
+    }
+
+    if sep, exists := options["separator"]; exists {
+        if sepStr, ok := sep.(string); ok {
+            opts.separator = sepStr
+        }
+    }
+    if l, exists := options["limit"]; exists {
+        if lInt, invalid := GetAsInt(l); !invalid {
+            opts.limit = lInt
+        }
+    }
+    if f, exists := options["format"]; exists {
+        if fStr, ok := f.(string); ok {
+            // Validate format option
+            validFormats := []string{"string", "json", "csv", "tsv", "table", "map", "array", "yaml", "xml", "jsonl"}
+            valid := false
+            for _, vf := range validFormats {
+                if fStr == vf {
+                    valid = true
+                    break
+                }
+            }
+            if !valid {
+                return opts, fmt.Errorf("invalid format '%s'. Valid formats: %v", fStr, validFormats)
+            }
+            opts.format = fStr
+        }
+    }
+    if p, exists := options["params"]; exists {
+        if pSlice, ok := p.([]any); ok {
+            opts.params = pSlice
+        }
+    }
+    if i, exists := options["into"]; exists {
+        iStr, ok := i.(string)
+        if !ok || iStr == "" {
+            return opts, fmt.Errorf("%s option 'into' must be a struct name", fname)
+        }
+        if _, hasFormat := options["format"]; hasFormat {
+            return opts, fmt.Errorf("%s options 'into' and 'format' cannot be combined", fname)
+        }
+        opts.into = iStr
+    }
+    return opts, nil
+}
+
+// formatRows renders a result set according to the requested output format.
+func formatRows(rows *sql.Rows, opts dbQueryOptions) (any, error) {
+    // Get column names
+    columnNames, err := rows.Columns()
+    if err != nil {
+        return nil, fmt.Errorf("failed to get column names: %v", err)
+    }
+
+    limit := opts.limit
+    if opts.target != nil {
+        return formatStructs(rows, columnNames, opts.target, limit)
+    }
+    switch opts.format {
+    case "json":
+        return formatJSON(rows, columnNames, limit)
+    case "csv":
+        return formatCSV(rows, columnNames, limit)
+    case "tsv":
+        return formatTSV(rows, columnNames, limit)
+    case "table":
+        return formatTable(rows, columnNames, limit)
+    case "map":
+        return formatMap(rows, columnNames, limit)
+    case "array":
+        return formatArray(rows, columnNames, limit)
+    case "yaml":
+        return formatYAML(rows, columnNames, limit)
+    case "xml":
+        return formatXML(rows, columnNames, limit)
+    case "jsonl":
+        return formatJSONL(rows, columnNames, limit)
+    case "string":
+        fallthrough
+    default:
+        return formatString(rows, opts.separator, limit)
+    }
+}
+
+// dbDrivers records the driver each handle was opened with, for SQL which
+// differs between engines.
+var dbDrivers sync.Map // *sql.DB -> driver name
+
+// dbOpenDriver opens a handle and records its driver in dbDrivers.
+func dbOpenDriver(driver, dsn string) (*sql.DB, error) {
+    dbh, err := sql.Open(driver, dsn)
+    if err != nil {
+        return nil, err
+    }
+    dbDrivers.Store(dbh, driver)
+    return dbh, nil
+}
+
+// dbDriverName maps a user supplied engine name onto a registered database/sql driver.
+func dbDriverName(engine string) (string, error) {
+    switch strings.ToLower(engine) {
+    case "mysql", "mariadb":
+        return "mysql", nil
+    case "postgres", "postgresql", "pg":
+        return "postgres", nil
+    case "sqlite3", "sqlite":
+        return "sqlite3", nil
+    }
+    return "", fmt.Errorf("unsupported database driver '%s' (expected mysql, postgres or sqlite3)", engine)
+}
+
+// dbApplyPoolOptions sets connection pool limits on dbh from a db_open options map.
+func dbApplyPoolOptions(dbh *sql.DB, options map[string]any) error {
+    for k, v := range options {
+        switch k {
+        case "max_open", "max_idle", "max_lifetime", "max_idle_time":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 0 {
+                return fmt.Errorf("db_open option '%s' must be a non-negative number", k)
+            }
+            switch k {
+            case "max_open":
+                dbh.SetMaxOpenConns(n)
+            case "max_idle":
+                dbh.SetMaxIdleConns(n)
+            case "max_lifetime":
+                dbh.SetConnMaxLifetime(time.Duration(n) * time.Second)
+            case "max_idle_time":
+                dbh.SetConnMaxIdleTime(time.Duration(n) * time.Second)
+            }
+        case "ping":
+            b, ok := v.(bool)
+            if !ok {
+                return errors.New("db_open option 'ping' must be a boolean")
+            }
+            if b {
+                if err := dbh.Ping(); err != nil {
+                    return fmt.Errorf("database connection failed: %v", err)
+                }
+            }
         default:
-            return formatString(rows, fsep, limit)
+            return fmt.Errorf("unknown db_open option '%s'", k)
         }
     }
+    return nil
 }
 
 // Helper functions for different output formats
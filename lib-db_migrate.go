@@ -0,0 +1,394 @@
+//go:build !test
+
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schema migrations for the db library.
+//
+// A migration directory holds files named <version>_<description>.up.sql and,
+// optionally, <version>_<description>.down.sql. A plain <version>_<description>.sql
+// file is treated as an up-only migration. Applied versions are recorded in a
+// bookkeeping table (schema_migrations by default) and each migration runs
+// inside its own transaction.
+
+type dbMigration struct {
+    version  int64
+    name     string
+    upFile   string
+    downFile string // empty if the migration is irreversible
+}
+
+type dbMigrateOptions struct {
+    table string
+    to    int64
+    hasTo bool
+    steps int
+}
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+?)(\.up|\.down)?\.sql$`)
+var sqlIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func parseDbMigrateOptions(fname string, args []any) (dbMigrateOptions, error) {
+    opts := dbMigrateOptions{table: "schema_migrations"}
+    if len(args) < 3 {
+        return opts, nil
+    }
+    for k, v := range args[2].(map[string]any) {
+        switch k {
+        case "table":
+            t, ok := v.(string)
+            if !ok || !sqlIdentRe.MatchString(t) {
+                return opts, fmt.Errorf("%s option 'table' must be a plain identifier", fname)
+            }
+            opts.table = t
+        case "to":
+            n, invalid := GetAsInt64(v)
+            if invalid || n < 0 {
+                return opts, fmt.Errorf("%s option 'to' must be a non-negative version number", fname)
+            }
+            opts.to = n
+            opts.hasTo = true
+        case "steps":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 1 {
+                return opts, fmt.Errorf("%s option 'steps' must be a positive number", fname)
+            }
+            opts.steps = n
+        default:
+            return opts, fmt.Errorf("unknown %s option '%s'", fname, k)
+        }
+    }
+    return opts, nil
+}
+
+// loadMigrations reads and orders the migration files in dir.
+func loadMigrations(dir string) ([]dbMigration, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, fmt.Errorf("cannot read migration directory: %v", err)
+    }
+    byVersion := make(map[int64]*dbMigration)
+    for _, e := range entries {
+        if e.IsDir() {
+            continue
+        }
+        m := migrationFileRe.FindStringSubmatch(e.Name())
+        if m == nil {
+            continue
+        }
+        version, err := strconv.ParseInt(m[1], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid migration version in '%s'", e.Name())
+        }
+        mig, found := byVersion[version]
+        if !found {
+            mig = &dbMigration{version: version, name: m[2]}
+            byVersion[version] = mig
+        } else if mig.name != m[2] {
+            return nil, fmt.Errorf("migration version %d is used by both '%s' and '%s'", version, mig.name, m[2])
+        }
+        path := filepath.Join(dir, e.Name())
+        if m[3] == ".down" {
+            mig.downFile = path
+        } else {
+            if mig.upFile != "" {
+                return nil, fmt.Errorf("migration version %d has more than one up file", version)
+            }
+            mig.upFile = path
+        }
+    }
+
+    migrations := make([]dbMigration, 0, len(byVersion))
+    for _, mig := range byVersion {
+        if mig.upFile == "" {
+            return nil, fmt.Errorf("migration version %d has a down file but no up file", mig.version)
+        }
+        migrations = append(migrations, *mig)
+    }
+    sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+    return migrations, nil
+}
+
+// dbPlaceholder returns the bind parameter marker for position n (1-based).
+func dbPlaceholder(dbh *sql.DB, n int) string {
+    if driver, _ := dbDrivers.Load(dbh); driver == "postgres" {
+        return "$" + strconv.Itoa(n)
+    }
+    return "?"
+}
+
+func ensureMigrationTable(dbh *sql.DB, table string) error {
+    _, err := dbh.Exec("CREATE TABLE IF NOT EXISTS " + table +
+        " (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at VARCHAR(32) NOT NULL)")
+    if err != nil {
+        return fmt.Errorf("cannot create migration table '%s': %v", table, err)
+    }
+    return nil
+}
+
+// appliedMigrations returns applied version -> applied_at from the bookkeeping table.
+func appliedMigrations(dbh *sql.DB, table string) (map[int64]string, error) {
+    rows, err := dbh.Query("SELECT version, applied_at FROM " + table)
+    if err != nil {
+        return nil, fmt.Errorf("cannot read migration table '%s': %v", table, err)
+    }
+    defer rows.Close()
+    applied := make(map[int64]string)
+    for rows.Next() {
+        var v int64
+        var at string
+        if err := rows.Scan(&v, &at); err != nil {
+            return nil, fmt.Errorf("cannot read migration table '%s': %v", table, err)
+        }
+        applied[v] = at
+    }
+    return applied, rows.Err()
+}
+
+func currentMigrationVersion(applied map[int64]string) int64 {
+    var current int64
+    for v := range applied {
+        if v > current {
+            current = v
+        }
+    }
+    return current
+}
+
+// splitSQLStatements splits a script on semicolons which are outside of
+// quoted strings and comments. Empty statements are dropped.
+func splitSQLStatements(script string) []string {
+    var stmts []string
+    var cur strings.Builder
+    var quote rune
+    lineComment, blockComment := false, false
+    rs := []rune(script)
+    for i := 0; i < len(rs); i++ {
+        c := rs[i]
+        var next rune
+        if i+1 < len(rs) {
+            next = rs[i+1]
+        }
+        switch {
+        case lineComment:
+            if c == '\n' {
+                lineComment = false
+            }
+        case blockComment:
+            if c == '*' && next == '/' {
+                blockComment = false
+                cur.WriteRune(c)
+                i++
+                c = next
+            }
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '\'' || c == '"' || c == '`':
+            quote = c
+        case c == '-' && next == '-':
+            lineComment = true
+        case c == '/' && next == '*':
+            blockComment = true
+        case c == ';':
+            if s := strings.TrimSpace(cur.String()); s != "" {
+                stmts = append(stmts, s)
+            }
+            cur.Reset()
+            continue
+        }
+        cur.WriteRune(c)
+    }
+    if s := strings.TrimSpace(cur.String()); s != "" && !onlySQLComments(s) {
+        stmts = append(stmts, s)
+    }
+    return stmts
+}
+
+func onlySQLComments(s string) bool {
+    for _, line := range strings.Split(s, "\n") {
+        line = strings.TrimSpace(line)
+        if line != "" && !strings.HasPrefix(line, "--") {
+            return false
+        }
+    }
+    return true
+}
+
+// runMigration executes one migration file and updates the bookkeeping table
+// inside a single transaction. MySQL commits implicitly on DDL, so there a
+// failure can leave earlier statements of the migration applied.
+func runMigration(dbh *sql.DB, table string, mig dbMigration, up bool) error {
+    path := mig.upFile
+    if !up {
+        path = mig.downFile
+    }
+    script, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("cannot read migration %d: %v", mig.version, err)
+    }
+
+    tx, err := dbh.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction for migration %d: %v", mig.version, err)
+    }
+    for _, stmt := range splitSQLStatements(string(script)) {
+        if _, err := tx.Exec(stmt); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %d (%s) failed: %v", mig.version, filepath.Base(path), err)
+        }
+    }
+    if up {
+        _, err = tx.Exec("INSERT INTO "+table+" (version, name, applied_at) VALUES ("+
+            dbPlaceholder(dbh, 1)+", "+dbPlaceholder(dbh, 2)+", "+dbPlaceholder(dbh, 3)+")",
+            mig.version, mig.name, time.Now().UTC().Format(time.RFC3339))
+    } else {
+        _, err = tx.Exec("DELETE FROM "+table+" WHERE version = "+dbPlaceholder(dbh, 1), mig.version)
+    }
+    if err != nil {
+        tx.Rollback()
+        return fmt.Errorf("cannot record migration %d: %v", mig.version, err)
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit migration %d: %v", mig.version, err)
+    }
+    return nil
+}
+
+// migrateUp applies pending migrations, optionally stopping at opts.to.
+func migrateUp(dbh *sql.DB, dir string, opts dbMigrateOptions) (map[string]any, error) {
+    migrations, err := loadMigrations(dir)
+    if err != nil {
+        return nil, err
+    }
+    if err := ensureMigrationTable(dbh, opts.table); err != nil {
+        return nil, err
+    }
+    applied, err := appliedMigrations(dbh, opts.table)
+    if err != nil {
+        return nil, err
+    }
+
+    done := []any{}
+    for _, mig := range migrations {
+        if opts.hasTo && mig.version > opts.to {
+            break
+        }
+        if _, isApplied := applied[mig.version]; isApplied {
+            continue
+        }
+        if opts.steps > 0 && len(done) >= opts.steps {
+            break
+        }
+        if err := runMigration(dbh, opts.table, mig, true); err != nil {
+            return nil, err
+        }
+        applied[mig.version] = ""
+        done = append(done, int(mig.version))
+    }
+    return map[string]any{"applied": done, "version": int(currentMigrationVersion(applied))}, nil
+}
+
+// migrateDown reverts applied migrations newer than opts.to, or the last
+// opts.steps migrations (default 1) when no target version is given.
+func migrateDown(dbh *sql.DB, dir string, opts dbMigrateOptions) (map[string]any, error) {
+    migrations, err := loadMigrations(dir)
+    if err != nil {
+        return nil, err
+    }
+    if err := ensureMigrationTable(dbh, opts.table); err != nil {
+        return nil, err
+    }
+    applied, err := appliedMigrations(dbh, opts.table)
+    if err != nil {
+        return nil, err
+    }
+
+    known := make(map[int64]dbMigration, len(migrations))
+    for _, mig := range migrations {
+        known[mig.version] = mig
+    }
+    var versions []int64
+    for v := range applied {
+        versions = append(versions, v)
+    }
+    sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+    steps := opts.steps
+    if !opts.hasTo && steps == 0 {
+        steps = 1
+    }
+
+    done := []any{}
+    for _, v := range versions {
+        if opts.hasTo && v <= opts.to {
+            break
+        }
+        if steps > 0 && len(done) >= steps {
+            break
+        }
+        mig, found := known[v]
+        if !found {
+            return nil, fmt.Errorf("applied migration %d has no file in '%s'", v, dir)
+        }
+        if mig.downFile == "" {
+            return nil, fmt.Errorf("migration %d (%s) has no down file", v, mig.name)
+        }
+        if err := runMigration(dbh, opts.table, mig, false); err != nil {
+            return nil, err
+        }
+        delete(applied, v)
+        done = append(done, int(v))
+    }
+    return map[string]any{"reverted": done, "version": int(currentMigrationVersion(applied))}, nil
+}
+
+// migrateStatus lists every known or applied migration in version order.
+func migrateStatus(dbh *sql.DB, dir string, opts dbMigrateOptions) ([]any, error) {
+    migrations, err := loadMigrations(dir)
+    if err != nil {
+        return nil, err
+    }
+    if err := ensureMigrationTable(dbh, opts.table); err != nil {
+        return nil, err
+    }
+    applied, err := appliedMigrations(dbh, opts.table)
+    if err != nil {
+        return nil, err
+    }
+
+    status := []any{}
+    seen := make(map[int64]bool)
+    for _, mig := range migrations {
+        at, isApplied := applied[mig.version]
+        seen[mig.version] = true
+        status = append(status, map[string]any{
+            "version": int(mig.version), "name": mig.name, "applied": isApplied,
+            "applied_at": at, "reversible": mig.downFile != "", "missing": false,
+        })
+    }
+    for v, at := range applied {
+        if !seen[v] {
+            status = append(status, map[string]any{
+                "version": int(v), "name": "", "applied": true,
+                "applied_at": at, "reversible": false, "missing": true,
+            })
+        }
+    }
+    sort.Slice(status, func(i, j int) bool {
+        return status[i].(map[string]any)["version"].(int) < status[j].(map[string]any)["version"].(int)
+    })
+    return status, nil
+}
@@ -1578,6 +1578,8 @@ func buildInternalLib() {
                     ch = hv.Result
                 case chan any:
                     ch = hv
+                case *poolTask:
+                    ch = hv.result
                 default:
                     continue
                 }
@@ -2431,6 +2433,68 @@ func buildInternalLib() {
 
 }
 
+// qualifyFnName resolves a function name passed to a library call against
+// the caller's namespace, and checks that the function exists.
+func qualifyFnName(ns, fn string) (string, error) {
+    if !str.Contains(fn, "::") {
+        fn = ns + "::" + fn
+    }
+    if _, found := fnlookup.lmget(fn); !found {
+        return "", fmt.Errorf("function %s not found", fn)
+    }
+    return fn, nil
+}
+
+// zaCallFn calls a Za function on behalf of library code (cron jobs, worker
+// pools, web handlers ...) and returns its first result.
+func zaCallFn(fn string, evalfs uint32, registrant uint8, args ...any) (any, error) {
+    return zaCallFnWith(fn, evalfs, registrant, nil, args...)
+}
+
+// zaCallFnWith is zaCallFn with a hook that sees the callee's function space
+// before the call starts; the func it returns runs once the call is over.
+func zaCallFnWith(fn string, evalfs uint32, registrant uint8, bind func(loc uint32) func(), args ...any) (any, error) {
+    ifn, found := fnlookup.lmget(fn)
+    if !found {
+        return nil, fmt.Errorf("function %s not found", fn)
+    }
+    loc, _ := GetNextFnSpace(true, fn+"@", call_s{prepared: true, base: ifn, caller: evalfs})
+    if bind != nil {
+        defer bind(loc)()
+    }
+
+    ctx := withProfilerContext(context.Background())
+    var ident = make([]Variable, identInitialSize)
+    atomic.StoreInt32(&calltable[loc].callLine, 0)
+    atomic.AddInt32(&concurrent_funcs, 1)
+    rcount, _, _, _, errVal := Call(ctx, MODE_NEW, &ident, loc, registrant, false, nil, "", []string{}, nil, args...)
+    atomic.AddInt32(&concurrent_funcs, -1)
+
+    calllock.Lock()
+    res := calltable[loc].retvals
+    calltable[loc].gcShyness = 40
+    calltable[loc].gc = true
+    calllock.Unlock()
+
+    if errVal != nil {
+        return nil, errVal
+    }
+    if rcount == 0 {
+        return nil, nil
+    }
+    if ra, ok := res.([]any); ok && len(ra) > 0 {
+        // an uncaught throw comes back as {EXCEPTION_THROWN, category, message, info}
+        if status, isInt := ra[0].(int); isInt && status == EXCEPTION_THROWN && len(ra) >= 3 {
+            if msg, _ := ra[2].(string); msg != "" {
+                return nil, fmt.Errorf("%v: %s", ra[1], msg)
+            }
+            return nil, fmt.Errorf("%v", ra[1])
+        }
+        return ra[0], nil
+    }
+    return res, nil
+}
+
 func getclktck() int {
 
     if runtime.GOOS == "windows" {
@@ -0,0 +1,688 @@
+//go:build !test
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net"
+    "net/mail"
+    "net/url"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+// JSON Schema validation for json_validate().
+//
+// Covers the commonly used parts of draft-07 and 2020-12: type, enum, const,
+// object keywords (properties, required, additionalProperties, patternProperties,
+// propertyNames, min/maxProperties, dependentRequired/dependencies), array
+// keywords (items, prefixItems, additionalItems, contains, min/maxContains,
+// min/maxItems, uniqueItems), string keywords (min/maxLength, pattern, format),
+// numeric bounds, multipleOf, allOf/anyOf/oneOf/not, if/then/else and $ref
+// within the schema document (JSON pointers and $anchor names).
+//
+// Errors are reported as "<path>: <message>" with paths written as $.a.b[0].
+
+const jsonSchemaMaxDepth = 200
+
+type jsonSchemaValidator struct {
+    root    any
+    anchors map[string]any
+    regexes map[string]*regexp.Regexp
+    errs    []string
+}
+
+func newJsonSchemaValidator(schema any) *jsonSchemaValidator {
+    v := &jsonSchemaValidator{root: schema, anchors: map[string]any{}, regexes: map[string]*regexp.Regexp{}}
+    v.collectAnchors(schema)
+    return v
+}
+
+// collectAnchors records $anchor (and draft-07 "#name" $id) targets.
+func (v *jsonSchemaValidator) collectAnchors(s any) {
+    switch t := s.(type) {
+    case map[string]any:
+        if a, ok := t["$anchor"].(string); ok {
+            v.anchors[a] = t
+        }
+        if id, ok := t["$id"].(string); ok && strings.HasPrefix(id, "#") && len(id) > 1 {
+            v.anchors[id[1:]] = t
+        }
+        for _, e := range t {
+            v.collectAnchors(e)
+        }
+    case []any:
+        for _, e := range t {
+            v.collectAnchors(e)
+        }
+    }
+}
+
+// jsonSchemaNormalise converts Za values into the JSON data model:
+// maps, []any, float64, string, bool and nil.
+func jsonSchemaNormalise(val any) any {
+    switch t := val.(type) {
+    case nil, string, bool, float64:
+        return t
+    case map[string]any:
+        m := make(map[string]any, len(t))
+        for k, e := range t {
+            m[k] = jsonSchemaNormalise(e)
+        }
+        return m
+    case []any:
+        a := make([]any, len(t))
+        for i, e := range t {
+            a[i] = jsonSchemaNormalise(e)
+        }
+        return a
+    case json.Number:
+        f, _ := t.Float64()
+        return f
+    }
+    rv := reflect.ValueOf(val)
+    switch rv.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+        reflect.Float32:
+        f, _ := GetAsFloat(val)
+        return f
+    case reflect.Slice, reflect.Array:
+        a := make([]any, rv.Len())
+        for i := range a {
+            a[i] = jsonSchemaNormalise(rv.Index(i).Interface())
+        }
+        return a
+    case reflect.Map:
+        m := make(map[string]any, rv.Len())
+        iter := rv.MapRange()
+        for iter.Next() {
+            m[fmt.Sprint(iter.Key().Interface())] = jsonSchemaNormalise(iter.Value().Interface())
+        }
+        return m
+    case reflect.Struct:
+        return jsonSchemaNormalise(s2m(val))
+    }
+    return fmt.Sprint(val)
+}
+
+func jsonPathKey(path, key string) string {
+    for i, r := range key {
+        if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+            return path + "[" + strconv.Quote(key) + "]"
+        }
+    }
+    if key == "" {
+        return path + `[""]`
+    }
+    return path + "." + key
+}
+
+func jsonTypeName(val any) string {
+    switch t := val.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return "boolean"
+    case string:
+        return "string"
+    case float64:
+        if t == math.Trunc(t) && !math.IsInf(t, 0) {
+            return "integer"
+        }
+        return "number"
+    case []any:
+        return "array"
+    case map[string]any:
+        return "object"
+    }
+    return fmt.Sprintf("%T", val)
+}
+
+func jsonTypeMatches(want string, val any) bool {
+    got := jsonTypeName(val)
+    return want == got || (want == "number" && got == "integer")
+}
+
+// jsonEqual compares two normalised JSON values.
+func jsonEqual(a, b any) bool {
+    return reflect.DeepEqual(a, b)
+}
+
+func jsonShow(val any) string {
+    b, err := json.Marshal(val)
+    if err != nil {
+        return fmt.Sprint(val)
+    }
+    if len(b) > 60 {
+        return string(b[:57]) + "..."
+    }
+    return string(b)
+}
+
+func (v *jsonSchemaValidator) fail(path, format string, args ...any) {
+    v.errs = append(v.errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *jsonSchemaValidator) regex(pattern string) (*regexp.Regexp, error) {
+    if re, found := v.regexes[pattern]; found {
+        return re, nil
+    }
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return nil, fmt.Errorf("invalid pattern %q in schema: %v", pattern, err)
+    }
+    v.regexes[pattern] = re
+    return re, nil
+}
+
+// resolveRef finds the target of a local $ref.
+func (v *jsonSchemaValidator) resolveRef(ref string) (any, error) {
+    if !strings.HasPrefix(ref, "#") {
+        return nil, fmt.Errorf("only local $ref values are supported (got %q)", ref)
+    }
+    frag, err := url.PathUnescape(ref[1:])
+    if err != nil {
+        return nil, fmt.Errorf("invalid $ref %q", ref)
+    }
+    if frag != "" && !strings.HasPrefix(frag, "/") {
+        if a, found := v.anchors[frag]; found {
+            return a, nil
+        }
+        return nil, fmt.Errorf("$ref %q: no such anchor", ref)
+    }
+    cur := v.root
+    if frag == "" {
+        return cur, nil
+    }
+    for _, tok := range strings.Split(frag[1:], "/") {
+        tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+        switch t := cur.(type) {
+        case map[string]any:
+            next, found := t[tok]
+            if !found {
+                return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+            }
+            cur = next
+        case []any:
+            i, err := strconv.Atoi(tok)
+            if err != nil || i < 0 || i >= len(t) {
+                return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+            }
+            cur = t[i]
+        default:
+            return nil, fmt.Errorf("$ref %q cannot be resolved", ref)
+        }
+    }
+    return cur, nil
+}
+
+// valid reports whether val matches schema without recording errors.
+func (v *jsonSchemaValidator) valid(schema, val any, path string, depth int) (bool, error) {
+    saved := v.errs
+    v.errs = nil
+    err := v.validate(schema, val, path, depth)
+    ok := len(v.errs) == 0
+    v.errs = saved
+    return ok, err
+}
+
+func schemaNumber(s map[string]any, key string) (float64, bool) {
+    raw, found := s[key]
+    if !found {
+        return 0, false
+    }
+    if _, isBool := raw.(bool); isBool {
+        return 0, false
+    }
+    f, invalid := GetAsFloat(raw)
+    return f, !invalid
+}
+
+func schemaInt(s map[string]any, key string) (int, bool) {
+    f, ok := schemaNumber(s, key)
+    return int(f), ok
+}
+
+func (v *jsonSchemaValidator) validate(schema, val any, path string, depth int) error {
+    if depth > jsonSchemaMaxDepth {
+        return fmt.Errorf("schema recursion too deep at %s", path)
+    }
+    var s map[string]any
+    switch t := schema.(type) {
+    case bool:
+        if !t {
+            v.fail(path, "no value is allowed here")
+        }
+        return nil
+    case map[string]any:
+        s = t
+    default:
+        return fmt.Errorf("schema at %s must be an object or boolean", path)
+    }
+
+    if ref, found := s["$ref"].(string); found {
+        target, err := v.resolveRef(ref)
+        if err != nil {
+            return err
+        }
+        if err := v.validate(target, val, path, depth+1); err != nil {
+            return err
+        }
+    }
+
+    // type
+    if t, found := s["type"]; found {
+        var types []string
+        switch tt := t.(type) {
+        case string:
+            types = []string{tt}
+        case []any:
+            for _, e := range tt {
+                types = append(types, GetAsString(e))
+            }
+        }
+        match := false
+        for _, want := range types {
+            if jsonTypeMatches(want, val) {
+                match = true
+                break
+            }
+        }
+        if !match {
+            v.fail(path, "expected %s, got %s", strings.Join(types, " or "), jsonTypeName(val))
+            // further keywords would only repeat the type mismatch
+            return nil
+        }
+    }
+
+    if e, found := s["enum"]; found {
+        opts, _ := jsonSchemaNormalise(e).([]any)
+        match := false
+        for _, o := range opts {
+            if jsonEqual(o, val) {
+                match = true
+                break
+            }
+        }
+        if !match {
+            var shown []string
+            for _, o := range opts {
+                shown = append(shown, jsonShow(o))
+            }
+            v.fail(path, "value %s is not one of [%s]", jsonShow(val), strings.Join(shown, ", "))
+        }
+    }
+    if c, found := s["const"]; found && !jsonEqual(jsonSchemaNormalise(c), val) {
+        v.fail(path, "value %s does not equal %s", jsonShow(val), jsonShow(c))
+    }
+
+    var err error
+    switch t := val.(type) {
+    case map[string]any:
+        err = v.validateObject(s, t, path, depth)
+    case []any:
+        err = v.validateArray(s, t, path, depth)
+    case string:
+        err = v.validateString(s, t, path)
+    case float64:
+        v.validateNumber(s, t, path)
+    }
+    if err != nil {
+        return err
+    }
+
+    // combinators
+    if all, found := s["allOf"].([]any); found {
+        for _, sub := range all {
+            if err := v.validate(sub, val, path, depth+1); err != nil {
+                return err
+            }
+        }
+    }
+    if any_, found := s["anyOf"].([]any); found {
+        matched := false
+        for _, sub := range any_ {
+            ok, err := v.valid(sub, val, path, depth+1)
+            if err != nil {
+                return err
+            }
+            if ok {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            v.fail(path, "value does not match any of the anyOf schemas")
+        }
+    }
+    if one, found := s["oneOf"].([]any); found {
+        count := 0
+        for _, sub := range one {
+            ok, err := v.valid(sub, val, path, depth+1)
+            if err != nil {
+                return err
+            }
+            if ok {
+                count++
+            }
+        }
+        if count != 1 {
+            v.fail(path, "value matches %d of the oneOf schemas, expected exactly 1", count)
+        }
+    }
+    if not, found := s["not"]; found {
+        ok, err := v.valid(not, val, path, depth+1)
+        if err != nil {
+            return err
+        }
+        if ok {
+            v.fail(path, "value must not match the 'not' schema")
+        }
+    }
+    if cond, found := s["if"]; found {
+        ok, err := v.valid(cond, val, path, depth+1)
+        if err != nil {
+            return err
+        }
+        branch, has := s["else"]
+        if ok {
+            branch, has = s["then"]
+        }
+        if has {
+            if err := v.validate(branch, val, path, depth+1); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (v *jsonSchemaValidator) validateObject(s map[string]any, obj map[string]any, path string, depth int) error {
+    keys := make([]string, 0, len(obj))
+    for k := range obj {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    if req, found := s["required"].([]any); found {
+        for _, r := range req {
+            name := GetAsString(r)
+            if _, present := obj[name]; !present {
+                v.fail(path, "missing required property '%s'", name)
+            }
+        }
+    }
+    if n, ok := schemaInt(s, "minProperties"); ok && len(obj) < n {
+        v.fail(path, "expected at least %d properties, got %d", n, len(obj))
+    }
+    if n, ok := schemaInt(s, "maxProperties"); ok && len(obj) > n {
+        v.fail(path, "expected at most %d properties, got %d", n, len(obj))
+    }
+
+    // dependentRequired (2020-12) and the array form of dependencies (draft-07)
+    for _, kw := range []string{"dependentRequired", "dependencies"} {
+        deps, _ := s[kw].(map[string]any)
+        for trigger, d := range deps {
+            if _, present := obj[trigger]; !present {
+                continue
+            }
+            switch dd := d.(type) {
+            case []any:
+                for _, r := range dd {
+                    name := GetAsString(r)
+                    if _, present := obj[name]; !present {
+                        v.fail(path, "property '%s' requires property '%s'", trigger, name)
+                    }
+                }
+            case map[string]any, bool:
+                if err := v.validate(dd, obj, path, depth+1); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    if deps, found := s["dependentSchemas"].(map[string]any); found {
+        for trigger, d := range deps {
+            if _, present := obj[trigger]; present {
+                if err := v.validate(d, obj, path, depth+1); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+
+    if names, found := s["propertyNames"]; found {
+        for _, k := range keys {
+            ok, err := v.valid(names, k, jsonPathKey(path, k), depth+1)
+            if err != nil {
+                return err
+            }
+            if !ok {
+                v.fail(jsonPathKey(path, k), "property name '%s' is not allowed", k)
+            }
+        }
+    }
+
+    props, _ := s["properties"].(map[string]any)
+    patterns, _ := s["patternProperties"].(map[string]any)
+    additional, hasAdditional := s["additionalProperties"]
+    patternKeys := make([]string, 0, len(patterns))
+    for p := range patterns {
+        patternKeys = append(patternKeys, p)
+    }
+    sort.Strings(patternKeys)
+
+    for _, k := range keys {
+        kpath := jsonPathKey(path, k)
+        matched := false
+        if sub, found := props[k]; found {
+            matched = true
+            if err := v.validate(sub, obj[k], kpath, depth+1); err != nil {
+                return err
+            }
+        }
+        for _, p := range patternKeys {
+            re, err := v.regex(p)
+            if err != nil {
+                return err
+            }
+            if re.MatchString(k) {
+                matched = true
+                if err := v.validate(patterns[p], obj[k], kpath, depth+1); err != nil {
+                    return err
+                }
+            }
+        }
+        if !matched && hasAdditional {
+            if b, isBool := additional.(bool); isBool {
+                if !b {
+                    v.fail(kpath, "additional property '%s' is not allowed", k)
+                }
+            } else if err := v.validate(additional, obj[k], kpath, depth+1); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (v *jsonSchemaValidator) validateArray(s map[string]any, arr []any, path string, depth int) error {
+    if n, ok := schemaInt(s, "minItems"); ok && len(arr) < n {
+        v.fail(path, "expected at least %d items, got %d", n, len(arr))
+    }
+    if n, ok := schemaInt(s, "maxItems"); ok && len(arr) > n {
+        v.fail(path, "expected at most %d items, got %d", n, len(arr))
+    }
+    if u, _ := s["uniqueItems"].(bool); u {
+    dup:
+        for i := range arr {
+            for j := i + 1; j < len(arr); j++ {
+                if jsonEqual(arr[i], arr[j]) {
+                    v.fail(path, "items %d and %d are equal; items must be unique", i, j)
+                    break dup
+                }
+            }
+        }
+    }
+
+    // tuple validation: prefixItems (2020-12) or an array-valued items (draft-07)
+    var prefix []any
+    var rest any
+    hasRest := false
+    if p, found := s["prefixItems"].([]any); found {
+        prefix = p
+        rest, hasRest = s["items"]
+    } else if p, found := s["items"].([]any); found {
+        prefix = p
+        rest, hasRest = s["additionalItems"]
+    } else {
+        rest, hasRest = s["items"]
+    }
+    for i, item := range arr {
+        ipath := fmt.Sprintf("%s[%d]", path, i)
+        var sub any
+        switch {
+        case i < len(prefix):
+            sub = prefix[i]
+        case hasRest:
+            sub = rest
+        default:
+            continue
+        }
+        if b, isBool := sub.(bool); isBool && !b && i >= len(prefix) {
+            v.fail(ipath, "no additional items are allowed beyond %d", len(prefix))
+            break
+        }
+        if err := v.validate(sub, item, ipath, depth+1); err != nil {
+            return err
+        }
+    }
+
+    if c, found := s["contains"]; found {
+        count := 0
+        for i, item := range arr {
+            ok, err := v.valid(c, item, fmt.Sprintf("%s[%d]", path, i), depth+1)
+            if err != nil {
+                return err
+            }
+            if ok {
+                count++
+            }
+        }
+        minC, hasMin := schemaInt(s, "minContains")
+        if !hasMin {
+            minC = 1
+        }
+        if count < minC {
+            v.fail(path, "expected at least %d item(s) matching 'contains', got %d", minC, count)
+        }
+        if maxC, ok := schemaInt(s, "maxContains"); ok && count > maxC {
+            v.fail(path, "expected at most %d item(s) matching 'contains', got %d", maxC, count)
+        }
+    }
+    return nil
+}
+
+func (v *jsonSchemaValidator) validateString(s map[string]any, str string, path string) error {
+    n := utf8.RuneCountInString(str)
+    if m, ok := schemaInt(s, "minLength"); ok && n < m {
+        v.fail(path, "string is shorter than %d characters", m)
+    }
+    if m, ok := schemaInt(s, "maxLength"); ok && n > m {
+        v.fail(path, "string is longer than %d characters", m)
+    }
+    if p, found := s["pattern"].(string); found {
+        re, err := v.regex(p)
+        if err != nil {
+            return err
+        }
+        if !re.MatchString(str) {
+            v.fail(path, "string %s does not match pattern %q", jsonShow(str), p)
+        }
+    }
+    if f, found := s["format"].(string); found {
+        if !jsonFormatValid(f, str) {
+            v.fail(path, "string %s is not a valid %s", jsonShow(str), f)
+        }
+    }
+    return nil
+}
+
+func (v *jsonSchemaValidator) validateNumber(s map[string]any, f float64, path string) {
+    if m, ok := schemaNumber(s, "minimum"); ok && f < m {
+        v.fail(path, "value %v is less than minimum %v", f, m)
+    }
+    if m, ok := schemaNumber(s, "maximum"); ok && f > m {
+        v.fail(path, "value %v is greater than maximum %v", f, m)
+    }
+    if m, ok := schemaNumber(s, "exclusiveMinimum"); ok && f <= m {
+        v.fail(path, "value %v must be greater than %v", f, m)
+    }
+    if m, ok := schemaNumber(s, "exclusiveMaximum"); ok && f >= m {
+        v.fail(path, "value %v must be less than %v", f, m)
+    }
+    if m, ok := schemaNumber(s, "multipleOf"); ok && m > 0 {
+        q := f / m
+        if math.Abs(q-math.Round(q)) > 1e-9 {
+            v.fail(path, "value %v is not a multiple of %v", f, m)
+        }
+    }
+}
+
+var jsonFormatHostnameRe = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+var jsonFormatUUIDRe = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// jsonFormatValid checks the common "format" values; unknown formats pass.
+func jsonFormatValid(format, s string) bool {
+    switch format {
+    case "date-time":
+        _, err := time.Parse(time.RFC3339, s)
+        return err == nil
+    case "date":
+        _, err := time.Parse("2006-01-02", s)
+        return err == nil
+    case "time":
+        _, err := time.Parse("15:04:05Z07:00", s)
+        if err != nil {
+            _, err = time.Parse("15:04:05", s)
+        }
+        return err == nil
+    case "email":
+        a, err := mail.ParseAddress(s)
+        return err == nil && a.Address == s
+    case "ipv4":
+        ip := net.ParseIP(s)
+        return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+    case "ipv6":
+        ip := net.ParseIP(s)
+        return ip != nil && strings.Contains(s, ":")
+    case "hostname":
+        return len(s) <= 253 && jsonFormatHostnameRe.MatchString(s)
+    case "uri":
+        u, err := url.Parse(s)
+        return err == nil && u.Scheme != ""
+    case "uuid":
+        return jsonFormatUUIDRe.MatchString(s)
+    case "regex":
+        _, err := regexp.Compile(s)
+        return err == nil
+    }
+    return true
+}
+
+// jsonValidate checks value against schema, returning one message per failure.
+func jsonValidate(value, schema any) ([]string, error) {
+    v := newJsonSchemaValidator(jsonSchemaNormalise(schema))
+    if err := v.validate(v.root, jsonSchemaNormalise(value), "$", 0); err != nil {
+        return nil, err
+    }
+    return v.errs, nil
+}
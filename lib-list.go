@@ -504,7 +504,7 @@ func buildListLib() {
         "append", "append_to", "insert", "remove", "push_front", "pop", "peek",
         "anytrue", "alltrue", "esplit", "min", "max", "avg", "eqlen",
         "empty", "list_string", "list_float", "list_int", "list_int64", "list_bool", "list_bigi", "list_bigf",
-        "scan_left", "zip", "list_fill", "concat",
+        "scan_left", "zip", "list_fill", "concat", "pmap", "pfilter", "preduce",
     }
 
     slhelp["scan_left"] = LibHelp{in: "numeric_list,op_string,start_seed", out: "list", action: "Creates a list from the intermediary values of processing [#i1]op_string[#i0] while iterating over [#i1]list[#i0]."}
@@ -3280,4 +3280,91 @@ func buildListLib() {
         return result, nil
     }
 
+    slhelp["pmap"] = LibHelp{in: "list,function[,workers]", out: "list",
+        action: "Calls [#i1]function[#i0] (a name or funcref()) on each element of [#i1]list[#i0] in parallel, on at most [#i1]workers[#i0]\n" +
+            "[#SOL]goroutines (default one per CPU), and returns the results in list order. The first error stops further calls and is raised."}
+    stdlib["pmap"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pmap", args, 2,
+            "2", "any", "any",
+            "3", "any", "any", "int"); !ok {
+            return nil, err
+        }
+        items, err := listItems("pmap", args[0])
+        if err != nil {
+            return nil, err
+        }
+        fn, err := listFnArg("pmap", ns, args[1])
+        if err != nil {
+            return nil, err
+        }
+        workers, err := listWorkers("pmap", args, 2)
+        if err != nil {
+            return nil, err
+        }
+        return parallelMap("pmap", fn, evalfs, items, workers)
+    }
+
+    slhelp["pfilter"] = LibHelp{in: "list,function[,workers]", out: "list",
+        action: "Returns the elements of [#i1]list[#i0] for which [#i1]function[#i0] returns true, calling it in parallel like pmap().\n" +
+            "[#SOL]Element order is preserved. The function must return a bool."}
+    stdlib["pfilter"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pfilter", args, 2,
+            "2", "any", "any",
+            "3", "any", "any", "int"); !ok {
+            return nil, err
+        }
+        items, err := listItems("pfilter", args[0])
+        if err != nil {
+            return nil, err
+        }
+        fn, err := listFnArg("pfilter", ns, args[1])
+        if err != nil {
+            return nil, err
+        }
+        workers, err := listWorkers("pfilter", args, 2)
+        if err != nil {
+            return nil, err
+        }
+        return parallelFilter(fn, evalfs, items, workers)
+    }
+
+    slhelp["preduce"] = LibHelp{in: "list,function,seed[,combine_function][,workers]", out: "value",
+        action: "Folds [#i1]list[#i0] into [#i1]seed[#i0] with [#i1]function[#i0](accumulator, element), left to right.\n" +
+            "[#SOL]Given [#i1]combine_function[#i0], the list is split into one slice per worker, each slice is folded from [#i1]seed[#i0] in parallel,\n" +
+            "[#SOL]and the partial results are merged in order with [#i1]combine_function[#i0](left, right). [#i1]seed[#i0] should then be an identity\n" +
+            "[#SOL](0 for counting or sums, \"\" for joining). Without a combine function the fold runs sequentially."}
+    stdlib["preduce"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("preduce", args, 5,
+            "3", "any", "any", "any",
+            "4", "any", "any", "any", "int",
+            "4", "any", "any", "any", "string",
+            "4", "any", "any", "any", "uint32",
+            "5", "any", "any", "any", "any", "int"); !ok {
+            return nil, err
+        }
+        items, err := listItems("preduce", args[0])
+        if err != nil {
+            return nil, err
+        }
+        fn, err := listFnArg("preduce", ns, args[1])
+        if err != nil {
+            return nil, err
+        }
+        combine := ""
+        wpos := 3
+        if len(args) > 3 {
+            if _, isInt := args[3].(int); !isInt {
+                if combine, err = listFnArg("preduce", ns, args[3]); err != nil {
+                    return nil, err
+                }
+                wpos = 4
+            }
+        }
+        workers, err := listWorkers("preduce", args, wpos)
+        if err != nil {
+            return nil, err
+        }
+        return parallelReduce(fn, combine, evalfs, items, args[2], workers)
+    }
+
 }
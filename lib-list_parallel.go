@@ -0,0 +1,209 @@
+//go:build !test
+
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "runtime"
+    "sync"
+    "sync/atomic"
+)
+
+/*
+   parallel list helpers: pmap(), pfilter() and preduce().
+
+   · a Za function is called once per element on a fixed set of worker
+     goroutines, which pick elements up in list order.
+   · results are stored by index, so output order matches input order.
+   · the first failure stops further elements being started; calls already
+     running are left to finish, then the error for the lowest failing index
+     is returned.
+*/
+
+// listFnArg resolves a function name or a funcref() handle to a qualified name.
+func listFnArg(fname, ns string, v any) (string, error) {
+    switch f := v.(type) {
+    case string:
+        fn, err := qualifyFnName(ns, f)
+        if err != nil {
+            return "", fmt.Errorf("%s: %v", fname, err)
+        }
+        return fn, nil
+    case uint32:
+        if name, found := numlookup.lmget(f); found {
+            if _, isFn := fnlookup.lmget(name); isFn {
+                return name, nil
+            }
+        }
+        return "", fmt.Errorf("%s: %d is not a function reference", fname, f)
+    }
+    return "", fmt.Errorf("%s: expected a function name or reference, not %T", fname, v)
+}
+
+// listItems copies any kind of Za list into a []any.
+func listItems(fname string, v any) ([]any, error) {
+    if items, ok := v.([]any); ok {
+        return items, nil
+    }
+    rv := reflect.ValueOf(v)
+    if rv.Kind() != reflect.Slice {
+        return nil, fmt.Errorf("%s: first argument must be a list", fname)
+    }
+    items := make([]any, rv.Len())
+    for i := range items {
+        items[i] = rv.Index(i).Interface()
+    }
+    return items, nil
+}
+
+// listWorkers reads the optional worker count at args[pos]. The default is
+// one worker per CPU.
+func listWorkers(fname string, args []any, pos int) (int, error) {
+    if len(args) <= pos {
+        return runtime.NumCPU(), nil
+    }
+    n, isInt := args[pos].(int)
+    if !isInt || n < 1 {
+        return 0, fmt.Errorf("%s: workers must be at least 1", fname)
+    }
+    return n, nil
+}
+
+// parallelEach runs do(i) for every i below n on up to workers goroutines.
+// Once a call fails no new indices are started, and the error with the
+// lowest index is returned.
+func parallelEach(n, workers int, do func(i int) error) error {
+    if workers > n {
+        workers = n
+    }
+    var next int64 = -1
+    var stop atomic.Bool
+    var mu sync.Mutex
+    failedAt := n
+    var failure error
+
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for !stop.Load() {
+                i := int(atomic.AddInt64(&next, 1))
+                if i >= n {
+                    return
+                }
+                if err := do(i); err != nil {
+                    stop.Store(true)
+                    mu.Lock()
+                    if i < failedAt {
+                        failedAt, failure = i, err
+                    }
+                    mu.Unlock()
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    return failure
+}
+
+// listCall calls fn for one list element, turning a panic into an error.
+func listCall(fn string, evalfs uint32, args ...any) (v any, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    return zaCallFn(fn, evalfs, ciAsyn, args...)
+}
+
+func parallelMap(fname, fn string, evalfs uint32, items []any, workers int) ([]any, error) {
+    results := make([]any, len(items))
+    err := parallelEach(len(items), workers, func(i int) error {
+        v, err := listCall(fn, evalfs, items[i])
+        if err != nil {
+            return fmt.Errorf("%s: element %d: %v", fname, i, err)
+        }
+        results[i] = v
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return results, nil
+}
+
+func parallelFilter(fn string, evalfs uint32, items []any, workers int) ([]any, error) {
+    keep, err := parallelMap("pfilter", fn, evalfs, items, workers)
+    if err != nil {
+        return nil, err
+    }
+    results := []any{}
+    for i, k := range keep {
+        b, isBool := k.(bool)
+        if !isBool {
+            return nil, fmt.Errorf("pfilter: element %d: function returned %T, not a bool", i, k)
+        }
+        if b {
+            results = append(results, items[i])
+        }
+    }
+    return results, nil
+}
+
+// parallelReduce folds items into seed with fn(accumulator, element). Without a
+// combine function this is a plain left fold on the calling goroutine. With
+// one, the list is cut into one contiguous run per worker, each run is folded
+// from seed on its own, and the partial results are merged left to right with
+// combine(left, right). seed must then be an identity for the fold, since
+// every run starts from it.
+func parallelReduce(fn, combine string, evalfs uint32, items []any, seed any, workers int) (any, error) {
+    if combine == "" || workers < 2 || len(items) < 2 {
+        acc := seed
+        for i, v := range items {
+            var err error
+            if acc, err = listCall(fn, evalfs, acc, v); err != nil {
+                return nil, fmt.Errorf("preduce: element %d: %v", i, err)
+            }
+        }
+        return acc, nil
+    }
+    if workers > len(items) {
+        workers = len(items)
+    }
+    runs := make([][]any, workers)
+    starts := make([]int, workers)
+    size, extra := len(items)/workers, len(items)%workers
+    for w, at := 0, 0; w < workers; w++ {
+        n := size
+        if w < extra {
+            n++
+        }
+        runs[w], starts[w] = items[at:at+n], at
+        at += n
+    }
+
+    partial := make([]any, workers)
+    err := parallelEach(workers, workers, func(w int) error {
+        acc := seed
+        for i, v := range runs[w] {
+            var err error
+            if acc, err = listCall(fn, evalfs, acc, v); err != nil {
+                return fmt.Errorf("preduce: element %d: %v", starts[w]+i, err)
+            }
+        }
+        partial[w] = acc
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    acc := partial[0]
+    for _, p := range partial[1:] {
+        if acc, err = listCall(combine, evalfs, acc, p); err != nil {
+            return nil, fmt.Errorf("preduce: combine: %v", err)
+        }
+    }
+    return acc, nil
+}
@@ -4,6 +4,7 @@ package main
 
 import (
     "crypto/tls"
+    "crypto/x509"
     "fmt"
     "io"
     "math/rand"
@@ -27,11 +28,16 @@ type tcpClientHandle struct {
     timeout   time.Duration
     handleID  string
     connected bool
+    rbuf      []byte     // received but not yet read, see lib-network_framing.go
+    rmu       sync.Mutex // guards rbuf
 }
 
 type tcpServerHandle struct {
     listener  net.Listener
+    raw       listenerDeadline // underlying tcp or unix listener, for accept deadlines
+    tls       bool
     port      int
+    path      string // unix socket path, when not listening on a port
     mode      string // "blocking" or "non_blocking"
     running   bool
     handleID  string
@@ -47,6 +53,30 @@ var (
     handleID   int64
 )
 
+type listenerDeadline interface {
+    SetDeadline(time.Time) error
+}
+
+// unixSocketPath recognises "unix:/path/to.sock" addresses.
+func unixSocketPath(addr string) (string, bool) {
+    if strings.HasPrefix(addr, "unix:") && len(addr) > 5 {
+        return addr[5:], true
+    }
+    return "", false
+}
+
+// listenUnix listens on a unix socket, removing a stale socket file left by a dead server.
+func listenUnix(path string) (*net.UnixListener, error) {
+    if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+        if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+            c.Close()
+            return nil, fmt.Errorf("unix socket %s is already in use", path)
+        }
+        os.Remove(path)
+    }
+    return net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+}
+
 // Generate unique handle ID (following ZA pattern)
 func generateHandleID() string {
     b := make([]byte, 16)
@@ -369,9 +399,11 @@ func buildNetworkLib() {
     features["network"] = Feature{version: 1, category: "network"}
     categories["network"] = []string{
         "tcp_client", "tcp_server", "tcp_close", "tcp_send", "tcp_receive", "tcp_available",
-        "icmp_ping", "tcp_ping", "traceroute", "tcp_traceroute", "icmp_traceroute", "dns_resolve", "port_scan",
+        "icmp_ping", "tcp_ping", "traceroute", "tcp_traceroute", "icmp_traceroute", "dns_resolve", "dns_query", "port_scan",
         "net_interfaces_detailed", "ssl_cert_validate", "ssl_cert_install_help", "http_headers", "http_benchmark",
-        "network_stats", "tcp_server_accept", "tcp_server_stop",
+        "network_stats", "tcp_server_accept", "tcp_server_stop", "tls_self_signed_cert",
+        "tcp_read_line", "tcp_read_until", "tcp_read_bytes", "tcp_read_frame", "tcp_send_frame", "tcp_send_line", "tcp_buffered",
+        "udp_listen", "udp_send_to", "udp_receive", "udp_close", "udp_join_group", "udp_leave_group", "udp_info",
         // Network monitoring functions
         "netstat", "netstat_protocols", "netstat_protocol_info", "netstat_protocol",
         "netstat_listen", "netstat_established", "netstat_process", "netstat_interface", "open_files",
@@ -379,14 +411,18 @@ func buildNetworkLib() {
 
     // TCP Client functions
     slhelp["tcp_client"] = LibHelp{
-        in:     "host, port, [timeout_seconds]",
+        in:     "host, port, [timeout_seconds, [tls_options]]",
         out:    "handle",
-        action: "Creates TCP connection to host:port. Returns handle for subsequent operations.",
+        action: "Creates TCP connection to host:port. Returns handle for subsequent operations.\n" +
+            "[#SOL]A [#i1]host[#i0] of \"unix:/path/to.sock\" connects to a unix domain socket instead; [#i1]port[#i0] is then ignored.\n" +
+            "[#SOL]When [#i1]tls_options[#i0] is given the connection uses TLS. Options: .ca_file, .cert_file and .key_file (client certificate),\n" +
+            "[#SOL].server_name and .insecure (skip verification). An empty map uses the system roots.",
     }
     stdlib["tcp_client"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("tcp_client", args, 2,
+        if ok, err := expect_args("tcp_client", args, 3,
             "2", "string", "int",
-            "3", "string", "int", "int"); !ok {
+            "3", "string", "int", "int",
+            "4", "string", "int", "int", "map[string]interface {}"); !ok {
             return "", err
         }
 
@@ -394,19 +430,37 @@ func buildNetworkLib() {
         port := args[1].(int)
         timeout := 30 * time.Second
 
-        if len(args) == 3 {
+        if len(args) > 2 {
             timeout = time.Duration(args[2].(int)) * time.Second
         }
 
-        // Validate port
-        if port <= 0 || port > 65535 {
-            return "", fmt.Errorf("port must be between 1 and 65535")
+        network, addr := "tcp", ""
+        if path, isUnix := unixSocketPath(host); isUnix {
+            network, addr, port = "unix", path, 0
+        } else {
+            // Validate port
+            if port <= 0 || port > 65535 {
+                return "", fmt.Errorf("port must be between 1 and 65535")
+            }
+            addr = net.JoinHostPort(host, strconv.Itoa(port))
         }
 
         // Create connection
-        conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), timeout)
-        if err != nil {
-            return "", err
+        var conn net.Conn
+        if len(args) == 4 {
+            tc, err := tlsClientOptions("tcp_client", args[3].(map[string]any))
+            if err != nil {
+                return "", err
+            }
+            conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, addr, tc)
+            if err != nil {
+                return "", err
+            }
+        } else {
+            conn, err = net.DialTimeout(network, addr, timeout)
+            if err != nil {
+                return "", err
+            }
         }
 
         // Create handle
@@ -518,6 +572,15 @@ func buildNetworkLib() {
             }, nil
         }
 
+        // Data left over from framed reads comes first
+        if buffered := handle.readBuffered(4096); buffered != nil {
+            return map[string]any{
+                "content":   string(buffered),
+                "available": true,
+                "error":     "",
+            }, nil
+        }
+
         // Set read timeout
         handle.conn.SetReadDeadline(time.Now().Add(timeout))
 
@@ -549,31 +612,71 @@ func buildNetworkLib() {
 
     // TCP Server functions
     slhelp["tcp_server"] = LibHelp{
-        in:     "port, [mode]",
+        in:     "port, [mode, [tls_options]]",
         out:    "handle",
-        action: "Starts a TCP server on the given port. Mode can be 'blocking' or 'non_blocking'. Returns handle.",
+        action: "Starts a TCP server on the given port. Mode can be 'blocking' or 'non_blocking'. Returns handle.\n" +
+            "[#SOL][#i1]port[#i0] may instead be \"unix:/path/to.sock\" to listen on a unix domain socket (a stale socket file is replaced).\n" +
+            "[#SOL][#i1]tls_options[#i0] serves TLS: .cert_file and .key_file (required), .client_ca to verify client certificates (mTLS),\n" +
+            "[#SOL].client_auth (none, request, require, verify_if_given or require_and_verify) and .min_version (1.2 by default).",
     }
     stdlib["tcp_server"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("tcp_server", args, 2,
+        if ok, err := expect_args("tcp_server", args, 6,
             "1", "int",
-            "2", "int", "string"); !ok {
+            "2", "int", "string",
+            "3", "int", "string", "map[string]interface {}",
+            "1", "string",
+            "2", "string", "string",
+            "3", "string", "string", "map[string]interface {}"); !ok {
             return "", err
         }
-        port := args[0].(int)
+        port, path := 0, ""
+        switch a := args[0].(type) {
+        case int:
+            port = a
+            if port <= 0 || port > 65535 {
+                return "", fmt.Errorf("port must be between 1 and 65535")
+            }
+        case string:
+            p, isUnix := unixSocketPath(a)
+            if !isUnix {
+                return "", fmt.Errorf("tcp_server address must be a port or \"unix:/path\"")
+            }
+            path = p
+        }
         mode := "blocking"
-        if len(args) == 2 {
+        if len(args) > 1 {
             mode = args[1].(string)
         }
-        if port <= 0 || port > 65535 {
-            return "", fmt.Errorf("port must be between 1 and 65535")
+        var tc *tls.Config
+        if len(args) == 3 {
+            if tc, err = tlsServerConfig("tcp_server", args[2].(map[string]any)); err != nil {
+                return "", err
+            }
         }
-        ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-        if err != nil {
-            return "", err
+        var ln net.Listener
+        var raw listenerDeadline
+        if path != "" {
+            uln, err := listenUnix(path)
+            if err != nil {
+                return "", err
+            }
+            ln, raw = uln, uln
+        } else {
+            tln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+            if err != nil {
+                return "", err
+            }
+            ln, raw = tln, tln.(*net.TCPListener)
+        }
+        if tc != nil {
+            ln = tls.NewListener(ln, tc)
         }
         handle := &tcpServerHandle{
             listener: ln,
+            raw:      raw,
+            tls:      tc != nil,
             port:     port,
+            path:     path,
             mode:     mode,
             running:  true,
             handleID: generateHandleID(),
@@ -588,7 +691,8 @@ func buildNetworkLib() {
     slhelp["tcp_server_accept"] = LibHelp{
         in:     "handle, [timeout_seconds]",
         out:    "map",
-        action: "Accepts a new client connection on the TCP server. Returns map with client handle and address.",
+        action: "Accepts a new client connection on the TCP server. Returns map with client handle and address.\n" +
+            "[#SOL]On unix socket servers .peer_cred holds the connecting process's .pid, .uid and .gid (Linux only, otherwise nil).",
     }
     stdlib["tcp_server_accept"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("tcp_server_accept", args, 2,
@@ -607,18 +711,48 @@ func buildNetworkLib() {
         if !exists || !server.running {
             return map[string]any{"error": "invalid or stopped TCP server handle"}, nil
         }
-        server.listener.(*net.TCPListener).SetDeadline(time.Now().Add(timeout))
+        server.raw.SetDeadline(time.Now().Add(timeout))
         conn, err := server.listener.Accept()
         if err != nil {
             return map[string]any{"error": err.Error()}, nil
         }
+
+        // complete the handshake here so certificate problems are reported by the accept
+        var peer any
+        if tconn, isTLS := conn.(*tls.Conn); isTLS {
+            tconn.SetDeadline(time.Now().Add(timeout))
+            if err := tconn.Handshake(); err != nil {
+                conn.Close()
+                return map[string]any{"error": "tls handshake: " + err.Error()}, nil
+            }
+            tconn.SetDeadline(time.Time{})
+            cs := tconn.ConnectionState()
+            if info := tlsPeerInfo(&cs); info != nil {
+                peer = info
+            }
+        }
+
+        var cred any
+        if server.path != "" {
+            if info := unixPeerCred(conn); info != nil {
+                cred = info
+            }
+        }
+
+        // accepted connections are usable with tcp_send(), tcp_receive() and tcp_close()
         clientHandleID := generateHandleID()
+        host, portStr, _ := net.SplitHostPort(conn.RemoteAddr().String())
+        rport, _ := strconv.Atoi(portStr)
         handleMux.Lock()
         server.clients[clientHandleID] = conn
+        tcpClients[clientHandleID] = &tcpClientHandle{conn: conn, host: host, port: rport, timeout: timeout, handleID: clientHandleID, connected: true}
         handleMux.Unlock()
         return map[string]any{
             "client_handle": clientHandleID,
             "remote_addr":   conn.RemoteAddr().String(),
+            "tls":           server.tls,
+            "peer":          peer,
+            "peer_cred":     cred,
             "error":         "",
         }, nil
     }
@@ -641,14 +775,112 @@ func buildNetworkLib() {
         }
         server.running = false
         server.listener.Close()
-        for _, conn := range server.clients {
+        for id, conn := range server.clients {
             conn.Close()
+            delete(tcpClients, id)
         }
         delete(tcpServers, handleID)
         handleMux.Unlock()
         return true, nil
     }
 
+    slhelp["tls_self_signed_cert"] = LibHelp{
+        in:     "hosts, [options]",
+        out:    "map",
+        action: "Generates an ECDSA certificate and key for [#i1]hosts[#i0] (a name, IP address or list of them) for local TLS testing.\n" +
+            "[#SOL]Returns map with .cert_file, .key_file, .cert and .key (PEM). Options: .dir (default a new temporary directory), .name (file base name),\n" +
+            "[#SOL].days (validity, default 30), .cn (common name) and .ca_cert_file/.ca_key_file to issue from a CA instead of self-signing.\n" +
+            "[#SOL]Self-signed certificates may themselves be used as the CA for client certificates.",
+    }
+    stdlib["tls_self_signed_cert"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tls_self_signed_cert", args, 4,
+            "1", "string",
+            "1", "[]interface {}",
+            "2", "string", "map[string]interface {}",
+            "2", "[]interface {}", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        var hosts []string
+        switch h := args[0].(type) {
+        case string:
+            hosts = []string{h}
+        case []any:
+            for _, e := range h {
+                hosts = append(hosts, GetAsString(e))
+            }
+        }
+        if len(hosts) == 0 {
+            return nil, fmt.Errorf("tls_self_signed_cert needs at least one host")
+        }
+
+        dir, name, cn := "", "", ""
+        days := 30
+        var caCertFile, caKeyFile string
+        if len(args) == 2 {
+            for k, v := range args[1].(map[string]any) {
+                switch k {
+                case "days":
+                    n, invalid := GetAsInt(v)
+                    if invalid || n < 1 {
+                        return nil, fmt.Errorf("tls_self_signed_cert option 'days' must be a positive number")
+                    }
+                    days = n
+                case "dir", "name", "cn", "ca_cert_file", "ca_key_file":
+                    sv, ok := v.(string)
+                    if !ok {
+                        return nil, fmt.Errorf("tls_self_signed_cert option '%s' must be a string", k)
+                    }
+                    switch k {
+                    case "dir":
+                        dir = sv
+                    case "name":
+                        name = sv
+                    case "cn":
+                        cn = sv
+                    case "ca_cert_file":
+                        caCertFile = sv
+                    case "ca_key_file":
+                        caKeyFile = sv
+                    }
+                default:
+                    return nil, fmt.Errorf("tls_self_signed_cert: unknown option '%s'", k)
+                }
+            }
+        }
+
+        var caCert *x509.Certificate
+        var caKey any
+        if caCertFile != "" || caKeyFile != "" {
+            if caCertFile == "" || caKeyFile == "" {
+                return nil, fmt.Errorf("tls_self_signed_cert options 'ca_cert_file' and 'ca_key_file' must be given together")
+            }
+            if caCert, caKey, err = tlsLoadCA("tls_self_signed_cert", caCertFile, caKeyFile); err != nil {
+                return nil, err
+            }
+        }
+
+        certPEM, keyPEM, err := tlsSelfSigned(hosts, cn, days, caCert, caKey)
+        if err != nil {
+            return nil, fmt.Errorf("tls_self_signed_cert: %v", err)
+        }
+        if dir == "" {
+            if dir, err = os.MkdirTemp("", "za-tls-"); err != nil {
+                return nil, fmt.Errorf("tls_self_signed_cert: %v", err)
+            }
+        }
+        if name == "" {
+            name = tlsSafeName(hosts[0])
+            if cn != "" {
+                name = tlsSafeName(cn)
+            }
+        }
+        certFile, keyFile, err := tlsWritePair(dir, name, certPEM, keyPEM)
+        if err != nil {
+            return nil, fmt.Errorf("tls_self_signed_cert: %v", err)
+        }
+        return map[string]any{"cert_file": certFile, "key_file": keyFile, "cert": string(certPEM), "key": string(keyPEM)}, nil
+    }
+
     slhelp["tcp_available"] = LibHelp{
         in:     "handle",
         out:    "bool",
@@ -668,6 +900,338 @@ func buildNetworkLib() {
         return handle.connected, nil
     }
 
+    // Framed reads
+    slhelp["tcp_read_line"] = LibHelp{
+        in:     "handle, [timeout_seconds, [max_length]]",
+        out:    "map",
+        action: "Reads one line from a TCP handle, without its \\n or \\r\\n ending. Returns map with content, available and error fields.\n" +
+            "[#SOL]Data after the line stays buffered for later reads. On timeout or eof a partial line is kept and .error is \"timeout\" or \"eof\".\n" +
+            "[#SOL][#i1]max_length[#i0] (default 1MiB) bounds the line; a longer line reports an error and is left unread.",
+    }
+    stdlib["tcp_read_line"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_read_line", args, 3,
+            "1", "string",
+            "2", "string", "number",
+            "3", "string", "number", "int"); !ok {
+            return nil, err
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return tcpReadResult(nil, fmt.Errorf("invalid or disconnected TCP client handle")), nil
+        }
+        max := tcpLineMax
+        if len(args) == 3 {
+            max = args[2].(int)
+        }
+        return tcpReadResult(h.readFramed(tcpTimeout(args, 1), scanUntil([]byte("\n"), max, true))), nil
+    }
+
+    slhelp["tcp_read_until"] = LibHelp{
+        in:     "handle, delimiter, [timeout_seconds, [max_length]]",
+        out:    "map",
+        action: "Reads from a TCP handle up to [#i1]delimiter[#i0], which is consumed but not returned. Returns map with content, available and error fields.\n" +
+            "[#SOL]Behaves as tcp_read_line() for buffering, timeouts and [#i1]max_length[#i0].",
+    }
+    stdlib["tcp_read_until"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_read_until", args, 3,
+            "2", "string", "string",
+            "3", "string", "string", "number",
+            "4", "string", "string", "number", "int"); !ok {
+            return nil, err
+        }
+        delim := args[1].(string)
+        if delim == "" {
+            return nil, fmt.Errorf("tcp_read_until: delimiter cannot be empty")
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return tcpReadResult(nil, fmt.Errorf("invalid or disconnected TCP client handle")), nil
+        }
+        max := tcpLineMax
+        if len(args) == 4 {
+            max = args[3].(int)
+        }
+        return tcpReadResult(h.readFramed(tcpTimeout(args, 2), scanUntil([]byte(delim), max, false))), nil
+    }
+
+    slhelp["tcp_read_bytes"] = LibHelp{
+        in:     "handle, count, [timeout_seconds]",
+        out:    "map",
+        action: "Reads exactly [#i1]count[#i0] bytes from a TCP handle. Returns map with content, available and error fields.\n" +
+            "[#SOL]If fewer bytes arrive before the timeout or eof they stay buffered and nothing is returned.",
+    }
+    stdlib["tcp_read_bytes"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_read_bytes", args, 2,
+            "2", "string", "int",
+            "3", "string", "int", "number"); !ok {
+            return nil, err
+        }
+        count := args[1].(int)
+        if count < 0 {
+            return nil, fmt.Errorf("tcp_read_bytes: count cannot be negative")
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return tcpReadResult(nil, fmt.Errorf("invalid or disconnected TCP client handle")), nil
+        }
+        return tcpReadResult(h.readFramed(tcpTimeout(args, 2), scanCount(count))), nil
+    }
+
+    slhelp["tcp_read_frame"] = LibHelp{
+        in:     "handle, [timeout_seconds, [options]]",
+        out:    "map",
+        action: "Reads one length-prefixed frame from a TCP handle and returns its payload in a map with content, available and error fields.\n" +
+            "[#SOL]Options: .size (prefix bytes: 1, 2, 4 or 8, default 4), .order (\"big\" or \"little\", default big)\n" +
+            "[#SOL]and .max (largest accepted payload, default 16MiB). Incomplete frames stay buffered.",
+    }
+    stdlib["tcp_read_frame"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_read_frame", args, 3,
+            "1", "string",
+            "2", "string", "number",
+            "3", "string", "number", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        var opts map[string]any
+        if len(args) == 3 {
+            opts = args[2].(map[string]any)
+        }
+        o, err := parseFrameOptions("tcp_read_frame", opts)
+        if err != nil {
+            return nil, err
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return tcpReadResult(nil, fmt.Errorf("invalid or disconnected TCP client handle")), nil
+        }
+        return tcpReadResult(h.readFramed(tcpTimeout(args, 1), scanPrefixed(o))), nil
+    }
+
+    slhelp["tcp_send_frame"] = LibHelp{
+        in:     "handle, data, [options]",
+        out:    "bool",
+        action: "Sends [#i1]data[#i0] over a TCP handle preceded by its length. Takes the .size and .order options of tcp_read_frame().",
+    }
+    stdlib["tcp_send_frame"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_send_frame", args, 2,
+            "2", "string", "string",
+            "3", "string", "string", "map[string]interface {}"); !ok {
+            return false, err
+        }
+        var opts map[string]any
+        if len(args) == 3 {
+            opts = args[2].(map[string]any)
+        }
+        o, err := parseFrameOptions("tcp_send_frame", opts)
+        if err != nil {
+            return false, err
+        }
+        data := args[1].(string)
+        prefix, err := framePrefix(o, len(data))
+        if err != nil {
+            return false, fmt.Errorf("tcp_send_frame: %v", err)
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return false, fmt.Errorf("invalid or disconnected TCP client handle")
+        }
+        if _, err = h.conn.Write(append(prefix, data...)); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+
+    slhelp["tcp_send_line"] = LibHelp{
+        in:     "handle, line, [line_ending]",
+        out:    "bool",
+        action: "Sends [#i1]line[#i0] over a TCP handle followed by [#i1]line_ending[#i0] (default \"\\r\\n\").",
+    }
+    stdlib["tcp_send_line"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_send_line", args, 2,
+            "2", "string", "string",
+            "3", "string", "string", "string"); !ok {
+            return false, err
+        }
+        eol := "\r\n"
+        if len(args) == 3 {
+            eol = args[2].(string)
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return false, fmt.Errorf("invalid or disconnected TCP client handle")
+        }
+        if _, err = h.conn.Write([]byte(args[1].(string) + eol)); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+
+    slhelp["tcp_buffered"] = LibHelp{
+        in:     "handle",
+        out:    "int",
+        action: "Returns the number of bytes received on a TCP handle but not yet read.",
+    }
+    stdlib["tcp_buffered"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("tcp_buffered", args, 1, "1", "string"); !ok {
+            return 0, err
+        }
+        h, ok := tcpLookup(args[0].(string))
+        if !ok {
+            return 0, fmt.Errorf("invalid or disconnected TCP client handle")
+        }
+        return h.buffered(), nil
+    }
+
+    // UDP functions
+    slhelp["udp_listen"] = LibHelp{
+        in:     "port, [options]",
+        out:    "handle",
+        action: "Opens a UDP socket bound to [#i1]port[#i0] (0 picks a free port, for sending). Returns handle.\n" +
+            "[#SOL]Options: .host (bind address, default all) and .buffer (largest datagram read, default 65535).",
+    }
+    stdlib["udp_listen"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_listen", args, 2,
+            "1", "int",
+            "2", "int", "map[string]interface {}"); !ok {
+            return "", err
+        }
+        var opts map[string]any
+        if len(args) == 2 {
+            opts = args[1].(map[string]any)
+        }
+        h, err := udpListen(args[0].(int), opts)
+        if err != nil {
+            return "", fmt.Errorf("udp_listen: %v", err)
+        }
+        return h.handleID, nil
+    }
+
+    slhelp["udp_send_to"] = LibHelp{
+        in:     "handle, host, port, data",
+        out:    "int",
+        action: "Sends [#i1]data[#i0] as one datagram to host:port. Returns the number of bytes sent.",
+    }
+    stdlib["udp_send_to"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_send_to", args, 1, "4", "string", "string", "int", "string"); !ok {
+            return 0, err
+        }
+        h, err := udpLookup("udp_send_to", args[0].(string))
+        if err != nil {
+            return 0, err
+        }
+        n, err := h.sendTo(args[1].(string), args[2].(int), []byte(args[3].(string)))
+        if err != nil {
+            return n, fmt.Errorf("udp_send_to: %v", err)
+        }
+        return n, nil
+    }
+
+    slhelp["udp_receive"] = LibHelp{
+        in:     "handle, [timeout_seconds]",
+        out:    "map",
+        action: "Waits (default 5s) for one datagram. Returns map with content, available, error, size and the sender's host and port.\n" +
+            "[#SOL]On timeout .available is false and .error is \"timeout\".",
+    }
+    stdlib["udp_receive"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_receive", args, 2,
+            "1", "string",
+            "2", "string", "number"); !ok {
+            return nil, err
+        }
+        h, err := udpLookup("udp_receive", args[0].(string))
+        if err != nil {
+            return map[string]any{"content": "", "available": false, "error": "invalid UDP handle", "host": "", "port": 0, "size": 0}, nil
+        }
+        timeout := 5 * time.Second
+        if len(args) == 2 {
+            f, _ := GetAsFloat(args[1])
+            timeout = time.Duration(f * float64(time.Second))
+        }
+        return h.receive(timeout), nil
+    }
+
+    slhelp["udp_close"] = LibHelp{
+        in:     "handle",
+        out:    "bool",
+        action: "Closes a UDP socket and frees handle.",
+    }
+    stdlib["udp_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_close", args, 1, "1", "string"); !ok {
+            return false, err
+        }
+        h, err := udpLookup("udp_close", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        h.close()
+        return true, nil
+    }
+
+    slhelp["udp_join_group"] = LibHelp{
+        in:     "handle, group_ip, [interface_name]",
+        out:    "bool",
+        action: "Joins the IPv4 or IPv6 multicast group [#i1]group_ip[#i0] on a UDP socket, optionally on a named interface.",
+    }
+    stdlib["udp_join_group"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_join_group", args, 2,
+            "2", "string", "string",
+            "3", "string", "string", "string"); !ok {
+            return false, err
+        }
+        h, err := udpLookup("udp_join_group", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        ifname := ""
+        if len(args) == 3 {
+            ifname = args[2].(string)
+        }
+        if err := h.membership("udp_join_group", args[1].(string), ifname, true); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+
+    slhelp["udp_leave_group"] = LibHelp{
+        in:     "handle, group_ip, [interface_name]",
+        out:    "bool",
+        action: "Leaves a multicast group previously joined with udp_join_group().",
+    }
+    stdlib["udp_leave_group"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_leave_group", args, 2,
+            "2", "string", "string",
+            "3", "string", "string", "string"); !ok {
+            return false, err
+        }
+        h, err := udpLookup("udp_leave_group", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        ifname := ""
+        if len(args) == 3 {
+            ifname = args[2].(string)
+        }
+        if err := h.membership("udp_leave_group", args[1].(string), ifname, false); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+
+    slhelp["udp_info"] = LibHelp{
+        in:     "handle",
+        out:    "map",
+        action: "Returns UDP socket details: local_addr, port, groups, sent and received datagram counts.",
+    }
+    stdlib["udp_info"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("udp_info", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        h, err := udpLookup("udp_info", args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+        return h.toMap(), nil
+    }
+
     // ICMP Ping
     slhelp["icmp_ping"] = LibHelp{
         in:     "host, [timeout_seconds]",
@@ -1132,6 +1696,46 @@ func buildNetworkLib() {
         return result, nil
     }
 
+    // DNS Query
+    slhelp["dns_query"] = LibHelp{
+        in:     "name, [record_type], [options_map]",
+        out:    "map",
+        action: "Queries a nameserver directly for [#i1]name[#i0]. Record types: A, AAAA, MX, TXT, SRV, CNAME, NS, SOA, PTR (default A). PTR queries accept an IP address.\n" +
+            "[#SOL]Options: .server (host or host:port, default first resolv.conf nameserver), .timeout (seconds, default 5), .retries (default 2), .tcp (bool, always use TCP), .recurse (bool, default true).\n" +
+            "[#SOL]Replies truncated over UDP are retried over TCP.\n" +
+            "[#SOL]Returns map with .name, .type, .server, .protocol, .rcode (e.g. NOERROR, NXDOMAIN), .authoritative, .recursion_available, .truncated, .rtt_ms, .error\n" +
+            "[#SOL]and .answers, .authority, .additional lists of records. Each record has .name, .type, .ttl and .data, plus\n" +
+            "[#SOL].preference/.exchange (MX), .priority/.weight/.port/.target (SRV), .strings (TXT) or .mname/.rname/.serial/.refresh/.retry/.expire/.minimum (SOA).\n" +
+            "[#SOL]Network failures are reported in .error.",
+    }
+    stdlib["dns_query"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("dns_query", args, 3,
+            "1", "string",
+            "2", "string", "string",
+            "3", "string", "string", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        name := args[0]
+        recordType := "A"
+        if len(args) > 1 {
+            recordType = strings.ToUpper(args[1].(string))
+        }
+        qtype, found := dnsTypes[recordType]
+        switch {
+        case !found, recordType == "OPT", recordType == "ANY", recordType == "CAA":
+            return nil, fmt.Errorf("dns_query: unsupported record type '%s'. Supported types: A, AAAA, MX, TXT, SRV, CNAME, NS, SOA, PTR", recordType)
+        }
+        opts := map[string]any{}
+        if len(args) == 3 {
+            opts = args[2].(map[string]any)
+        }
+        o, err := parseDnsOptions(opts)
+        if err != nil {
+            return nil, err
+        }
+        return dnsQuery(name.(string), qtype, o)
+    }
+
     // Port Scan
     slhelp["port_scan"] = LibHelp{
         in:     "host, ports, [timeout_seconds]",
@@ -0,0 +1,486 @@
+//go:build !test
+
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/*
+   dns_query(): a small RFC 1035 stub resolver that talks to a chosen
+   nameserver directly, so record details (TTLs, the AA flag, SOA fields)
+   that the system resolver hides are available to scripts.
+
+   · queries go over UDP with an EDNS0 4096 byte payload, falling back to
+     TCP when the reply is truncated (or always, with .tcp true).
+   · names in replies may be compressed; pointers are followed with a hop
+     limit so malformed packets cannot loop.
+*/
+
+var dnsTypes = map[string]uint16{
+    "A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "PTR": 12, "MX": 15, "TXT": 16, "AAAA": 28, "SRV": 33, "OPT": 41, "CAA": 257, "ANY": 255,
+}
+
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED", "YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE"}
+
+func dnsTypeName(t uint16) string {
+    for name, v := range dnsTypes {
+        if v == t {
+            return name
+        }
+    }
+    return "TYPE" + strconv.Itoa(int(t))
+}
+
+func dnsRcodeName(rc int) string {
+    if rc < len(dnsRcodes) {
+        return dnsRcodes[rc]
+    }
+    return "RCODE" + strconv.Itoa(rc)
+}
+
+type dnsOptions struct {
+    server  string // host:port
+    timeout time.Duration
+    tcp     bool
+    recurse bool
+    retries int
+}
+
+// dnsDefaultServer returns the first nameserver from /etc/resolv.conf.
+func dnsDefaultServer() string {
+    f, err := os.Open("/etc/resolv.conf")
+    if err == nil {
+        defer f.Close()
+        sc := bufio.NewScanner(f)
+        for sc.Scan() {
+            fields := strings.Fields(sc.Text())
+            if len(fields) >= 2 && fields[0] == "nameserver" {
+                return net.JoinHostPort(fields[1], "53")
+            }
+        }
+    }
+    return "127.0.0.1:53"
+}
+
+func parseDnsOptions(opts map[string]any) (dnsOptions, error) {
+    o := dnsOptions{timeout: 5 * time.Second, recurse: true, retries: 2}
+    for k, v := range opts {
+        switch k {
+        case "server":
+            s, ok := v.(string)
+            if !ok || s == "" {
+                return o, errors.New("dns_query option 'server' must be a host or host:port string")
+            }
+            if _, _, err := net.SplitHostPort(s); err != nil {
+                s = net.JoinHostPort(strings.Trim(s, "[]"), "53")
+            }
+            o.server = s
+        case "timeout":
+            f, invalid := GetAsFloat(v)
+            if invalid || f <= 0 {
+                return o, errors.New("dns_query option 'timeout' must be a positive number of seconds")
+            }
+            o.timeout = time.Duration(f * float64(time.Second))
+        case "retries":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 0 {
+                return o, errors.New("dns_query option 'retries' must be zero or more")
+            }
+            o.retries = n
+        case "tcp", "recurse":
+            b, ok := v.(bool)
+            if !ok {
+                return o, fmt.Errorf("dns_query option '%s' must be a boolean", k)
+            }
+            if k == "tcp" {
+                o.tcp = b
+            } else {
+                o.recurse = b
+            }
+        default:
+            return o, fmt.Errorf("dns_query: unknown option '%s'", k)
+        }
+    }
+    if o.server == "" {
+        o.server = dnsDefaultServer()
+    }
+    return o, nil
+}
+
+// dnsReverseName turns an IP address into its in-addr.arpa or ip6.arpa name.
+func dnsReverseName(ip net.IP) string {
+    if ip4 := ip.To4(); ip4 != nil {
+        return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+    }
+    h := hex.EncodeToString(ip.To16())
+    var b strings.Builder
+    for i := len(h) - 1; i >= 0; i-- {
+        b.WriteByte(h[i])
+        b.WriteByte('.')
+    }
+    return b.String() + "ip6.arpa."
+}
+
+func dnsAppendName(b []byte, name string) ([]byte, error) {
+    name = strings.TrimSuffix(name, ".")
+    if name != "" {
+        for _, label := range strings.Split(name, ".") {
+            if label == "" || len(label) > 63 {
+                return nil, fmt.Errorf("invalid name '%s'", name)
+            }
+            b = append(b, byte(len(label)))
+            b = append(b, label...)
+        }
+    }
+    return append(b, 0), nil
+}
+
+// dnsBuildQuery encodes a single question with an EDNS0 OPT record.
+func dnsBuildQuery(id uint16, name string, qtype uint16, recurse bool) ([]byte, error) {
+    var flags uint16
+    if recurse {
+        flags |= 0x0100
+    }
+    b := make([]byte, 12)
+    binary.BigEndian.PutUint16(b[0:], id)
+    binary.BigEndian.PutUint16(b[2:], flags)
+    binary.BigEndian.PutUint16(b[4:], 1)  // questions
+    binary.BigEndian.PutUint16(b[10:], 1) // additional: OPT
+    b, err := dnsAppendName(b, name)
+    if err != nil {
+        return nil, err
+    }
+    b = binary.BigEndian.AppendUint16(b, qtype)
+    b = binary.BigEndian.AppendUint16(b, 1) // IN
+    // OPT: root name, type 41, class = udp payload size, ttl 0, no data
+    b = append(b, 0)
+    b = binary.BigEndian.AppendUint16(b, 41)
+    b = binary.BigEndian.AppendUint16(b, 4096)
+    b = append(b, 0, 0, 0, 0, 0, 0)
+    return b, nil
+}
+
+type dnsReader struct {
+    msg []byte
+    off int
+}
+
+var errDnsShort = errors.New("truncated DNS message")
+
+func (r *dnsReader) u8() (byte, error) {
+    if r.off+1 > len(r.msg) {
+        return 0, errDnsShort
+    }
+    r.off++
+    return r.msg[r.off-1], nil
+}
+
+func (r *dnsReader) u16() (uint16, error) {
+    if r.off+2 > len(r.msg) {
+        return 0, errDnsShort
+    }
+    r.off += 2
+    return binary.BigEndian.Uint16(r.msg[r.off-2:]), nil
+}
+
+func (r *dnsReader) u32() (uint32, error) {
+    if r.off+4 > len(r.msg) {
+        return 0, errDnsShort
+    }
+    r.off += 4
+    return binary.BigEndian.Uint32(r.msg[r.off-4:]), nil
+}
+
+// name reads a possibly compressed domain name at the current offset.
+func (r *dnsReader) name() (string, error) {
+    var labels []string
+    off := r.off
+    jumped := false
+    for hops := 0; ; hops++ {
+        if hops > 64 || off >= len(r.msg) {
+            return "", errors.New("malformed name in DNS message")
+        }
+        l := int(r.msg[off])
+        switch {
+        case l == 0:
+            if !jumped {
+                r.off = off + 1
+            }
+            return strings.Join(labels, ".") + ".", nil
+        case l&0xC0 == 0xC0:
+            if off+2 > len(r.msg) {
+                return "", errDnsShort
+            }
+            if !jumped {
+                r.off = off + 2
+            }
+            jumped = true
+            off = int(binary.BigEndian.Uint16(r.msg[off:]) & 0x3FFF)
+        default:
+            if off+1+l > len(r.msg) {
+                return "", errDnsShort
+            }
+            labels = append(labels, string(r.msg[off+1:off+1+l]))
+            off += 1 + l
+        }
+    }
+}
+
+// record decodes one resource record into a Za map.
+func (r *dnsReader) record() (map[string]any, error) {
+    name, err := r.name()
+    if err != nil {
+        return nil, err
+    }
+    rtype, err := r.u16()
+    if err != nil {
+        return nil, err
+    }
+    class, err := r.u16()
+    if err != nil {
+        return nil, err
+    }
+    ttl, err := r.u32()
+    if err != nil {
+        return nil, err
+    }
+    rdlen, err := r.u16()
+    if err != nil {
+        return nil, err
+    }
+    end := r.off + int(rdlen)
+    if end > len(r.msg) {
+        return nil, errDnsShort
+    }
+    rec := map[string]any{"name": name, "type": dnsTypeName(rtype), "ttl": int(ttl), "class": int(class)}
+    rd := &dnsReader{msg: r.msg[:end], off: r.off}
+
+    switch rtype {
+    case 1, 28:
+        rec["data"] = net.IP(r.msg[r.off:end]).String()
+    case 2, 5, 12:
+        target, err := rd.name()
+        if err != nil {
+            return nil, err
+        }
+        rec["data"] = target
+    case 15:
+        pref, err := rd.u16()
+        if err != nil {
+            return nil, err
+        }
+        host, err := rd.name()
+        if err != nil {
+            return nil, err
+        }
+        rec["preference"] = int(pref)
+        rec["exchange"] = host
+        rec["data"] = fmt.Sprintf("%d %s", pref, host)
+    case 16:
+        var parts []any
+        var joined strings.Builder
+        for rd.off < end {
+            l, _ := rd.u8()
+            if rd.off+int(l) > end {
+                return nil, errDnsShort
+            }
+            s := string(r.msg[rd.off : rd.off+int(l)])
+            rd.off += int(l)
+            parts = append(parts, s)
+            joined.WriteString(s)
+        }
+        rec["strings"] = parts
+        rec["data"] = joined.String()
+    case 33:
+        var v [3]uint16
+        for i := range v {
+            if v[i], err = rd.u16(); err != nil {
+                return nil, err
+            }
+        }
+        target, err := rd.name()
+        if err != nil {
+            return nil, err
+        }
+        rec["priority"], rec["weight"], rec["port"], rec["target"] = int(v[0]), int(v[1]), int(v[2]), target
+        rec["data"] = fmt.Sprintf("%d %d %d %s", v[0], v[1], v[2], target)
+    case 6:
+        mname, err := rd.name()
+        if err != nil {
+            return nil, err
+        }
+        rname, err := rd.name()
+        if err != nil {
+            return nil, err
+        }
+        var v [5]uint32
+        for i := range v {
+            if v[i], err = rd.u32(); err != nil {
+                return nil, err
+            }
+        }
+        rec["mname"], rec["rname"] = mname, rname
+        rec["serial"], rec["refresh"], rec["retry"], rec["expire"], rec["minimum"] = int(v[0]), int(v[1]), int(v[2]), int(v[3]), int(v[4])
+        rec["data"] = fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname, v[0], v[1], v[2], v[3], v[4])
+    default:
+        rec["data"] = hex.EncodeToString(r.msg[r.off:end])
+    }
+    r.off = end
+    return rec, nil
+}
+
+// dnsParseReply decodes a reply to query id into the dns_query() result fields.
+func dnsParseReply(msg []byte, id uint16) (map[string]any, error) {
+    r := &dnsReader{msg: msg}
+    var hdr [6]uint16
+    for i := range hdr {
+        v, err := r.u16()
+        if err != nil {
+            return nil, err
+        }
+        hdr[i] = v
+    }
+    if hdr[0] != id {
+        return nil, errors.New("reply ID does not match query")
+    }
+    flags := hdr[1]
+    if flags&0x8000 == 0 {
+        return nil, errors.New("message is not a reply")
+    }
+    for i := 0; i < int(hdr[2]); i++ {
+        if _, err := r.name(); err != nil {
+            return nil, err
+        }
+        r.off += 4
+    }
+    res := map[string]any{
+        "id":                  int(id),
+        "rcode":               dnsRcodeName(int(flags & 0x000F)),
+        "authoritative":       flags&0x0400 != 0,
+        "truncated":           flags&0x0200 != 0,
+        "recursion_available": flags&0x0080 != 0,
+    }
+    sections := []string{"answers", "authority", "additional"}
+    for i, sec := range sections {
+        list := []any{}
+        for n := 0; n < int(hdr[3+i]); n++ {
+            rec, err := r.record()
+            if err != nil {
+                return nil, err
+            }
+            if rec["type"] == "OPT" {
+                continue
+            }
+            list = append(list, rec)
+        }
+        res[sec] = list
+    }
+    return res, nil
+}
+
+// dnsExchange sends one query over udp or tcp and returns the raw reply.
+func dnsExchange(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+    conn, err := net.DialTimeout(network, server, timeout)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(timeout))
+
+    if network == "tcp" {
+        if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+            return nil, err
+        }
+        var l [2]byte
+        if _, err := io.ReadFull(conn, l[:]); err != nil {
+            return nil, err
+        }
+        msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+        if _, err := io.ReadFull(conn, msg); err != nil {
+            return nil, err
+        }
+        return msg, nil
+    }
+
+    if _, err := conn.Write(query); err != nil {
+        return nil, err
+    }
+    buf := make([]byte, 65535)
+    for {
+        n, err := conn.Read(buf)
+        if err != nil {
+            return nil, err
+        }
+        // ignore stray datagrams that do not answer this query
+        if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+            return buf[:n], nil
+        }
+    }
+}
+
+// dnsQuery resolves name/qtype against o.server. Network failures are reported in .error.
+func dnsQuery(name string, qtype uint16, o dnsOptions) (map[string]any, error) {
+    if ip := net.ParseIP(name); ip != nil && qtype == dnsTypes["PTR"] {
+        name = dnsReverseName(ip)
+    }
+    if !strings.HasSuffix(name, ".") {
+        name += "."
+    }
+    id := uint16(rand.Intn(65536))
+    query, err := dnsBuildQuery(id, name, qtype, o.recurse)
+    if err != nil {
+        return nil, err
+    }
+
+    res := map[string]any{
+        "name": name, "type": dnsTypeName(qtype), "server": o.server, "protocol": "udp", "error": "",
+        "rcode": "", "authoritative": false, "truncated": false, "recursion_available": false,
+        "answers": []any{}, "authority": []any{}, "additional": []any{}, "rtt_ms": 0.0,
+    }
+    start := time.Now()
+
+    var msg []byte
+    var lastErr error
+    protocol := "udp"
+    if o.tcp {
+        protocol = "tcp"
+    }
+    for attempt := 0; attempt <= o.retries; attempt++ {
+        msg, lastErr = dnsExchange(protocol, o.server, query, o.timeout)
+        if lastErr == nil {
+            break
+        }
+    }
+    if lastErr == nil && protocol == "udp" && len(msg) > 2 && msg[2]&0x02 != 0 {
+        // truncated: ask again over tcp
+        protocol = "tcp"
+        msg, lastErr = dnsExchange("tcp", o.server, query, o.timeout)
+    }
+    res["protocol"] = protocol
+    res["rtt_ms"] = msecs(time.Since(start))
+    if lastErr != nil {
+        res["error"] = lastErr.Error()
+        return res, nil
+    }
+    reply, err := dnsParseReply(msg, id)
+    if err != nil {
+        res["error"] = err.Error()
+        return res, nil
+    }
+    for k, v := range reply {
+        res[k] = v
+    }
+    return res, nil
+}
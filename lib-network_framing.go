@@ -0,0 +1,222 @@
+//go:build !test
+
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "time"
+)
+
+/*
+   framed reads on tcp handles.
+
+   · every tcp handle owns a read buffer. the tcp_read_* calls fill it from the
+     connection until a whole frame is present, then remove just that frame,
+     so anything that arrived after it stays buffered for the next call.
+   · a frame is all or nothing: on timeout or eof the partial data remains in
+     the buffer and the call reports .error "timeout" or "eof".
+   · tcp_receive() drains the buffer before reading the socket again.
+*/
+
+const (
+    tcpLineMax  = 1 << 20  // default longest line or delimited read
+    tcpFrameMax = 16 << 20 // default largest length-prefixed frame
+)
+
+// tcpScan inspects the buffered bytes and reports a complete frame and how much
+// of the buffer it used, or ok=false if more data is needed.
+type tcpScan func(buf []byte) (frame []byte, used int, ok bool, err error)
+
+type tcpFrameOptions struct {
+    size  int // prefix bytes: 1, 2, 4 or 8
+    order binary.ByteOrder
+    max   int
+}
+
+func tcpLookup(id string) (*tcpClientHandle, bool) {
+    handleMux.RLock()
+    h, exists := tcpClients[id]
+    handleMux.RUnlock()
+    return h, exists && h.connected
+}
+
+func tcpReadResult(content []byte, err error) map[string]any {
+    if err != nil {
+        return map[string]any{"content": "", "available": false, "error": tcpReadError(err)}
+    }
+    return map[string]any{"content": string(content), "available": true, "error": ""}
+}
+
+func tcpReadError(err error) string {
+    switch {
+    case errors.Is(err, os.ErrDeadlineExceeded):
+        return "timeout"
+    case err == io.EOF:
+        return "eof"
+    }
+    return err.Error()
+}
+
+// readFramed fills the handle buffer until scan finds a frame or the timeout passes.
+func (h *tcpClientHandle) readFramed(timeout time.Duration, scan tcpScan) ([]byte, error) {
+    h.rmu.Lock()
+    defer h.rmu.Unlock()
+    deadline := time.Now().Add(timeout)
+    tmp := make([]byte, 4096)
+    for {
+        frame, used, ok, err := scan(h.rbuf)
+        if err != nil {
+            return nil, err
+        }
+        if ok {
+            frame = append([]byte(nil), frame...)
+            h.rbuf = h.rbuf[used:]
+            if len(h.rbuf) == 0 {
+                h.rbuf = nil
+            }
+            return frame, nil
+        }
+        h.conn.SetReadDeadline(deadline)
+        n, err := h.conn.Read(tmp)
+        h.rbuf = append(h.rbuf, tmp[:n]...)
+        if err != nil && n == 0 {
+            return nil, err
+        }
+    }
+}
+
+// readBuffered returns up to max already buffered bytes, for tcp_receive().
+func (h *tcpClientHandle) readBuffered(max int) []byte {
+    h.rmu.Lock()
+    defer h.rmu.Unlock()
+    if len(h.rbuf) == 0 {
+        return nil
+    }
+    n := min(max, len(h.rbuf))
+    out := append([]byte(nil), h.rbuf[:n]...)
+    h.rbuf = h.rbuf[n:]
+    return out
+}
+
+func (h *tcpClientHandle) buffered() int {
+    h.rmu.Lock()
+    defer h.rmu.Unlock()
+    return len(h.rbuf)
+}
+
+// scanUntil finds delim, returning the data before it. If trimCR is set a
+// trailing \r is also removed (for \r\n line endings).
+func scanUntil(delim []byte, max int, trimCR bool) tcpScan {
+    return func(buf []byte) ([]byte, int, bool, error) {
+        i := bytes.Index(buf, delim)
+        if i < 0 {
+            if len(buf) > max+len(delim) {
+                return nil, 0, false, fmt.Errorf("no delimiter within %d bytes", max)
+            }
+            return nil, 0, false, nil
+        }
+        if i > max {
+            return nil, 0, false, fmt.Errorf("no delimiter within %d bytes", max)
+        }
+        frame := buf[:i]
+        if trimCR && len(frame) > 0 && frame[len(frame)-1] == '\r' {
+            frame = frame[:len(frame)-1]
+        }
+        return frame, i + len(delim), true, nil
+    }
+}
+
+func scanCount(count int) tcpScan {
+    return func(buf []byte) ([]byte, int, bool, error) {
+        if len(buf) < count {
+            return nil, 0, false, nil
+        }
+        return buf[:count], count, true, nil
+    }
+}
+
+func scanPrefixed(o tcpFrameOptions) tcpScan {
+    return func(buf []byte) ([]byte, int, bool, error) {
+        if len(buf) < o.size {
+            return nil, 0, false, nil
+        }
+        var l uint64
+        switch o.size {
+        case 1:
+            l = uint64(buf[0])
+        case 2:
+            l = uint64(o.order.Uint16(buf))
+        case 4:
+            l = uint64(o.order.Uint32(buf))
+        case 8:
+            l = o.order.Uint64(buf)
+        }
+        if l > uint64(o.max) {
+            return nil, 0, false, fmt.Errorf("frame of %d bytes exceeds limit of %d", l, o.max)
+        }
+        end := o.size + int(l)
+        if len(buf) < end {
+            return nil, 0, false, nil
+        }
+        return buf[o.size:end], end, true, nil
+    }
+}
+
+func parseFrameOptions(fname string, opts map[string]any) (tcpFrameOptions, error) {
+    o := tcpFrameOptions{size: 4, order: binary.BigEndian, max: tcpFrameMax}
+    for k, v := range opts {
+        switch k {
+        case "size":
+            n, invalid := GetAsInt(v)
+            if invalid || (n != 1 && n != 2 && n != 4 && n != 8) {
+                return o, fmt.Errorf("%s option 'size' must be 1, 2, 4 or 8", fname)
+            }
+            o.size = n
+        case "order":
+            switch v {
+            case "big":
+                o.order = binary.BigEndian
+            case "little":
+                o.order = binary.LittleEndian
+            default:
+                return o, fmt.Errorf("%s option 'order' must be \"big\" or \"little\"", fname)
+            }
+        case "max":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 0 {
+                return o, fmt.Errorf("%s option 'max' must be zero or more", fname)
+            }
+            o.max = n
+        default:
+            return o, fmt.Errorf("%s: unknown option '%s'", fname, k)
+        }
+    }
+    return o, nil
+}
+
+// framePrefix encodes the length prefix for a frame of n bytes.
+func framePrefix(o tcpFrameOptions, n int) ([]byte, error) {
+    if o.size < 8 && uint64(n) >= 1<<(8*o.size) {
+        return nil, fmt.Errorf("frame of %d bytes does not fit a %d byte length prefix", n, o.size)
+    }
+    p := make([]byte, 8)
+    o.order.PutUint64(p, uint64(n))
+    if o.order == binary.BigEndian {
+        return p[8-o.size:], nil
+    }
+    return p[:o.size], nil
+}
+
+// tcpTimeout reads an optional timeout argument in seconds.
+func tcpTimeout(args []any, pos int) time.Duration {
+    if len(args) > pos {
+        f, _ := GetAsFloat(args[pos])
+        return time.Duration(f * float64(time.Second))
+    }
+    return 5 * time.Second
+}
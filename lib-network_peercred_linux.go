@@ -0,0 +1,33 @@
+//go:build linux && !test
+
+package main
+
+import (
+    "crypto/tls"
+    "net"
+
+    "golang.org/x/sys/unix"
+)
+
+// unixPeerCred returns the credentials of the process at the other end of a unix socket.
+func unixPeerCred(conn net.Conn) map[string]any {
+    if tc, ok := conn.(*tls.Conn); ok {
+        conn = tc.NetConn()
+    }
+    uc, ok := conn.(*net.UnixConn)
+    if !ok {
+        return nil
+    }
+    rc, err := uc.SyscallConn()
+    if err != nil {
+        return nil
+    }
+    var cred *unix.Ucred
+    rc.Control(func(fd uintptr) {
+        cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+    })
+    if err != nil || cred == nil {
+        return nil
+    }
+    return map[string]any{"pid": int(cred.Pid), "uid": int(cred.Uid), "gid": int(cred.Gid)}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !test
+
+package main
+
+import "net"
+
+// unixPeerCred is only implemented on Linux.
+func unixPeerCred(conn net.Conn) map[string]any {
+    return nil
+}
@@ -0,0 +1,174 @@
+//go:build !test
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// UDP socket handles, kept alongside the tcp handles and guarded by handleMux.
+type udpHandle struct {
+    conn     *net.UDPConn
+    handleID string
+    buffer   int
+    groups   map[string]*net.Interface // joined multicast groups
+    mu       sync.Mutex
+    sent     int64
+    received int64
+}
+
+var udpSockets = make(map[string]*udpHandle)
+
+func udpLookup(fname, id string) (*udpHandle, error) {
+    handleMux.RLock()
+    h, exists := udpSockets[id]
+    handleMux.RUnlock()
+    if !exists {
+        return nil, fmt.Errorf("%s: invalid UDP handle", fname)
+    }
+    return h, nil
+}
+
+// udpListen binds a UDP socket. Options: .host (bind address) and .buffer (largest datagram read).
+func udpListen(port int, opts map[string]any) (*udpHandle, error) {
+    host := ""
+    buffer := 65535
+    for k, v := range opts {
+        switch k {
+        case "host":
+            s, ok := v.(string)
+            if !ok {
+                return nil, errors.New("udp_listen option 'host' must be a string")
+            }
+            host = s
+        case "buffer":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 1 || n > 65535 {
+                return nil, errors.New("udp_listen option 'buffer' must be between 1 and 65535")
+            }
+            buffer = n
+        default:
+            return nil, fmt.Errorf("udp_listen: unknown option '%s'", k)
+        }
+    }
+    addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+    if err != nil {
+        return nil, err
+    }
+    conn, err := net.ListenUDP("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    h := &udpHandle{
+        conn:     conn,
+        handleID: "udp_" + strings.TrimPrefix(generateHandleID(), "tcp_"),
+        buffer:   buffer,
+        groups:   make(map[string]*net.Interface),
+    }
+    handleMux.Lock()
+    udpSockets[h.handleID] = h
+    handleMux.Unlock()
+    return h, nil
+}
+
+func (h *udpHandle) sendTo(host string, port int, data []byte) (int, error) {
+    addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+    if err != nil {
+        return 0, err
+    }
+    n, err := h.conn.WriteToUDP(data, addr)
+    h.mu.Lock()
+    h.sent++
+    h.mu.Unlock()
+    return n, err
+}
+
+// receive waits for one datagram, returning the tcp_receive() style map plus the sender.
+func (h *udpHandle) receive(timeout time.Duration) map[string]any {
+    res := map[string]any{"content": "", "available": false, "error": "", "host": "", "port": 0, "size": 0}
+    buf := make([]byte, h.buffer)
+    h.conn.SetReadDeadline(time.Now().Add(timeout))
+    n, addr, err := h.conn.ReadFromUDP(buf)
+    if err != nil {
+        if errors.Is(err, os.ErrDeadlineExceeded) {
+            res["error"] = "timeout"
+        } else {
+            res["error"] = err.Error()
+        }
+        return res
+    }
+    h.mu.Lock()
+    h.received++
+    h.mu.Unlock()
+    res["content"] = string(buf[:n])
+    res["available"] = true
+    res["size"] = n
+    res["host"] = addr.IP.String()
+    res["port"] = addr.Port
+    return res
+}
+
+// membership joins or leaves a multicast group, optionally on a named interface.
+func (h *udpHandle) membership(fname, group, ifname string, join bool) error {
+    ip := net.ParseIP(group)
+    if ip == nil || !ip.IsMulticast() {
+        return fmt.Errorf("%s: '%s' is not a multicast address", fname, group)
+    }
+    var ifi *net.Interface
+    if ifname != "" {
+        var err error
+        if ifi, err = net.InterfaceByName(ifname); err != nil {
+            return fmt.Errorf("%s: %v", fname, err)
+        }
+    }
+    key := ip.String() + "%" + ifname
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    _, joined := h.groups[key]
+    if join == joined {
+        if join {
+            return fmt.Errorf("%s: already a member of %s", fname, group)
+        }
+        return fmt.Errorf("%s: not a member of %s", fname, group)
+    }
+    if err := udpMulticastMembership(h.conn, ip, ifi, join); err != nil {
+        return fmt.Errorf("%s: %v", fname, err)
+    }
+    if join {
+        h.groups[key] = ifi
+    } else {
+        delete(h.groups, key)
+    }
+    return nil
+}
+
+func (h *udpHandle) toMap() map[string]any {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    groups := []string{}
+    for g := range h.groups {
+        groups = append(groups, strings.TrimSuffix(g, "%"))
+    }
+    addr := h.conn.LocalAddr().(*net.UDPAddr)
+    return map[string]any{
+        "local_addr": addr.String(),
+        "port":       addr.Port,
+        "groups":     groups,
+        "sent":       h.sent,
+        "received":   h.received,
+    }
+}
+
+func (h *udpHandle) close() {
+    handleMux.Lock()
+    delete(udpSockets, h.handleID)
+    handleMux.Unlock()
+    h.conn.Close()
+}
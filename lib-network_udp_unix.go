@@ -0,0 +1,63 @@
+//go:build !windows && !test
+
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// udpMulticastMembership adds or drops a multicast group on an existing socket.
+func udpMulticastMembership(conn *net.UDPConn, group net.IP, ifi *net.Interface, join bool) error {
+    rc, err := conn.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var serr error
+    err = rc.Control(func(fd uintptr) {
+        if ip4 := group.To4(); ip4 != nil {
+            mreq := &syscall.IPMreq{}
+            copy(mreq.Multiaddr[:], ip4)
+            if ifi != nil {
+                if local := interfaceIPv4(ifi); local != nil {
+                    copy(mreq.Interface[:], local)
+                }
+            }
+            opt := syscall.IP_ADD_MEMBERSHIP
+            if !join {
+                opt = syscall.IP_DROP_MEMBERSHIP
+            }
+            serr = syscall.SetsockoptIPMreq(int(fd), syscall.IPPROTO_IP, opt, mreq)
+            return
+        }
+        mreq := &syscall.IPv6Mreq{}
+        copy(mreq.Multiaddr[:], group.To16())
+        if ifi != nil {
+            mreq.Interface = uint32(ifi.Index)
+        }
+        opt := syscall.IPV6_JOIN_GROUP
+        if !join {
+            opt = syscall.IPV6_LEAVE_GROUP
+        }
+        serr = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, opt, mreq)
+    })
+    if err != nil {
+        return err
+    }
+    return serr
+}
+
+func interfaceIPv4(ifi *net.Interface) net.IP {
+    addrs, err := ifi.Addrs()
+    if err != nil {
+        return nil
+    }
+    for _, a := range addrs {
+        if n, ok := a.(*net.IPNet); ok {
+            if ip4 := n.IP.To4(); ip4 != nil {
+                return ip4
+            }
+        }
+    }
+    return nil
+}
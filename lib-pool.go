@@ -0,0 +1,482 @@
+//go:build !test
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/*
+   bounded worker pools for Za functions.
+
+   · a pool runs at most size tasks at once; further submissions queue in
+     submission order until a slot frees up.
+   · a task may be cancelled, or given a deadline measured from when it
+     starts. either settles the task at once, so await and pool_wait report
+     it as cancelled or timed_out straight away.
+   · Za code cannot be pre-empted: a running function notices cancellation
+     by checking task_cancelled(), and keeps its pool slot until it returns.
+*/
+
+type workerPool struct {
+    id        int64
+    size      int
+    sem       chan struct{}
+    timeout   time.Duration // default per-task deadline, 0 for none
+    mu        sync.Mutex
+    settled   *sync.Cond // signalled as tasks settle, for pool_wait()
+    pending   []*poolTask // submitted and not yet collected by pool_wait()
+    live      map[*poolTask]bool
+    queued    int
+    running   int
+    submitted int
+    completed int
+    failed    int
+    timedOut  int
+    cancelled int
+}
+
+type poolTask struct {
+    id       string
+    pool     *workerPool
+    fn       string
+    args     []any
+    evalfs   uint32
+    timeout  time.Duration
+    cancel   chan struct{}
+    once     sync.Once
+    result   chan any // receives the status map once, for await()
+    mu       sync.Mutex
+    status   string // queued, running, done, error, timed_out or cancelled
+    value    any
+    err      string
+    started  time.Time
+    finished time.Time
+}
+
+var workerPoolSeq int64
+var poolTaskSeq int64
+
+// poolTokens maps the function space of each running pool task to its task,
+// so task_cancelled() can find the task it was called under.
+var poolTokens = make(map[uint32]*poolTask)
+var poolTokenLock sync.RWMutex
+
+func newWorkerPool(size int, timeout time.Duration) *workerPool {
+    p := &workerPool{
+        id:      atomic.AddInt64(&workerPoolSeq, 1),
+        size:    size,
+        sem:     make(chan struct{}, size),
+        timeout: timeout,
+        live:    make(map[*poolTask]bool),
+    }
+    p.settled = sync.NewCond(&p.mu)
+    return p
+}
+
+func (p *workerPool) String() string {
+    return fmt.Sprintf("pool#%d(%d)", p.id, p.size)
+}
+
+func (t *poolTask) String() string {
+    return t.id
+}
+
+// submit queues a call to fn. A timeout of -1 uses the pool default.
+func (p *workerPool) submit(fn string, evalfs uint32, args []any, timeout time.Duration) *poolTask {
+    if timeout < 0 {
+        timeout = p.timeout
+    }
+    t := &poolTask{
+        id:      fmt.Sprintf("task_%d", atomic.AddInt64(&poolTaskSeq, 1)),
+        pool:    p,
+        fn:      fn,
+        args:    args,
+        evalfs:  evalfs,
+        timeout: timeout,
+        cancel:  make(chan struct{}),
+        result:  make(chan any, 1),
+        status:  "queued",
+    }
+    p.mu.Lock()
+    p.pending = append(p.pending, t)
+    p.live[t] = true
+    p.queued++
+    p.submitted++
+    p.mu.Unlock()
+    go t.run()
+    return t
+}
+
+func (t *poolTask) run() {
+    p := t.pool
+    select {
+    case p.sem <- struct{}{}:
+    case <-t.cancel:
+        p.mu.Lock()
+        p.queued--
+        p.mu.Unlock()
+        return
+    }
+    defer func() { <-p.sem }()
+
+    t.mu.Lock()
+    p.mu.Lock()
+    p.queued--
+    p.mu.Unlock()
+    if t.status != "queued" {
+        // cancelled while waiting for the slot
+        t.mu.Unlock()
+        return
+    }
+    t.status = "running"
+    t.started = time.Now()
+    t.mu.Unlock()
+
+    p.mu.Lock()
+    p.running++
+    p.mu.Unlock()
+    if t.timeout > 0 {
+        deadline := time.AfterFunc(t.timeout, func() { t.stop("timed_out") })
+        defer deadline.Stop()
+    }
+
+    value, err := t.call()
+    p.mu.Lock()
+    p.running--
+    p.mu.Unlock()
+    if err != nil {
+        t.settle("error", nil, err.Error())
+    } else {
+        t.settle("done", value, "")
+    }
+}
+
+// call runs the task function with its cancellation token bound, turning a
+// panic in the function into an error.
+func (t *poolTask) call() (value any, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    bind := func(loc uint32) func() {
+        poolTokenLock.Lock()
+        poolTokens[loc] = t
+        poolTokenLock.Unlock()
+        return func() {
+            poolTokenLock.Lock()
+            delete(poolTokens, loc)
+            poolTokenLock.Unlock()
+        }
+    }
+    return zaCallFnWith(t.fn, t.evalfs, ciAsyn, bind, t.args...)
+}
+
+// settle records the outcome of a task. Only the first outcome counts, so a
+// task that finishes after it timed out stays timed out.
+func (t *poolTask) settle(status string, value any, errmsg string) bool {
+    t.mu.Lock()
+    if t.status != "queued" && t.status != "running" {
+        t.mu.Unlock()
+        return false
+    }
+    t.status, t.value, t.err = status, value, errmsg
+    t.finished = time.Now()
+    t.mu.Unlock()
+    t.result <- struct {
+        l uint32
+        r any
+    }{0, t.toMap()}
+
+    p := t.pool
+    p.mu.Lock()
+    delete(p.live, t)
+    switch status {
+    case "done":
+        p.completed++
+    case "error":
+        p.failed++
+    case "timed_out":
+        p.timedOut++
+    case "cancelled":
+        p.cancelled++
+    }
+    p.settled.Broadcast()
+    p.mu.Unlock()
+    return true
+}
+
+// stop settles the task with the given status and signals its cancellation
+// token. Reports false if the task had already settled.
+func (t *poolTask) stop(status string) bool {
+    if !t.settle(status, nil, "") {
+        return false
+    }
+    t.once.Do(func() { close(t.cancel) })
+    return true
+}
+
+func (t *poolTask) isCancelled() bool {
+    select {
+    case <-t.cancel:
+        return true
+    default:
+        return false
+    }
+}
+
+func (t *poolTask) toMap() map[string]any {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    var d time.Duration
+    switch {
+    case t.started.IsZero():
+    case t.finished.IsZero():
+        d = time.Since(t.started)
+    default:
+        d = t.finished.Sub(t.started)
+    }
+    return map[string]any{
+        "id": t.id, "function": t.fn, "status": t.status,
+        "value": t.value, "error": t.err, "duration_ms": msecs(d),
+    }
+}
+
+// cancelAll stops every unsettled task, returning how many were stopped.
+func (p *workerPool) cancelAll() int {
+    p.mu.Lock()
+    tasks := make([]*poolTask, 0, len(p.live))
+    for t := range p.live {
+        tasks = append(tasks, t)
+    }
+    p.mu.Unlock()
+    n := 0
+    for _, t := range tasks {
+        if t.stop("cancelled") {
+            n++
+        }
+    }
+    return n
+}
+
+// wait blocks until every submitted task has settled, or the timeout passes
+// (-1 waits forever), then returns and forgets the settled tasks in
+// submission order. Tasks still unsettled stay for the next wait.
+func (p *workerPool) wait(timeout time.Duration) []any {
+    if timeout > 0 {
+        t := time.AfterFunc(timeout, func() {
+            p.mu.Lock()
+            p.settled.Broadcast()
+            p.mu.Unlock()
+        })
+        defer t.Stop()
+    }
+    deadline := time.Now().Add(timeout)
+    p.mu.Lock()
+    for len(p.live) > 0 && (timeout < 0 || time.Now().Before(deadline)) {
+        p.settled.Wait()
+    }
+    var results, keep []*poolTask
+    for _, t := range p.pending {
+        if p.live[t] {
+            keep = append(keep, t)
+            continue
+        }
+        results = append(results, t)
+    }
+    p.pending = keep
+    p.mu.Unlock()
+
+    list := make([]any, 0, len(results))
+    for _, t := range results {
+        list = append(list, t.toMap())
+    }
+    return list
+}
+
+func (p *workerPool) toMap() map[string]any {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return map[string]any{
+        "size": p.size, "timeout": p.timeout.Seconds(),
+        "queued": p.queued, "running": p.running, "uncollected": len(p.pending),
+        "submitted": p.submitted, "completed": p.completed, "failed": p.failed,
+        "timed_out": p.timedOut, "cancelled": p.cancelled,
+    }
+}
+
+// poolTaskAt finds the pool task that function space fs is running under, by
+// walking up the caller chain to the function the pool called.
+func poolTaskAt(fs uint32) *poolTask {
+    poolTokenLock.RLock()
+    defer poolTokenLock.RUnlock()
+    if len(poolTokens) == 0 {
+        return nil
+    }
+    calllock.RLock()
+    defer calllock.RUnlock()
+    for depth := 0; depth < gnfsModulus && fs < uint32(len(calltable)); depth++ {
+        if t, found := poolTokens[fs]; found {
+            return t
+        }
+        next := calltable[fs].caller
+        if next == fs || next == 0 {
+            break
+        }
+        fs = next
+    }
+    return nil
+}
+
+// poolSeconds reads an optional timeout in seconds from an options map.
+func poolSeconds(fname string, opts map[string]any) (time.Duration, error) {
+    timeout := time.Duration(-1)
+    for k, v := range opts {
+        if k != "timeout" {
+            return 0, fmt.Errorf("%s: unknown option '%s'", fname, k)
+        }
+        f, invalid := GetAsFloat(v)
+        if invalid || f < 0 {
+            return 0, fmt.Errorf("%s: timeout must be zero or more seconds", fname)
+        }
+        timeout = time.Duration(f * float64(time.Second))
+    }
+    return timeout, nil
+}
+
+func buildPoolLib() {
+
+    features["pool"] = Feature{version: 1, category: "concurrency"}
+    categories["pool"] = []string{"pool_new", "pool_submit", "pool_wait", "pool_cancel", "pool_info", "task_cancelled"}
+
+    slhelp["pool_new"] = LibHelp{in: "size, [options_map]", out: "pool",
+        action: "Creates a worker pool that runs at most [#i1]size[#i0] submitted tasks at once.\n" +
+            "[#SOL]Option .timeout sets a default deadline in seconds for each task, counted from when it starts."}
+    stdlib["pool_new"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pool_new", args, 2,
+            "1", "int",
+            "2", "int", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        size := args[0].(int)
+        if size < 1 {
+            return nil, errors.New("pool_new: size must be at least 1")
+        }
+        var timeout time.Duration
+        if len(args) == 2 {
+            if timeout, err = poolSeconds("pool_new", args[1].(map[string]any)); err != nil {
+                return nil, err
+            }
+        }
+        if timeout < 0 {
+            timeout = 0
+        }
+        return newWorkerPool(size, timeout), nil
+    }
+
+    slhelp["pool_submit"] = LibHelp{in: "pool, function_name, [args_list], [options_map]", out: "task",
+        action: "Queues a call to [#i1]function_name[#i0] on [#i1]pool[#i0] and returns a task handle.\n" +
+            "[#SOL]Option .timeout overrides the pool deadline for this task (0 for none).\n" +
+            "[#SOL]The handle may be stored in an async handle map, so await() returns its status map."}
+    stdlib["pool_submit"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pool_submit", args, 3,
+            "2", "*main.workerPool", "string",
+            "3", "*main.workerPool", "string", "any",
+            "4", "*main.workerPool", "string", "any", "map[string]interface {}"); !ok {
+            return nil, err
+        }
+        fn, err := qualifyFnName(ns, args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("pool_submit: %v", err)
+        }
+        var fargs []any
+        if len(args) > 2 {
+            rv := reflect.ValueOf(args[2])
+            if rv.Kind() != reflect.Slice {
+                return nil, fmt.Errorf("pool_submit: args must be a list")
+            }
+            for i := 0; i < rv.Len(); i++ {
+                fargs = append(fargs, rv.Index(i).Interface())
+            }
+        }
+        timeout := time.Duration(-1)
+        if len(args) > 3 {
+            if timeout, err = poolSeconds("pool_submit", args[3].(map[string]any)); err != nil {
+                return nil, err
+            }
+        }
+        return args[0].(*workerPool).submit(fn, evalfs, fargs, timeout), nil
+    }
+
+    slhelp["pool_wait"] = LibHelp{in: "pool, [timeout_seconds]", out: "[]map",
+        action: "Waits until every task submitted to [#i1]pool[#i0] has settled, or [#i1]timeout_seconds[#i0] pass.\n" +
+            "[#SOL]Returns the settled tasks in submission order, each a map with .id, .function, .status\n" +
+            "[#SOL](done, error, timed_out or cancelled), .value, .error and .duration_ms. Returned tasks are not reported again."}
+    stdlib["pool_wait"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pool_wait", args, 2,
+            "1", "*main.workerPool",
+            "2", "*main.workerPool", "number"); !ok {
+            return nil, err
+        }
+        timeout, err := chanSeconds("pool_wait", args, 1)
+        if err != nil {
+            return nil, err
+        }
+        return args[0].(*workerPool).wait(timeout), nil
+    }
+
+    slhelp["pool_cancel"] = LibHelp{in: "pool_or_task", out: "int",
+        action: "Cancels a task, or every unsettled task in a pool. Returns the number of tasks cancelled.\n" +
+            "[#SOL]Queued tasks never start. Running tasks are reported as cancelled at once and should return when task_cancelled() is true."}
+    stdlib["pool_cancel"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pool_cancel", args, 2,
+            "1", "*main.workerPool",
+            "1", "*main.poolTask"); !ok {
+            return nil, err
+        }
+        switch h := args[0].(type) {
+        case *workerPool:
+            return h.cancelAll(), nil
+        case *poolTask:
+            if h.stop("cancelled") {
+                return 1, nil
+            }
+        }
+        return 0, nil
+    }
+
+    slhelp["pool_info"] = LibHelp{in: "pool_or_task", out: "map",
+        action: "Returns the status map of a task, or the counters of a pool: .size, .timeout, .queued, .running, .uncollected,\n" +
+            "[#SOL].submitted, .completed, .failed, .timed_out and .cancelled."}
+    stdlib["pool_info"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("pool_info", args, 2,
+            "1", "*main.workerPool",
+            "1", "*main.poolTask"); !ok {
+            return nil, err
+        }
+        if t, isTask := args[0].(*poolTask); isTask {
+            return t.toMap(), nil
+        }
+        return args[0].(*workerPool).toMap(), nil
+    }
+
+    slhelp["task_cancelled"] = LibHelp{in: "", out: "bool",
+        action: "Returns true when the pool task this function is running under has been cancelled or has passed its deadline.\n" +
+            "[#SOL]Always false outside a pool task."}
+    stdlib["task_cancelled"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("task_cancelled", args, 1, "0"); !ok {
+            return nil, err
+        }
+        if t := poolTaskAt(evalfs); t != nil {
+            return t.isCancelled(), nil
+        }
+        return false, nil
+    }
+
+}
@@ -0,0 +1,476 @@
+//go:build !test
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/*
+   locks and atomic counters for sharing state between async tasks.
+
+   · a zaLock is a mutex, or a read-write lock when created with kind "rw".
+     waiters sleep on a channel that is replaced every time the lock state
+     changes, so acquiring can give up after a timeout. a waiting writer
+     holds off new readers.
+   · locks and counters may be named; lock_new("x") in any task returns the
+     same lock, and every function accepts the name in place of the handle.
+   · with_lock() holds a lock for the length of a function call and always
+     releases it, whether the function returns, errors or throws.
+   · WITH LOCK l ... ENDWITH holds a lock for a block of statements. the lock
+     is released at ENDWITH, or as soon as execution leaves the block some
+     other way (BREAK, CONTINUE, RETURN, an error or a throw).
+*/
+
+type zaLock struct {
+    name     string
+    rw       bool
+    mu       sync.Mutex
+    writer   bool
+    readers  int
+    waiting  int           // writers waiting, which keeps new readers out
+    changed  chan struct{} // closed and replaced whenever the lock is released
+    acquired int64
+}
+
+type zaCounter struct {
+    name string
+    v    atomic.Int64
+}
+
+// lockBlock is a lock held by a WITH LOCK block, which covers the statements
+// from start to end (the ENDWITH).
+type lockBlock struct {
+    l          *zaLock
+    write      bool
+    start, end int16
+}
+
+var (
+    zaLocks    = make(map[string]*zaLock)
+    zaCounters = make(map[string]*zaCounter)
+    zaSyncLock sync.Mutex
+)
+
+func newZaLock(name string, rw bool) *zaLock {
+    return &zaLock{name: name, rw: rw, changed: make(chan struct{})}
+}
+
+func (l *zaLock) String() string {
+    if l.name == "" {
+        return fmt.Sprintf("lock(%s)", l.kind())
+    }
+    return fmt.Sprintf("lock(%s %s)", l.kind(), l.name)
+}
+
+func (l *zaLock) kind() string {
+    if l.rw {
+        return "rw"
+    }
+    return "mutex"
+}
+
+// wake releases everyone waiting for the lock state to change. l.mu must be held.
+func (l *zaLock) wake() {
+    close(l.changed)
+    l.changed = make(chan struct{})
+}
+
+// acquire takes the lock exclusively, or shared when write is false, waiting
+// up to timeout (-1 forever, 0 not at all). Reports whether it was taken.
+func (l *zaLock) acquire(write bool, timeout time.Duration) bool {
+    var expired <-chan time.Time
+    if timeout > 0 {
+        t := time.NewTimer(timeout)
+        defer t.Stop()
+        expired = t.C
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if write {
+        l.waiting++
+        defer func() { l.waiting-- }()
+    }
+    for {
+        if write && !l.writer && l.readers == 0 {
+            l.writer = true
+            break
+        }
+        if !write && !l.writer && l.waiting == 0 {
+            l.readers++
+            break
+        }
+        if timeout == 0 {
+            return false
+        }
+        changed := l.changed
+        l.mu.Unlock()
+        select {
+        case <-changed:
+            l.mu.Lock()
+        case <-expired:
+            l.mu.Lock()
+            if write {
+                // readers held off by this writer may now go ahead
+                l.wake()
+            }
+            return false
+        }
+    }
+    l.acquired++
+    return true
+}
+
+func (l *zaLock) release(write bool) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    switch {
+    case write && !l.writer:
+        return errors.New("lock is not held")
+    case write:
+        l.writer = false
+    case l.readers == 0:
+        return errors.New("lock is not read-locked")
+    default:
+        l.readers--
+    }
+    l.wake()
+    return nil
+}
+
+func (l *zaLock) toMap() map[string]any {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return map[string]any{
+        "name": l.name, "kind": l.kind(), "locked": l.writer, "readers": l.readers,
+        "waiting_writers": l.waiting, "acquisitions": int(l.acquired),
+    }
+}
+
+// namedLock returns the lock called name, creating it if needed.
+func namedLock(name string, rw bool) (*zaLock, error) {
+    zaSyncLock.Lock()
+    defer zaSyncLock.Unlock()
+    if l, found := zaLocks[name]; found {
+        if l.rw != rw {
+            return nil, fmt.Errorf("lock '%s' already exists as a %s", name, l.kind())
+        }
+        return l, nil
+    }
+    l := newZaLock(name, rw)
+    zaLocks[name] = l
+    return l, nil
+}
+
+// lockArg accepts a lock handle or the name of a lock.
+func lockArg(fname string, v any) (*zaLock, error) {
+    switch l := v.(type) {
+    case *zaLock:
+        return l, nil
+    case string:
+        zaSyncLock.Lock()
+        defer zaSyncLock.Unlock()
+        if found, ok := zaLocks[l]; ok {
+            return found, nil
+        }
+        return nil, fmt.Errorf("%s: unknown lock '%s'", fname, l)
+    }
+    return nil, fmt.Errorf("%s: expected a lock or lock name, not %T", fname, v)
+}
+
+// lockMode reads an optional "read" or "write" mode argument.
+func lockMode(fname string, l *zaLock, args []any, pos int) (bool, error) {
+    if len(args) <= pos {
+        return true, nil
+    }
+    mode, isString := args[pos].(string)
+    switch {
+    case !isString:
+        return false, fmt.Errorf("%s: mode must be \"read\" or \"write\"", fname)
+    case mode == "write" || mode == "w":
+        return true, nil
+    case mode == "read" || mode == "r":
+        if !l.rw {
+            return false, fmt.Errorf("%s: read mode needs a lock created with kind \"rw\"", fname)
+        }
+        return false, nil
+    }
+    return false, fmt.Errorf("%s: mode must be \"read\" or \"write\"", fname)
+}
+
+// counterArg accepts a counter handle or the name of a counter.
+func counterArg(fname string, v any) (*zaCounter, error) {
+    switch c := v.(type) {
+    case *zaCounter:
+        return c, nil
+    case string:
+        zaSyncLock.Lock()
+        defer zaSyncLock.Unlock()
+        if found, ok := zaCounters[c]; ok {
+            return found, nil
+        }
+        return nil, fmt.Errorf("%s: unknown counter '%s'", fname, c)
+    }
+    return nil, fmt.Errorf("%s: expected a counter or counter name, not %T", fname, v)
+}
+
+func (c *zaCounter) String() string {
+    return fmt.Sprintf("counter(%d)", c.v.Load())
+}
+
+func buildSyncLib() {
+
+    features["sync"] = Feature{version: 1, category: "concurrency"}
+    categories["sync"] = []string{"lock_new", "lock", "try_lock", "unlock", "with_lock", "lock_info",
+        "counter_new", "counter_add", "counter_get", "counter_set", "counter_cas",
+    }
+
+    slhelp["lock_new"] = LibHelp{in: "[name[,kind]]", out: "lock",
+        action: "Creates a lock for sharing state between async tasks. [#i1]kind[#i0] is \"mutex\" (the default) or \"rw\" for a read-write lock.\n" +
+            "[#SOL]A named lock is created once: later calls with the same name return it, and other lock functions accept the name in place of the handle."}
+    stdlib["lock_new"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("lock_new", args, 3,
+            "0",
+            "1", "string",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+        rw := false
+        if len(args) == 2 {
+            switch args[1].(string) {
+            case "mutex":
+            case "rw":
+                rw = true
+            default:
+                return nil, errors.New("lock_new: kind must be \"mutex\" or \"rw\"")
+            }
+        }
+        if len(args) == 0 || args[0].(string) == "" {
+            return newZaLock("", rw), nil
+        }
+        l, err := namedLock(args[0].(string), rw)
+        if err != nil {
+            return nil, fmt.Errorf("lock_new: %v", err)
+        }
+        return l, nil
+    }
+
+    slhelp["lock"] = LibHelp{in: "lock[,mode]", out: "bool",
+        action: "Waits for and takes [#i1]lock[#i0]. [#i1]mode[#i0] is \"write\" (the default) or \"read\" for a shared hold on a rw lock."}
+    stdlib["lock"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("lock", args, 2,
+            "1", "any",
+            "2", "any", "string"); !ok {
+            return nil, err
+        }
+        l, err := lockArg("lock", args[0])
+        if err != nil {
+            return nil, err
+        }
+        write, err := lockMode("lock", l, args, 1)
+        if err != nil {
+            return nil, err
+        }
+        return l.acquire(write, -1), nil
+    }
+
+    slhelp["try_lock"] = LibHelp{in: "lock[,timeout_seconds[,mode]]", out: "bool",
+        action: "Takes [#i1]lock[#i0] if it becomes free within [#i1]timeout_seconds[#i0] (default 0, do not wait). Returns false if it could not."}
+    stdlib["try_lock"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("try_lock", args, 3,
+            "1", "any",
+            "2", "any", "number",
+            "3", "any", "number", "string"); !ok {
+            return nil, err
+        }
+        l, err := lockArg("try_lock", args[0])
+        if err != nil {
+            return nil, err
+        }
+        timeout := time.Duration(0)
+        if len(args) > 1 {
+            if timeout, err = chanSeconds("try_lock", args, 1); err != nil {
+                return nil, err
+            }
+        }
+        write, err := lockMode("try_lock", l, args, 2)
+        if err != nil {
+            return nil, err
+        }
+        return l.acquire(write, timeout), nil
+    }
+
+    slhelp["unlock"] = LibHelp{in: "lock[,mode]", out: "bool",
+        action: "Releases [#i1]lock[#i0]. Use mode \"read\" to release a shared hold. Releasing a lock that is not held is an error."}
+    stdlib["unlock"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("unlock", args, 2,
+            "1", "any",
+            "2", "any", "string"); !ok {
+            return nil, err
+        }
+        l, err := lockArg("unlock", args[0])
+        if err != nil {
+            return nil, err
+        }
+        write, err := lockMode("unlock", l, args, 1)
+        if err != nil {
+            return nil, err
+        }
+        if err := l.release(write); err != nil {
+            return nil, fmt.Errorf("unlock: %v", err)
+        }
+        return true, nil
+    }
+
+    slhelp["with_lock"] = LibHelp{in: "lock,function_name[,args_list[,mode]]", out: "value",
+        action: "Calls [#i1]function_name[#i0] while holding [#i1]lock[#i0] and returns its result.\n" +
+            "[#SOL]The lock is released however the call ends; an uncaught throw in the function is raised again as an error.\n" +
+            "[#SOL]To hold a lock over statements in the current scope, use WITH LOCK [#i1]lock[#i0][,[#i1]mode[#i0]] ... ENDWITH."}
+    stdlib["with_lock"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("with_lock", args, 3,
+            "2", "any", "string",
+            "3", "any", "string", "any",
+            "4", "any", "string", "any", "string"); !ok {
+            return nil, err
+        }
+        l, err := lockArg("with_lock", args[0])
+        if err != nil {
+            return nil, err
+        }
+        fn, err := qualifyFnName(ns, args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("with_lock: %v", err)
+        }
+        var fargs []any
+        if len(args) > 2 {
+            rv := reflect.ValueOf(args[2])
+            if rv.Kind() != reflect.Slice {
+                return nil, fmt.Errorf("with_lock: args must be a list")
+            }
+            for i := 0; i < rv.Len(); i++ {
+                fargs = append(fargs, rv.Index(i).Interface())
+            }
+        }
+        write, err := lockMode("with_lock", l, args, 3)
+        if err != nil {
+            return nil, err
+        }
+        l.acquire(write, -1)
+        defer l.release(write)
+        ret, err = zaCallFn(fn, evalfs, ciEval, fargs...)
+        if err != nil {
+            return nil, fmt.Errorf("with_lock: %v", err)
+        }
+        return ret, nil
+    }
+
+    slhelp["lock_info"] = LibHelp{in: "lock", out: "map",
+        action: "Returns .name, .kind, .locked (held for writing), .readers, .waiting_writers and .acquisitions for [#i1]lock[#i0]."}
+    stdlib["lock_info"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("lock_info", args, 1, "1", "any"); !ok {
+            return nil, err
+        }
+        l, err := lockArg("lock_info", args[0])
+        if err != nil {
+            return nil, err
+        }
+        return l.toMap(), nil
+    }
+
+    slhelp["counter_new"] = LibHelp{in: "[name][,initial]", out: "counter",
+        action: "Creates an atomic integer counter, starting at [#i1]initial[#i0] (default 0).\n" +
+            "[#SOL]A named counter is created once: later calls with the same name return it, and other counter functions accept the name."}
+    stdlib["counter_new"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("counter_new", args, 4,
+            "0",
+            "1", "int",
+            "1", "string",
+            "2", "string", "int"); !ok {
+            return nil, err
+        }
+        c := &zaCounter{}
+        switch len(args) {
+        case 1:
+            if n, isInt := args[0].(int); isInt {
+                c.v.Store(int64(n))
+                return c, nil
+            }
+            c.name = args[0].(string)
+        case 2:
+            c.name = args[0].(string)
+            c.v.Store(int64(args[1].(int)))
+        }
+        if c.name == "" {
+            return c, nil
+        }
+        zaSyncLock.Lock()
+        defer zaSyncLock.Unlock()
+        if found, ok := zaCounters[c.name]; ok {
+            return found, nil
+        }
+        zaCounters[c.name] = c
+        return c, nil
+    }
+
+    slhelp["counter_add"] = LibHelp{in: "counter[,delta]", out: "int",
+        action: "Atomically adds [#i1]delta[#i0] (default 1) to [#i1]counter[#i0] and returns the new value."}
+    stdlib["counter_add"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("counter_add", args, 2,
+            "1", "any",
+            "2", "any", "int"); !ok {
+            return nil, err
+        }
+        c, err := counterArg("counter_add", args[0])
+        if err != nil {
+            return nil, err
+        }
+        delta := 1
+        if len(args) == 2 {
+            delta = args[1].(int)
+        }
+        return int(c.v.Add(int64(delta))), nil
+    }
+
+    slhelp["counter_get"] = LibHelp{in: "counter", out: "int", action: "Returns the current value of [#i1]counter[#i0]."}
+    stdlib["counter_get"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("counter_get", args, 1, "1", "any"); !ok {
+            return nil, err
+        }
+        c, err := counterArg("counter_get", args[0])
+        if err != nil {
+            return nil, err
+        }
+        return int(c.v.Load()), nil
+    }
+
+    slhelp["counter_set"] = LibHelp{in: "counter,value", out: "int", action: "Sets [#i1]counter[#i0] to [#i1]value[#i0] and returns the previous value."}
+    stdlib["counter_set"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("counter_set", args, 1, "2", "any", "int"); !ok {
+            return nil, err
+        }
+        c, err := counterArg("counter_set", args[0])
+        if err != nil {
+            return nil, err
+        }
+        return int(c.v.Swap(int64(args[1].(int)))), nil
+    }
+
+    slhelp["counter_cas"] = LibHelp{in: "counter,old,new", out: "bool",
+        action: "Sets [#i1]counter[#i0] to [#i1]new[#i0] only if it currently holds [#i1]old[#i0]. Returns true if it was set."}
+    stdlib["counter_cas"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("counter_cas", args, 1, "3", "any", "int", "int"); !ok {
+            return nil, err
+        }
+        c, err := counterArg("counter_cas", args[0])
+        if err != nil {
+            return nil, err
+        }
+        return c.v.CompareAndSwap(int64(args[1].(int)), int64(args[2].(int))), nil
+    }
+
+}
@@ -0,0 +1,285 @@
+//go:build !test
+
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    crand "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "fmt"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    str "strings"
+    "time"
+)
+
+/*
+   shared TLS configuration for the web server, the tcp server/client and
+   the http client, plus the self-signed certificate generator.
+*/
+
+var tlsClientAuthModes = map[string]tls.ClientAuthType{
+    "none":               tls.NoClientCert,
+    "request":            tls.RequestClientCert,
+    "require":            tls.RequireAnyClientCert,
+    "verify_if_given":    tls.VerifyClientCertIfGiven,
+    "require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+    pem, err := os.ReadFile(file)
+    if err != nil {
+        return nil, fmt.Errorf("could not read CA file: %v", err)
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificates found in CA file %s", file)
+    }
+    return pool, nil
+}
+
+// tlsServerConfig builds a server configuration from a Za options map.
+// Recognised keys: cert_file, key_file (both required), client_ca,
+// client_auth and min_version.
+func tlsServerConfig(fname string, opts map[string]any) (*tls.Config, error) {
+    var certFile, keyFile, clientCA, clientAuth, minVersion string
+    for k, v := range opts {
+        s, ok := v.(string)
+        if !ok {
+            return nil, fmt.Errorf("%s TLS option '%s' must be a string", fname, k)
+        }
+        switch k {
+        case "cert_file":
+            certFile = s
+        case "key_file":
+            keyFile = s
+        case "client_ca":
+            clientCA = s
+        case "client_auth":
+            clientAuth = s
+        case "min_version":
+            minVersion = s
+        default:
+            return nil, fmt.Errorf("%s: unknown TLS option '%s'", fname, k)
+        }
+    }
+    if certFile == "" || keyFile == "" {
+        return nil, fmt.Errorf("%s TLS options require both 'cert_file' and 'key_file'", fname)
+    }
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, fmt.Errorf("%s could not load certificate: %v", fname, err)
+    }
+    tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+    if clientCA != "" {
+        pool, err := loadCertPool(clientCA)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", fname, err)
+        }
+        tc.ClientCAs = pool
+        tc.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    if clientAuth != "" {
+        mode, found := tlsClientAuthModes[clientAuth]
+        if !found {
+            return nil, fmt.Errorf("%s TLS option 'client_auth' must be one of none, request, require, verify_if_given or require_and_verify", fname)
+        }
+        if (mode == tls.VerifyClientCertIfGiven || mode == tls.RequireAndVerifyClientCert) && tc.ClientCAs == nil {
+            return nil, fmt.Errorf("%s TLS option 'client_auth' %s needs 'client_ca'", fname, clientAuth)
+        }
+        tc.ClientAuth = mode
+    }
+    if minVersion != "" {
+        ver, found := tlsVersions[minVersion]
+        if !found {
+            return nil, fmt.Errorf("%s TLS option 'min_version' must be one of 1.0, 1.1, 1.2 or 1.3", fname)
+        }
+        tc.MinVersion = ver
+    }
+    return tc, nil
+}
+
+// tlsClientConfig builds a client configuration. caFile replaces the system
+// roots, certFile/keyFile present a client certificate.
+func tlsClientConfig(insecure bool, caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+    tc := &tls.Config{InsecureSkipVerify: insecure, ServerName: serverName}
+    if caFile != "" {
+        pool, err := loadCertPool(caFile)
+        if err != nil {
+            return nil, err
+        }
+        tc.RootCAs = pool
+    }
+    if certFile != "" || keyFile != "" {
+        if certFile == "" || keyFile == "" {
+            return nil, fmt.Errorf("options 'cert_file' and 'key_file' must be given together")
+        }
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            return nil, fmt.Errorf("could not load client certificate: %v", err)
+        }
+        tc.Certificates = []tls.Certificate{cert}
+    }
+    return tc, nil
+}
+
+// tlsClientOptions parses the client side TLS options map used by tcp_client().
+func tlsClientOptions(fname string, opts map[string]any) (*tls.Config, error) {
+    var insecure bool
+    var caFile, certFile, keyFile, serverName string
+    for k, v := range opts {
+        if k == "insecure" {
+            b, ok := v.(bool)
+            if !ok {
+                return nil, fmt.Errorf("%s TLS option 'insecure' must be a boolean", fname)
+            }
+            insecure = b
+            continue
+        }
+        s, ok := v.(string)
+        if !ok {
+            return nil, fmt.Errorf("%s TLS option '%s' must be a string", fname, k)
+        }
+        switch k {
+        case "ca_file":
+            caFile = s
+        case "cert_file":
+            certFile = s
+        case "key_file":
+            keyFile = s
+        case "server_name":
+            serverName = s
+        default:
+            return nil, fmt.Errorf("%s: unknown TLS option '%s'", fname, k)
+        }
+    }
+    tc, err := tlsClientConfig(insecure, caFile, certFile, keyFile, serverName)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", fname, err)
+    }
+    return tc, nil
+}
+
+// tlsPeerInfo summarises the verified client certificate of a connection, if any.
+func tlsPeerInfo(cs *tls.ConnectionState) map[string]any {
+    if cs == nil || len(cs.PeerCertificates) == 0 {
+        return nil
+    }
+    c := cs.PeerCertificates[0]
+    return map[string]any{
+        "subject":   c.Subject.String(),
+        "cn":        c.Subject.CommonName,
+        "issuer":    c.Issuer.String(),
+        "not_after": c.NotAfter.UTC().Format(time.RFC3339),
+    }
+}
+
+// tlsSelfSigned creates an ECDSA P-256 certificate for hosts, valid for days.
+// When caCert/caKey are given the certificate is issued by that CA, otherwise
+// it signs itself and may in turn be used as a CA.
+func tlsSelfSigned(hosts []string, cn string, days int, caCert *x509.Certificate, caKey any) (certPEM, keyPEM []byte, err error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+    serial, err := crand.Int(crand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+    if err != nil {
+        return nil, nil, err
+    }
+    if cn == "" && len(hosts) > 0 {
+        cn = hosts[0]
+    }
+
+    now := time.Now()
+    tmpl := &x509.Certificate{
+        SerialNumber:          serial,
+        Subject:               pkix.Name{CommonName: cn, Organization: []string{"za"}},
+        NotBefore:             now.Add(-time.Minute),
+        NotAfter:              now.Add(time.Duration(days) * 24 * time.Hour),
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        BasicConstraintsValid: true,
+    }
+    for _, h := range hosts {
+        if ip := net.ParseIP(h); ip != nil {
+            tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+        } else {
+            tmpl.DNSNames = append(tmpl.DNSNames, h)
+        }
+    }
+
+    parent, signer := tmpl, any(key)
+    if caCert != nil {
+        parent, signer = caCert, caKey
+    } else {
+        tmpl.IsCA = true
+        tmpl.KeyUsage |= x509.KeyUsageCertSign
+    }
+
+    der, err := x509.CreateCertificate(crand.Reader, tmpl, parent, &key.PublicKey, signer)
+    if err != nil {
+        return nil, nil, err
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        return nil, nil, err
+    }
+    certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+    keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+    return certPEM, keyPEM, nil
+}
+
+// tlsLoadCA reads a CA certificate and key for signing.
+func tlsLoadCA(fname, certFile, keyFile string) (*x509.Certificate, any, error) {
+    pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s could not load CA: %v", fname, err)
+    }
+    ca, err := x509.ParseCertificate(pair.Certificate[0])
+    if err != nil {
+        return nil, nil, fmt.Errorf("%s could not parse CA certificate: %v", fname, err)
+    }
+    if !ca.IsCA {
+        return nil, nil, fmt.Errorf("%s: %s is not a CA certificate", fname, certFile)
+    }
+    return ca, pair.PrivateKey, nil
+}
+
+// tlsWritePair stores a certificate and key as <dir>/<name>.crt and <dir>/<name>.key.
+func tlsWritePair(dir, name string, certPEM, keyPEM []byte) (string, string, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return "", "", err
+    }
+    certFile := filepath.Join(dir, name+".crt")
+    keyFile := filepath.Join(dir, name+".key")
+    if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+        return "", "", err
+    }
+    if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+        return "", "", err
+    }
+    return certFile, keyFile, nil
+}
+
+func tlsSafeName(s string) string {
+    return str.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+            return r
+        }
+        return '_'
+    }, s)
+}
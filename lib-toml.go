@@ -0,0 +1,780 @@
+//go:build !test
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "math"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/BurntSushi/toml"
+)
+
+// TOML support mirrors lib-yaml.go: documents parse to Za maps and arrays and
+// the toml_get/set/delete functions use the yaml_* dot/index path syntax. A
+// key[n] segment selects element n of the array held by key, which is how
+// arrays of tables are reached (upstream[0].name).
+//
+// When toml_set or toml_delete is given TOML text rather than parsed data the
+// edit is made in place on the text, so comments, ordering and layout survive.
+// The edited text is re-parsed and compared with the same edit applied to the
+// parsed data; if they differ (or the edit cannot be expressed textually) the
+// document is re-marshalled instead, which loses comments.
+
+func buildTomlLib() {
+    features["toml"] = Feature{version: 1, category: "data"}
+    categories["toml"] = []string{"toml_parse", "toml_marshal", "toml_get", "toml_set", "toml_delete"}
+
+    slhelp["toml_parse"] = LibHelp{in: "toml_string", out: "map", action: "Parse TOML string to Za data structures. Date/time values are returned as strings."}
+    stdlib["toml_parse"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("toml_parse", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        result, err := parseTOML(args[0].(string))
+        if err != nil {
+            return nil, fmt.Errorf("toml_parse error: %v", err)
+        }
+        return result, nil
+    }
+
+    slhelp["toml_marshal"] = LibHelp{in: "data", out: "string", action: "Convert a Za map (or struct) to a TOML string."}
+    stdlib["toml_marshal"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("toml_marshal", args, 1, "1", "any"); !ok {
+            return nil, err
+        }
+        tomlString, err := marshalTOML(args[0])
+        if err != nil {
+            return nil, fmt.Errorf("toml_marshal error: %v", err)
+        }
+        return tomlString, nil
+    }
+
+    slhelp["toml_get"] = LibHelp{in: "data_or_string, path", out: "any", action: "Get value from TOML data (or TOML text) using dot notation path (e.g., 'servers[0].ports[1]')."}
+    stdlib["toml_get"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("toml_get", args, 2, "2", "any", "string"); !ok {
+            return nil, err
+        }
+        data := args[0]
+        if text, isText := data.(string); isText {
+            if data, err = parseTOML(text); err != nil {
+                return nil, fmt.Errorf("toml_get error: %v", err)
+            }
+        }
+        result, err := tomlGet(data, args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("toml_get error: %v", err)
+        }
+        return result, nil
+    }
+
+    slhelp["toml_set"] = LibHelp{in: "data_or_string, path, value", out: "any", action: "Set value in TOML data using dot notation path. Returns modified data.\n" +
+        "[#SOL]Given TOML text, returns edited text with comments and layout preserved where possible."}
+    stdlib["toml_set"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("toml_set", args, 3, "3", "any", "string", "any"); !ok {
+            return nil, err
+        }
+        path := args[1].(string)
+        if text, isText := args[0].(string); isText {
+            result, err := tomlEditText(text, path, args[2], false)
+            if err != nil {
+                return nil, fmt.Errorf("toml_set error: %v", err)
+            }
+            return result, nil
+        }
+        result, err := tomlSet(args[0], path, args[2])
+        if err != nil {
+            return nil, fmt.Errorf("toml_set error: %v", err)
+        }
+        return result, nil
+    }
+
+    slhelp["toml_delete"] = LibHelp{in: "data_or_string, path", out: "any", action: "Delete value from TOML data using dot notation path. Returns modified data.\n" +
+        "[#SOL]Given TOML text, returns edited text with comments and layout preserved where possible."}
+    stdlib["toml_delete"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("toml_delete", args, 2, "2", "any", "string"); !ok {
+            return nil, err
+        }
+        path := args[1].(string)
+        if text, isText := args[0].(string); isText {
+            result, err := tomlEditText(text, path, nil, true)
+            if err != nil {
+                return nil, fmt.Errorf("toml_delete error: %v", err)
+            }
+            return result, nil
+        }
+        result, err := tomlDelete(args[0], path)
+        if err != nil {
+            return nil, fmt.Errorf("toml_delete error: %v", err)
+        }
+        return result, nil
+    }
+}
+
+// tomlSteps splits a path into one step per map key or array index, so that
+// key[n] becomes the key followed by the index.
+func tomlSteps(path string) []pathPart {
+    var steps []pathPart
+    for _, p := range parsePath(path) {
+        if p.index < 0 {
+            steps = append(steps, p)
+            continue
+        }
+        if p.key != "" {
+            steps = append(steps, pathPart{key: p.key, index: -1})
+        }
+        steps = append(steps, pathPart{index: p.index})
+    }
+    return steps
+}
+
+// tomlWalk follows steps from data and returns the value reached.
+func tomlWalk(data any, steps []pathPart) (any, error) {
+    current := data
+    for _, step := range steps {
+        switch v := current.(type) {
+        case map[string]any:
+            if step.index >= 0 {
+                return nil, fmt.Errorf("cannot index a table with [%d]", step.index)
+            }
+            value, exists := v[step.key]
+            if !exists {
+                return nil, fmt.Errorf("key '%s' not found", step.key)
+            }
+            current = value
+        case []any:
+            if step.index < 0 {
+                return nil, fmt.Errorf("cannot access '%s' in an array", step.key)
+            }
+            if step.index >= len(v) {
+                return nil, fmt.Errorf("index %d out of bounds (length: %d)", step.index, len(v))
+            }
+            current = v[step.index]
+        default:
+            return nil, fmt.Errorf("cannot access '%s' in type %T", pathPartsToStrings([]pathPart{step})[0], current)
+        }
+    }
+    return current, nil
+}
+
+func tomlGet(data any, path string) (any, error) {
+    return tomlWalk(data, tomlSteps(path))
+}
+
+func tomlSet(data any, path string, value any) (any, error) {
+    return tomlSetSteps(data, tomlSteps(path), value)
+}
+
+func tomlSetSteps(data any, steps []pathPart, value any) (any, error) {
+    if len(steps) == 0 {
+        return nil, fmt.Errorf("empty path")
+    }
+    parent, err := tomlWalk(data, steps[:len(steps)-1])
+    if err != nil {
+        return nil, err
+    }
+    last := steps[len(steps)-1]
+    switch v := parent.(type) {
+    case map[string]any:
+        if last.index >= 0 {
+            return nil, fmt.Errorf("cannot index a table with [%d]", last.index)
+        }
+        v[last.key] = value
+    case []any:
+        if last.index < 0 {
+            return nil, fmt.Errorf("cannot set '%s' in an array", last.key)
+        }
+        if last.index >= len(v) {
+            return nil, fmt.Errorf("index %d out of bounds (length: %d)", last.index, len(v))
+        }
+        v[last.index] = value
+    default:
+        return nil, fmt.Errorf("cannot set value in type %T", parent)
+    }
+    return data, nil
+}
+
+func tomlDelete(data any, path string) (any, error) {
+    steps := tomlSteps(path)
+    if len(steps) == 0 {
+        return nil, fmt.Errorf("empty path")
+    }
+    parent, err := tomlWalk(data, steps[:len(steps)-1])
+    if err != nil {
+        return nil, err
+    }
+    last := steps[len(steps)-1]
+    switch v := parent.(type) {
+    case map[string]any:
+        if last.index >= 0 {
+            return nil, fmt.Errorf("cannot index a table with [%d]", last.index)
+        }
+        delete(v, last.key)
+    case []any:
+        if last.index < 0 {
+            return nil, fmt.Errorf("cannot delete '%s' in an array", last.key)
+        }
+        if last.index >= len(v) {
+            return nil, fmt.Errorf("index %d out of bounds (length: %d)", last.index, len(v))
+        }
+        // the shorter array replaces the original in its parent
+        shorter := append(v[:last.index:last.index], v[last.index+1:]...)
+        return tomlSetSteps(data, steps[:len(steps)-1], shorter)
+    default:
+        return nil, fmt.Errorf("cannot delete from type %T", parent)
+    }
+    return data, nil
+}
+
+func parseTOML(input string) (map[string]any, error) {
+    var result map[string]any
+    if _, err := toml.Decode(input, &result); err != nil {
+        return nil, err
+    }
+    return tomlToZa(result).(map[string]any), nil
+}
+
+// tomlToZa converts decoded TOML values to the types Za works with.
+func tomlToZa(v any) any {
+    switch t := v.(type) {
+    case map[string]any:
+        for k, e := range t {
+            t[k] = tomlToZa(e)
+        }
+        return t
+    case []map[string]any:
+        arr := make([]any, len(t))
+        for i, e := range t {
+            arr[i] = tomlToZa(e)
+        }
+        return arr
+    case []any:
+        for i, e := range t {
+            t[i] = tomlToZa(e)
+        }
+        return t
+    case int64:
+        return int(t)
+    case time.Time:
+        // local date/time values are decoded with a marker time zone
+        switch t.Location().String() {
+        case "date-local":
+            return t.Format("2006-01-02")
+        case "time-local":
+            return t.Format("15:04:05.999999999")
+        case "datetime-local":
+            return t.Format("2006-01-02T15:04:05.999999999")
+        }
+        return t.Format(time.RFC3339Nano)
+    }
+    return v
+}
+
+func marshalTOML(data any) (string, error) {
+    prepared, err := tomlFromZa(data)
+    if err != nil {
+        return "", err
+    }
+    if _, isMap := prepared.(map[string]any); !isMap {
+        return "", fmt.Errorf("top level value must be a map (got %T)", data)
+    }
+    var buf bytes.Buffer
+    if err := toml.NewEncoder(&buf).Encode(prepared); err != nil {
+        return "", err
+    }
+    return buf.String(), nil
+}
+
+// tomlFromZa copies Za data into a form the encoder accepts: structs become
+// maps and arrays holding only maps become arrays of tables.
+func tomlFromZa(v any) (any, error) {
+    switch t := v.(type) {
+    case nil:
+        return nil, fmt.Errorf("TOML has no null value")
+    case map[string]any:
+        m := make(map[string]any, len(t))
+        for k, e := range t {
+            c, err := tomlFromZa(e)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %v", k, err)
+            }
+            m[k] = c
+        }
+        return m, nil
+    case []any:
+        arr := make([]any, len(t))
+        allMaps := len(t) > 0
+        for i, e := range t {
+            c, err := tomlFromZa(e)
+            if err != nil {
+                return nil, fmt.Errorf("[%d]: %v", i, err)
+            }
+            arr[i] = c
+            if _, isMap := c.(map[string]any); !isMap {
+                allMaps = false
+            }
+        }
+        if allMaps {
+            tables := make([]map[string]any, len(arr))
+            for i, e := range arr {
+                tables[i] = e.(map[string]any)
+            }
+            return tables, nil
+        }
+        return arr, nil
+    }
+    if reflect.TypeOf(v).Kind() == reflect.Struct {
+        return tomlFromZa(s2m(v))
+    }
+    return v, nil
+}
+
+// tomlItem is a header or key/value statement located in TOML text.
+type tomlItem struct {
+    path     string // canonical path, as produced by pathPartsToStrings
+    header   bool
+    start    int // start of the statement's line
+    valStart int // value span, for key/value items
+    valEnd   int
+    end      int // just past the statement's line ending
+}
+
+var tomlBareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlJoinPath(prefix, key string) string {
+    if prefix == "" {
+        return key
+    }
+    return prefix + "." + key
+}
+
+// scanTOML locates every table header and key/value statement in s.
+func scanTOML(s string) ([]tomlItem, error) {
+    var items []tomlItem
+    arrays := make(map[string]int) // array-of-tables path -> element count
+    table := ""
+    pos := 0
+    for pos < len(s) {
+        lineStart := pos
+        pos = tomlSkipBlank(s, pos)
+        if pos >= len(s) {
+            break
+        }
+        switch s[pos] {
+        case '\r', '\n', '#':
+            pos = tomlLineEnd(s, pos)
+            continue
+        case '[':
+            isArray := strings.HasPrefix(s[pos:], "[[")
+            pos++
+            if isArray {
+                pos++
+            }
+            keys, next, err := tomlScanKey(s, pos)
+            if err != nil {
+                return nil, err
+            }
+            pos = tomlSkipBlank(s, next)
+            closer := "]"
+            if isArray {
+                closer = "]]"
+            }
+            if !strings.HasPrefix(s[pos:], closer) {
+                return nil, fmt.Errorf("malformed table header at offset %d", lineStart)
+            }
+            pos += len(closer)
+            table = ""
+            for i, k := range keys {
+                table = tomlJoinPath(table, k)
+                if isArray && i == len(keys)-1 {
+                    arrays[table]++
+                    table += "[" + strconv.Itoa(arrays[table]-1) + "]"
+                } else if n, found := arrays[table]; found {
+                    table += "[" + strconv.Itoa(n-1) + "]"
+                }
+            }
+            end, err := tomlRestOfLine(s, pos)
+            if err != nil {
+                return nil, err
+            }
+            items = append(items, tomlItem{path: table, header: true, start: lineStart, end: end})
+            pos = end
+        default:
+            keys, next, err := tomlScanKey(s, pos)
+            if err != nil {
+                return nil, err
+            }
+            pos = tomlSkipBlank(s, next)
+            if pos >= len(s) || s[pos] != '=' {
+                return nil, fmt.Errorf("expected '=' at offset %d", pos)
+            }
+            valStart := tomlSkipBlank(s, pos+1)
+            valEnd := tomlScanValue(s, valStart)
+            end, err := tomlRestOfLine(s, valEnd)
+            if err != nil {
+                return nil, err
+            }
+            path := table
+            for _, k := range keys {
+                path = tomlJoinPath(path, k)
+            }
+            items = append(items, tomlItem{path: path, start: lineStart, valStart: valStart, valEnd: valEnd, end: end})
+            pos = end
+        }
+    }
+    return items, nil
+}
+
+func tomlSkipBlank(s string, pos int) int {
+    for pos < len(s) && (s[pos] == ' ' || s[pos] == '\t') {
+        pos++
+    }
+    return pos
+}
+
+// tomlLineEnd returns the position just past the newline at or after pos.
+func tomlLineEnd(s string, pos int) int {
+    if i := strings.IndexByte(s[pos:], '\n'); i >= 0 {
+        return pos + i + 1
+    }
+    return len(s)
+}
+
+// tomlRestOfLine accepts optional whitespace and a comment after a statement.
+func tomlRestOfLine(s string, pos int) (int, error) {
+    pos = tomlSkipBlank(s, pos)
+    if pos >= len(s) {
+        return pos, nil
+    }
+    switch s[pos] {
+    case '#', '\r', '\n':
+        return tomlLineEnd(s, pos), nil
+    }
+    return 0, fmt.Errorf("unexpected text at offset %d", pos)
+}
+
+// tomlScanKey reads a possibly dotted key, returning its unquoted segments.
+func tomlScanKey(s string, pos int) ([]string, int, error) {
+    var keys []string
+    for {
+        pos = tomlSkipBlank(s, pos)
+        if pos >= len(s) {
+            return nil, pos, fmt.Errorf("expected key at end of input")
+        }
+        switch s[pos] {
+        case '"':
+            end := tomlStringEnd(s, pos, `"`)
+            k, err := strconv.Unquote(s[pos:end])
+            if err != nil {
+                k = s[pos+1 : end-1]
+            }
+            keys = append(keys, k)
+            pos = end
+        case '\'':
+            end := tomlStringEnd(s, pos, `'`)
+            keys = append(keys, s[pos+1:end-1])
+            pos = end
+        default:
+            start := pos
+            for pos < len(s) && (s[pos] == '_' || s[pos] == '-' ||
+                (s[pos] >= 'A' && s[pos] <= 'Z') || (s[pos] >= 'a' && s[pos] <= 'z') || (s[pos] >= '0' && s[pos] <= '9')) {
+                pos++
+            }
+            if pos == start {
+                return nil, pos, fmt.Errorf("invalid key at offset %d", pos)
+            }
+            keys = append(keys, s[start:pos])
+        }
+        pos = tomlSkipBlank(s, pos)
+        if pos < len(s) && s[pos] == '.' {
+            pos++
+            continue
+        }
+        return keys, pos, nil
+    }
+}
+
+// tomlStringEnd returns the position just past the string opened at pos by delim.
+func tomlStringEnd(s string, pos int, delim string) int {
+    i := pos + len(delim)
+    for i < len(s) {
+        if delim[0] == '"' && s[i] == '\\' {
+            i += 2
+            continue
+        }
+        if strings.HasPrefix(s[i:], delim) {
+            i += len(delim)
+            // a multi-line string may end with up to two extra quote characters
+            for n := 0; n < 2 && len(delim) == 3 && i < len(s) && s[i] == delim[0]; n++ {
+                i++
+            }
+            return i
+        }
+        if len(delim) == 1 && s[i] == '\n' {
+            return i
+        }
+        i++
+    }
+    return len(s)
+}
+
+// tomlScanValue returns the end of the value starting at pos, which may
+// span lines inside arrays, inline tables and multi-line strings.
+func tomlScanValue(s string, pos int) int {
+    depth := 0
+    end := pos
+    for pos < len(s) {
+        c := s[pos]
+        switch {
+        case strings.HasPrefix(s[pos:], `"""`):
+            pos = tomlStringEnd(s, pos, `"""`)
+        case strings.HasPrefix(s[pos:], `'''`):
+            pos = tomlStringEnd(s, pos, `'''`)
+        case c == '"':
+            pos = tomlStringEnd(s, pos, `"`)
+        case c == '\'':
+            pos = tomlStringEnd(s, pos, `'`)
+        case c == '[' || c == '{':
+            depth++
+            pos++
+        case c == ']' || c == '}':
+            depth--
+            pos++
+        case c == '#':
+            if depth == 0 {
+                return end
+            }
+            pos = tomlLineEnd(s, pos)
+            continue
+        case c == '\r' || c == '\n':
+            if depth <= 0 {
+                return end
+            }
+            pos++
+            continue
+        case c == ' ' || c == '\t':
+            pos++
+            continue
+        default:
+            pos++
+        }
+        end = pos
+    }
+    return end
+}
+
+// tomlFormatKey quotes a key segment when it is not a valid bare key.
+func tomlFormatKey(k string) string {
+    if tomlBareKeyRe.MatchString(k) {
+        return k
+    }
+    return strconv.Quote(k)
+}
+
+// tomlFormatValue renders a Za value as an inline TOML value.
+func tomlFormatValue(v any) (string, error) {
+    switch t := v.(type) {
+    case nil:
+        return "", fmt.Errorf("TOML has no null value")
+    case string:
+        return strconv.Quote(t), nil
+    case bool:
+        return strconv.FormatBool(t), nil
+    case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+        return fmt.Sprint(t), nil
+    case float32, float64:
+        f, _ := GetAsFloat(t)
+        switch {
+        case math.IsNaN(f):
+            return "nan", nil
+        case math.IsInf(f, 1):
+            return "inf", nil
+        case math.IsInf(f, -1):
+            return "-inf", nil
+        }
+        s := strconv.FormatFloat(f, 'g', -1, 64)
+        if !strings.ContainsAny(s, ".eE") {
+            s += ".0"
+        }
+        return s, nil
+    case []any:
+        parts := make([]string, len(t))
+        for i, e := range t {
+            p, err := tomlFormatValue(e)
+            if err != nil {
+                return "", err
+            }
+            parts[i] = p
+        }
+        return "[" + strings.Join(parts, ", ") + "]", nil
+    case map[string]any:
+        keys := make([]string, 0, len(t))
+        for k := range t {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        parts := make([]string, len(keys))
+        for i, k := range keys {
+            p, err := tomlFormatValue(t[k])
+            if err != nil {
+                return "", err
+            }
+            parts[i] = tomlFormatKey(k) + " = " + p
+        }
+        if len(parts) == 0 {
+            return "{}", nil
+        }
+        return "{ " + strings.Join(parts, ", ") + " }", nil
+    }
+    if reflect.TypeOf(v).Kind() == reflect.Struct {
+        return tomlFormatValue(s2m(v))
+    }
+    return "", fmt.Errorf("cannot represent %T in TOML", v)
+}
+
+// tomlEditText applies a set (or delete) to TOML text. The edit is first made
+// on the parsed data, which also validates the path; the text edit is kept
+// only if it parses back to that same data.
+func tomlEditText(text, path string, value any, remove bool) (string, error) {
+    data, err := parseTOML(text)
+    if err != nil {
+        return "", err
+    }
+    var edited any
+    if remove {
+        edited, err = tomlDelete(data, path)
+    } else {
+        edited, err = tomlSet(data, path, value)
+    }
+    if err != nil {
+        return "", err
+    }
+
+    target := strings.Join(pathPartsToStrings(parsePath(path)), ".")
+    var newText string
+    var ok bool
+    if remove {
+        newText, ok = tomlDeleteText(text, target)
+    } else {
+        newText, ok = tomlSetText(text, target, value)
+    }
+    if ok {
+        if check, err := parseTOML(newText); err == nil && reflect.DeepEqual(check, edited) {
+            return newText, nil
+        }
+    }
+    return marshalTOML(edited)
+}
+
+func tomlSetText(text, target string, value any) (string, bool) {
+    formatted, err := tomlFormatValue(value)
+    if err != nil {
+        return "", false
+    }
+    items, err := scanTOML(text)
+    if err != nil {
+        return "", false
+    }
+    for _, it := range items {
+        if !it.header && it.path == target {
+            return text[:it.valStart] + formatted + text[it.valEnd:], true
+        }
+    }
+
+    // new key: add it at the end of its parent table's section
+    parent, key := "", target
+    if i := strings.LastIndexByte(target, '.'); i >= 0 {
+        parent, key = target[:i], target[i+1:]
+    }
+    if strings.Contains(key, "[") {
+        return "", false
+    }
+    line := tomlFormatKey(key) + " = " + formatted + "\n"
+
+    inSection := parent == ""
+    found := inSection
+    insertAt, indent := -1, ""
+    for _, it := range items {
+        if it.header {
+            if inSection && insertAt < 0 && parent == "" {
+                insertAt = 0 // root table has no keys yet
+            }
+            inSection = it.path == parent
+            if inSection {
+                found, insertAt, indent = true, it.end, ""
+            }
+            continue
+        }
+        if inSection {
+            insertAt = it.end
+            indent = text[it.start:tomlSkipBlank(text, it.start)]
+        }
+    }
+    if !found {
+        if strings.Contains(parent, "[") {
+            return "", false
+        }
+        var hdr []string
+        for _, k := range strings.Split(parent, ".") {
+            hdr = append(hdr, tomlFormatKey(k))
+        }
+        if text != "" && !strings.HasSuffix(text, "\n") {
+            text += "\n"
+        }
+        return text + "\n[" + strings.Join(hdr, ".") + "]\n" + line, true
+    }
+    if insertAt < 0 {
+        insertAt = len(text)
+    }
+    if insertAt > 0 && text[insertAt-1] != '\n' {
+        line = "\n" + line
+    }
+    return text[:insertAt] + indent + line + text[insertAt:], true
+}
+
+func tomlDeleteText(text, target string) (string, bool) {
+    items, err := scanTOML(text)
+    if err != nil {
+        return "", false
+    }
+    matches := func(p string) bool {
+        return p == target || strings.HasPrefix(p, target+".") || strings.HasPrefix(p, target+"[")
+    }
+    type span struct{ from, to int }
+    var cuts []span
+    for i, it := range items {
+        if !matches(it.path) {
+            continue
+        }
+        to := it.end
+        if it.header {
+            // remove the section body up to its last statement
+            for j := i + 1; j < len(items) && !items[j].header; j++ {
+                to = items[j].end
+            }
+        }
+        if len(cuts) > 0 && it.start <= cuts[len(cuts)-1].to {
+            if to > cuts[len(cuts)-1].to {
+                cuts[len(cuts)-1].to = to
+            }
+            continue
+        }
+        cuts = append(cuts, span{it.start, to})
+    }
+    if len(cuts) == 0 {
+        return "", false
+    }
+    var sb strings.Builder
+    last := 0
+    for _, c := range cuts {
+        sb.WriteString(text[last:c.from])
+        last = c.to
+    }
+    sb.WriteString(text[last:])
+    return sb.String(), true
+}
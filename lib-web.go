@@ -20,6 +20,7 @@ import (
     "os"
     "path/filepath"
     "regexp"
+    "sort"
     str "strings"
     "sync"
     "sync/atomic"
@@ -49,6 +50,8 @@ type web_table_entry struct {
     port    int    // which tcp port to create a multiplexor on.
     srv     *http.Server
     mux     *http.ServeMux
+    tls     bool  // serving https
+    maxBody int64 // request body limit for route handlers, in bytes
 }
 
 var web_handles = make(map[string]web_table_entry)
@@ -94,6 +97,11 @@ func (r *webResponseRecorder) WriteHeader(code int) {
     r.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, for websocket hijacking.
+func (r *webResponseRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
 func (r *webResponseRecorder) Write(b []byte) (int, error) {
     if r.status == 0 {
         r.status = http.StatusOK
@@ -368,6 +376,7 @@ func webLookup(host string) string {
 
 func webClose(h string) {
     wlog("* Closing server (%s).\n", h)
+    wsCloseServer(h)
     weblock.Lock()
     web_handles[h].srv.Shutdown(context.Background())
     delete(web_handles, h)
@@ -378,6 +387,7 @@ func webCloseAll() {
     weblock.Lock()
     for h, s := range web_handles {
         wlog("* Closing server (%s) : %+v\n", h, s)
+        wsCloseServer(h)
         s.srv.Shutdown(context.Background())
         delete(web_handles, h)
     }
@@ -396,6 +406,18 @@ func webRoutesAll() {
 }
 
 func webRoutes(uid string) {
+    webrulelock.RLock()
+    defer webrulelock.RUnlock()
+    for _, mw := range web_middleware[uid] {
+        pf("* %s : middleware %s* -> %s\n", uid, mw.prefix, mw.fn)
+    }
+    for _, rt := range web_routes[uid] {
+        methods := "*"
+        if len(rt.methods) > 0 {
+            methods = str.Join(rt.methods, ",")
+        }
+        pf("* %s : route %s %s -> %s\n", uid, methods, rt.pattern, rt.fn)
+    }
     for _, action := range web_rules[uid] {
         pf("* %s : %s %s -> %+v\n", uid, action.code, action.in, action.mutation)
     }
@@ -475,6 +497,9 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
     scheme := purl.Scheme  // http/https/etc
     if scheme == "" {
         scheme = "http"
+        if r.TLS != nil {
+            scheme = "https"
+        }
     }
     _ = header
 
@@ -518,6 +543,13 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
     wr_copy := web_rules[handle]
     webrulelock.RUnlock()
 
+    // method+path routes take precedence over the rules
+    if route, handled := webDispatchRoute(w, r, handle, evalfs, use_gzip); handled {
+        matchedRoute = route
+        wlog("%s routed %s %s for %s.\n", host, method, path, remoteIp)
+        return
+    }
+
     for _, rule := range wr_copy {
 
         switch rule.code {
@@ -594,7 +626,7 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
                     // log_nq,_:=url.QueryUnescape(nq)
                     // wlog("* GET Proxying this URL: %s\n",log_nq)
 
-                    content, down_code, header = download(nq)
+                    content, down_code, header = download(nq, newHttpOptions())
 
                     w.Header().Add("proxied-by-za", "true")
                     for k, v := range header {
@@ -616,7 +648,7 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
                     // log_nq,_:=url.QueryUnescape(nq)
                     // wlog("* HEAD Proxying this URL: %s\n",log_nq)
 
-                    content, down_code = head(nq)
+                    content, down_code = head(nq, newHttpOptions())
 
                     w.Header().Add("proxied-by-za", "true")
                     for k, v := range header {
@@ -782,11 +814,16 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
                 ifn, _ = fnlookup.lmget(fn)
                 loc, _ := GetNextFnSpace(true, fn+"@", call_s{prepared: true, base: ifn, caller: evalfs})
 
+                // the handler may stream its response instead of returning it
+                st := newWebStream(w, r)
+                webStreamBind(loc, st)
+
                 ctx := withProfilerContext(context.Background())
                 var ident = make([]Variable, identInitialSize)
                 atomic.AddInt32(&concurrent_funcs, 1)
                 rcount, _, _, _, errVal := Call(ctx, MODE_NEW, &ident, loc, ciLnet, false, nil, "", []string{}, nil, webcallstruct)
                 atomic.AddInt32(&concurrent_funcs, -1)
+                webStreamUnbind(loc, st)
 
                 if errVal != nil {
                     panic(sf("error in web router called function (%s)", fn))
@@ -794,10 +831,12 @@ func webRouter(origW http.ResponseWriter, r *http.Request) {
 
                 calllock.Lock()
                 tmp := calltable[loc].retvals
-                switch rcount {
-                case 0:
+                switch {
+                case st.isStarted():
+                    // the handler has already streamed its response
+                case rcount == 0:
                     writeResponse(w, []byte(""), use_gzip)
-                case 1:
+                case rcount == 1:
                     switch tmp.(type) {
                     case nil:
                         // translate bad func call to error page
@@ -936,11 +975,15 @@ var webrulelock = &sync.RWMutex{}
 func buildWebLib() {
 
     // persistent http client
-    web_tr = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: false}}
+    // Go's default dial, TLS handshake and idle timeouts, but no proxy unless a
+    // request asks for one with .proxy
+    web_tr = http.DefaultTransport.(*http.Transport).Clone()
+    web_tr.Proxy = nil
+    web_tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: false}
     web_client = &http.Client{Transport: web_tr}
 
     features["web"] = Feature{version: 1, category: "web"}
-    categories["web"] = []string{"web_download", "web_head", "web_get", "web_custom", "web_post", "web_raw_send", "web_serve_start", "web_serve_stop", "web_serve_up", "web_serve_path", "web_serve_log_throttle", "web_display", "web_serve_decode", "web_serve_log", "web_max_clients", "net_interfaces", "html_escape", "html_unescape", "download",         "web_cache_enable", "web_cache_max_size", "web_cache_max_age", "web_cache_cleanup_interval", "web_cache_max_memory", "web_cache_purge", "web_cache_stats", "web_gzip_enable", "web_template"}
+    categories["web"] = []string{"http_request", "http_breaker_status", "http_breaker_reset", "web_download", "web_head", "web_get", "web_custom", "web_post", "web_raw_send", "web_serve_start", "web_serve_stop", "web_serve_up", "web_serve_path", "web_route", "web_middleware", "web_response", "web_json", "web_stream", "web_stream_write", "web_stream_closed", "web_sse", "web_sse_send", "ws_connect", "ws_send", "ws_receive", "ws_ping", "ws_close", "ws_info", "ws_list", "ws_broadcast", "web_serve_log_throttle", "web_display", "web_serve_decode", "web_serve_log", "web_max_clients", "net_interfaces", "html_escape", "html_unescape", "download",         "web_cache_enable", "web_cache_max_size", "web_cache_max_age", "web_cache_cleanup_interval", "web_cache_max_memory", "web_cache_purge", "web_cache_stats", "web_gzip_enable", "web_template"}
 
     // listenandserve always fires off a server we don't fully control. The Serve() part returns a non-nil
     // error under all circumstances. We'll have track handles against ip/port here.
@@ -1009,9 +1052,13 @@ func buildWebLib() {
         return nil, err
     }
 
-    slhelp["web_serve_start"] = LibHelp{in: "docroot,port[,vhost]", out: "handle", action: "Returns an identifier for a new http server."}
+    slhelp["web_serve_start"] = LibHelp{in: "docroot,port[,vhost[,options]]", out: "handle", action: "Returns an identifier for a new http server.\n" +
+        "[#SOL][#i1]options[#i0]: .max_body limits request bodies read for route handlers (bytes, default 4MiB); larger bodies get a 413 reply.\n" +
+        "[#SOL].cert_file and .key_file serve HTTPS, with .client_ca to verify client certificates (mTLS),\n" +
+        "[#SOL].client_auth (none, request, require, verify_if_given or require_and_verify) and .min_version (1.2 by default)."}
     stdlib["web_serve_start"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_serve_start", args, 2,
+        if ok, err := expect_args("web_serve_start", args, 3,
+            "4", "string", "int", "string", "map[string]interface {}",
             "3", "string", "int", "string",
             "2", "string", "int"); !ok {
             return nil, err
@@ -1023,7 +1070,7 @@ func buildWebLib() {
         docroot := args[0].(string)
         port := args[1].(int)
 
-        if len(args) == 3 {
+        if len(args) > 2 {
             host = args[2].(string)
         }
 
@@ -1032,6 +1079,28 @@ func buildWebLib() {
             return "", errors.New("port must be between 1 and 65535 in web_serve_start()")
         }
 
+        var tc *tls.Config
+        maxBody := int64(WEB_MAX_BODY)
+        if len(args) == 4 {
+            tlsOpts := make(map[string]any)
+            for k, v := range args[3].(map[string]any) {
+                if k != "max_body" {
+                    tlsOpts[k] = v
+                    continue
+                }
+                n, isInt := v.(int)
+                if !isInt || n < 1 {
+                    return "", errors.New("web_serve_start: max_body must be a positive number of bytes")
+                }
+                maxBody = int64(n)
+            }
+            if len(tlsOpts) > 0 {
+                if tc, err = tlsServerConfig("web_serve_start", tlsOpts); err != nil {
+                    return "", err
+                }
+            }
+        }
+
         // setup server
 
         var e error
@@ -1052,6 +1121,9 @@ func buildWebLib() {
             if e != nil {
                 log.Fatal(err)
             } else {
+                if tc != nil {
+                    l = tls.NewListener(l, tc)
+                }
                 e = srv.Serve(l)
             }
         }()
@@ -1080,7 +1152,7 @@ func buildWebLib() {
             }
             // store in lookup table
             weblock.Lock()
-            web_handles[uid] = web_table_entry{srv: &srv, mux: mux, docroot: docroot, addr: addr, host: host, port: port}
+            web_handles[uid] = web_table_entry{srv: &srv, mux: mux, docroot: docroot, addr: addr, host: host, port: port, tls: tc != nil, maxBody: maxBody}
             weblock.Unlock()
             wlog("* Started web service %s\n", uid)
             return uid, nil
@@ -1100,6 +1172,10 @@ func buildWebLib() {
         weblock.Lock()
         delete(web_handles, uid)
         weblock.Unlock()
+        webrulelock.Lock()
+        delete(web_routes, uid)
+        delete(web_middleware, uid)
+        webrulelock.Unlock()
         return true, nil
     }
 
@@ -1181,6 +1257,424 @@ func buildWebLib() {
         return true, nil
     }
 
+    slhelp["web_route"] = LibHelp{in: "handle,method_string,pattern_string,function_name[,options]", out: "bool", action: "Routes requests matching [#i1]method_string[#i0] and [#i1]pattern_string[#i0] to the Za function [#i1]function_name[#i0].\n" +
+        "[#SOL][#i1]method_string[#i0] is a method, a comma separated list of methods or \"*\". GET routes also answer HEAD requests.\n" +
+        "[#SOL]Patterns are paths with optional parameters: [#i1]/users/{id}[#i0] captures one segment, [#i1]/files/{path...}[#i0] captures the rest.\n" +
+        "[#SOL]Routes are tried in the order added, before any web_serve_path() rules. A path match with the wrong method gets 405.\n" +
+        "[#SOL]The handler receives a request map with [#i1].method .path .route .params .query .headers .body .json .form .remote .host[#i0]\n" +
+        "[#SOL](and [#i1].json_error[#i0] if a JSON body failed to decode). It may return a string, a response map\n" +
+        "[#SOL](keys .status .headers .content_type .body .json, see web_response() and web_json()) or nothing (204).\n" +
+        "[#SOL]A [#i1]method_string[#i0] of \"WS\" makes a websocket route: upgrade requests are accepted (others get 426) and, after\n" +
+        "[#SOL]any middleware, the handler is called as [#i1]fn(ws_handle, request)[#i0] for the life of the connection, which is\n" +
+        "[#SOL]closed when it returns. Use ws_send(), ws_receive() and friends on the handle.\n" +
+        "[#SOL]Browser upgrades whose Origin is not this server's host get 403. WS routes take an [#i1]options[#i0] map whose\n" +
+        "[#SOL][#i1].origins[#i0] (a string or list) names other allowed origins, as [#i1]https://host[:port][#i0] or [#i1]host[:port][#i0]; \"*\" allows any."}
+    stdlib["web_route"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_route", args, 2,
+            "5", "string", "string", "string", "string", "map[string]interface {}",
+            "4", "string", "string", "string", "string"); !ok {
+            return nil, err
+        }
+        uid := args[0].(string)
+        weblock.RLock()
+        _, exists := web_handles[uid]
+        weblock.RUnlock()
+        if !exists {
+            return false, fmt.Errorf("web_route: unknown server handle '%s'", uid)
+        }
+
+        var methods []string
+        var ws bool
+        if ms := str.TrimSpace(args[1].(string)); ms != "*" {
+            for _, m := range str.Split(ms, ",") {
+                m = str.ToUpper(str.TrimSpace(m))
+                if m == "" {
+                    return false, fmt.Errorf("web_route: empty method in '%s'", args[1].(string))
+                }
+                if m == "WS" {
+                    ws = true
+                }
+                methods = append(methods, m)
+            }
+        }
+        if ws && len(methods) > 1 {
+            return false, fmt.Errorf("web_route: method WS cannot be combined with other methods")
+        }
+        segs, err := parseRoutePattern(args[2].(string))
+        if err != nil {
+            return false, fmt.Errorf("web_route: %v", err)
+        }
+        fn, err := qualifyFnName(ns, args[3].(string))
+        if err != nil {
+            return false, fmt.Errorf("web_route: %v", err)
+        }
+        var origins []string
+        if len(args) == 5 {
+            if !ws {
+                return false, fmt.Errorf("web_route: options are only accepted for WS routes")
+            }
+            for k, v := range args[4].(map[string]any) {
+                if k != "origins" {
+                    return false, fmt.Errorf("web_route: unknown option '%s'", k)
+                }
+                switch o := v.(type) {
+                case string:
+                    origins = []string{o}
+                case []string:
+                    origins = o
+                case []any:
+                    for _, e := range o {
+                        es, isStr := e.(string)
+                        if !isStr {
+                            return false, fmt.Errorf("web_route: option 'origins' must be a string or a list of strings")
+                        }
+                        origins = append(origins, es)
+                    }
+                default:
+                    return false, fmt.Errorf("web_route: option 'origins' must be a string or a list of strings")
+                }
+            }
+        }
+
+        webrulelock.Lock()
+        web_routes[uid] = append(web_routes[uid], webRoute{methods: methods, pattern: args[2].(string), segs: segs, fn: fn, ws: ws, origins: origins})
+        webrulelock.Unlock()
+        return true, nil
+    }
+
+    slhelp["web_middleware"] = LibHelp{in: "handle,function_name[,path_prefix]", out: "bool", action: "Adds [#i1]function_name[#i0] to the middleware chain for routed requests (optionally only those under [#i1]path_prefix[#i0]).\n" +
+        "[#SOL][#i1]path_prefix[#i0] matches whole segments: \"/api\" covers /api and /api/users but not /apix.\n" +
+        "[#SOL]Middleware runs in the order added, after route matching and before the handler, and receives the request map.\n" +
+        "[#SOL]Return nothing to continue, map(.request req) to continue with a changed request, or a response to answer immediately."}
+    stdlib["web_middleware"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_middleware", args, 2,
+            "3", "string", "string", "string",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+        uid := args[0].(string)
+        weblock.RLock()
+        _, exists := web_handles[uid]
+        weblock.RUnlock()
+        if !exists {
+            return false, fmt.Errorf("web_middleware: unknown server handle '%s'", uid)
+        }
+        fn, err := qualifyFnName(ns, args[1].(string))
+        if err != nil {
+            return false, fmt.Errorf("web_middleware: %v", err)
+        }
+        prefix := "/"
+        if len(args) == 3 {
+            prefix = args[2].(string)
+        }
+        webrulelock.Lock()
+        web_middleware[uid] = append(web_middleware[uid], webMiddleware{prefix: prefix, fn: fn})
+        webrulelock.Unlock()
+        return true, nil
+    }
+
+    slhelp["web_response"] = LibHelp{in: "status_int[,body[,headers_map]]", out: "response_map", action: "Builds a response map for a web_route() handler."}
+    stdlib["web_response"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_response", args, 3,
+            "3", "int", "any", "map[string]interface {}",
+            "2", "int", "any",
+            "1", "int"); !ok {
+            return nil, err
+        }
+        res := map[string]any{"status": args[0].(int)}
+        if len(args) > 1 {
+            res["body"] = args[1]
+        }
+        if len(args) > 2 {
+            res["headers"] = args[2]
+        }
+        return res, nil
+    }
+
+    slhelp["web_json"] = LibHelp{in: "value[,status_int[,headers_map]]", out: "response_map", action: "Builds a response map for a web_route() handler that sends [#i1]value[#i0] as JSON (status 200 by default)."}
+    stdlib["web_json"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_json", args, 3,
+            "3", "any", "int", "map[string]interface {}",
+            "2", "any", "int",
+            "1", "any"); !ok {
+            return nil, err
+        }
+        res := map[string]any{"status": 200, "json": args[0]}
+        if len(args) > 1 {
+            res["status"] = args[1].(int)
+        }
+        if len(args) > 2 {
+            res["headers"] = args[2]
+        }
+        return res, nil
+    }
+
+    slhelp["web_stream"] = LibHelp{in: "[content_type[,status_int[,headers_map]]]", out: "stream_handle", action: "Starts a streaming response from inside a web_route() or 'f' rule handler.\n" +
+        "[#SOL]The status (default 200) and headers are sent at once; the content type defaults to text/plain; charset=utf-8.\n" +
+        "[#SOL]Write the body with web_stream_write(). The handler's return value is then ignored and the response ends when it returns."}
+    stdlib["web_stream"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_stream", args, 4,
+            "3", "string", "int", "map[string]interface {}",
+            "2", "string", "int",
+            "1", "string",
+            "0"); !ok {
+            return nil, err
+        }
+        ctype, status := "text/plain; charset=utf-8", http.StatusOK
+        var headers map[string]any
+        if len(args) > 0 {
+            ctype = args[0].(string)
+        }
+        if len(args) > 1 {
+            status = args[1].(int)
+        }
+        if len(args) > 2 {
+            headers = args[2].(map[string]any)
+        }
+        return webStreamBegin("web_stream", evalfs, ctype, status, headers, false)
+    }
+
+    slhelp["web_stream_write"] = LibHelp{in: "stream_handle,string", out: "bool", action: "Writes and flushes a chunk of a streaming response. Returns false once the client has disconnected."}
+    stdlib["web_stream_write"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_stream_write", args, 1, "2", "string", "string"); !ok {
+            return nil, err
+        }
+        st, err := webStreamLookup("web_stream_write", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        return st.write([]byte(args[1].(string))), nil
+    }
+
+    slhelp["web_stream_closed"] = LibHelp{in: "stream_handle", out: "bool", action: "Checks if the client of a streaming response has gone away (or the handler has finished)."}
+    stdlib["web_stream_closed"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_stream_closed", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        st, err := webStreamLookup("web_stream_closed", args[0].(string))
+        if err != nil {
+            return true, nil
+        }
+        return st.closed(), nil
+    }
+
+    slhelp["web_sse"] = LibHelp{in: "[headers_map]", out: "stream_handle", action: "Starts a server-sent event stream (text/event-stream, uncached) from inside a web handler. Send events with web_sse_send()."}
+    stdlib["web_sse"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_sse", args, 2,
+            "1", "map[string]interface {}",
+            "0"); !ok {
+            return nil, err
+        }
+        var headers map[string]any
+        if len(args) == 1 {
+            headers = args[0].(map[string]any)
+        }
+        return webStreamBegin("web_sse", evalfs, "text/event-stream", http.StatusOK, headers, true)
+    }
+
+    slhelp["web_sse_send"] = LibHelp{in: "stream_handle,data[,options_map]", out: "bool", action: "Sends one event on a web_sse() stream. Non-string [#i1]data[#i0] is sent as JSON.\n" +
+        "[#SOL][#i1]options_map[#i0] may set [#i1].event[#i0] (event name), [#i1].id[#i0] and [#i1].retry[#i0] (client reconnect delay in ms).\n" +
+        "[#SOL]Returns false once the client has disconnected."}
+    stdlib["web_sse_send"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("web_sse_send", args, 2,
+            "3", "string", "any", "map[string]interface {}",
+            "2", "string", "any"); !ok {
+            return nil, err
+        }
+        st, err := webStreamLookup("web_sse_send", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        if !st.sse {
+            return false, fmt.Errorf("web_sse_send: stream was not started with web_sse()")
+        }
+        data, err := sseData(args[1])
+        if err != nil {
+            return false, fmt.Errorf("web_sse_send: could not encode data: %v", err)
+        }
+        var event, id string
+        var retry int
+        if len(args) == 3 {
+            for k, v := range args[2].(map[string]any) {
+                switch k {
+                case "event":
+                    event = GetAsString(v)
+                case "id":
+                    id = GetAsString(v)
+                case "retry":
+                    n, invalid := GetAsInt(v)
+                    if invalid || n < 0 {
+                        return false, fmt.Errorf("web_sse_send: option 'retry' must be a positive integer")
+                    }
+                    retry = n
+                default:
+                    return false, fmt.Errorf("web_sse_send: unknown option '%s'", k)
+                }
+            }
+        }
+        return st.write(sseEvent(data, event, id, retry)), nil
+    }
+
+    slhelp["ws_connect"] = LibHelp{in: "url[,options_map]", out: "ws_handle", action: "Opens a websocket client connection to a [#i1]ws://[#i0] or [#i1]wss://[#i0] [#i1]url[#i0].\n" +
+        "[#SOL][#i1]options_map[#i0] takes the http_request() keys .headers .query .timeout .basic_auth .bearer .user_agent\n" +
+        "[#SOL].ca_file .cert_file .key_file and .insecure. The timeout (default 30s) applies to the handshake."}
+    stdlib["ws_connect"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_connect", args, 2,
+            "2", "string", "map[string]interface {}",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        var optArg any
+        if len(args) == 2 {
+            optArg = args[1]
+        }
+        opts, err := wsConnectOptions(optArg)
+        if err != nil {
+            return nil, err
+        }
+        ws, err := wsDial(args[0].(string), &opts)
+        if err != nil {
+            return nil, fmt.Errorf("ws_connect: %v", err)
+        }
+        return ws.id, nil
+    }
+
+    slhelp["ws_send"] = LibHelp{in: "ws_handle,string[,binary_bool]", out: "bool", action: "Sends [#i1]string[#i0] as a text message, or as a binary message when [#i1]binary_bool[#i0] is true.\n" +
+        "[#SOL]Returns false if the connection has closed."}
+    stdlib["ws_send"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_send", args, 2,
+            "3", "string", "string", "bool",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+        ws, err := wsLookup("ws_send", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        binaryFrame := len(args) == 3 && args[2].(bool)
+        return ws.send(binaryFrame, []byte(args[1].(string))) == nil, nil
+    }
+
+    slhelp["ws_receive"] = LibHelp{in: "ws_handle[,timeout_seconds]", out: "map", action: "Waits for the next message, indefinitely unless [#i1]timeout_seconds[#i0] is given.\n" +
+        "[#SOL]Returns a map with [#i1].type[#i0] (\"text\", \"binary\", \"timeout\" or \"close\"), [#i1].data[#i0], [#i1].code[#i0] (the close code) and [#i1].error[#i0].\n" +
+        "[#SOL]Pings are answered automatically. Once closed, every call returns the close details."}
+    stdlib["ws_receive"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_receive", args, 2,
+            "2", "string", "number",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        ws, err := wsLookup("ws_receive", args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+        var timeout time.Duration
+        if len(args) == 2 {
+            timeout = wsSeconds(args[1])
+            if timeout <= 0 {
+                return nil, fmt.Errorf("ws_receive: timeout must be positive")
+            }
+        }
+        return ws.receive(timeout), nil
+    }
+
+    slhelp["ws_ping"] = LibHelp{in: "ws_handle[,timeout_seconds]", out: "float", action: "Sends a ping and waits (default 5s) for the pong. Returns the round trip in milliseconds, or -1."}
+    stdlib["ws_ping"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_ping", args, 2,
+            "2", "string", "number",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        ws, err := wsLookup("ws_ping", args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+        timeout := 5 * time.Second
+        if len(args) == 2 {
+            timeout = wsSeconds(args[1])
+        }
+        rtt, err := ws.ping(timeout)
+        if err != nil {
+            return -1.0, nil
+        }
+        return msecs(rtt), nil
+    }
+
+    slhelp["ws_close"] = LibHelp{in: "ws_handle[,code_int[,reason_string]]", out: "bool", action: "Closes a websocket with [#i1]code_int[#i0] (default 1000) and discards the handle."}
+    stdlib["ws_close"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_close", args, 3,
+            "3", "string", "int", "string",
+            "2", "string", "int",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        ws, err := wsLookup("ws_close", args[0].(string))
+        if err != nil {
+            return false, err
+        }
+        code, reason := wsCloseNormal, ""
+        if len(args) > 1 {
+            code = args[1].(int)
+            if code < 1000 || code > 4999 || code == wsCloseNoStatus || code == wsCloseAbnormal {
+                return false, fmt.Errorf("ws_close: invalid close code %d", code)
+            }
+        }
+        if len(args) > 2 {
+            reason = args[2].(string)
+        }
+        ws.close(code, reason)
+        return true, nil
+    }
+
+    slhelp["ws_info"] = LibHelp{in: "ws_handle", out: "map", action: "Returns websocket details: .open .client .server .url .remote .sent .received .close_code .close_reason .opened"}
+    stdlib["ws_info"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_info", args, 1, "1", "string"); !ok {
+            return nil, err
+        }
+        ws, err := wsLookup("ws_info", args[0].(string))
+        if err != nil {
+            return nil, err
+        }
+        return ws.toMap(), nil
+    }
+
+    slhelp["ws_list"] = LibHelp{in: "[server_handle]", out: "[]ws_handle", action: "Lists open websocket handles, optionally only those accepted by web server [#i1]server_handle[#i0]."}
+    stdlib["ws_list"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_list", args, 2,
+            "1", "string",
+            "0"); !ok {
+            return nil, err
+        }
+        var l []string
+        wsLock.RLock()
+        for id, ws := range wsConns {
+            if (len(args) == 0 || ws.server == args[0].(string)) && ws.isOpen() {
+                l = append(l, id)
+            }
+        }
+        wsLock.RUnlock()
+        sort.Strings(l)
+        return l, nil
+    }
+
+    slhelp["ws_broadcast"] = LibHelp{in: "server_handle,string[,binary_bool]", out: "int", action: "Sends a message to every open websocket on web server [#i1]server_handle[#i0]. Returns the number reached."}
+    stdlib["ws_broadcast"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("ws_broadcast", args, 2,
+            "3", "string", "string", "bool",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+        binaryFrame := len(args) == 3 && args[2].(bool)
+        data := []byte(args[1].(string))
+        sent := 0
+        for _, ws := range wsServerConns(args[0].(string)) {
+            if ws.send(binaryFrame, data) == nil {
+                sent++
+            }
+        }
+        return sent, nil
+    }
+
     slhelp["web_serve_log_throttle"] = LibHelp{in: "start,freq", out: "", action: "Set the throttle controls for web server logging."}
     stdlib["web_serve_log_throttle"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
         if ok, err := expect_args("web_serve_log_throttle", args, 1, "2", "int", "int"); !ok {
@@ -1203,33 +1697,111 @@ func buildWebLib() {
            }
     */
 
-    slhelp["web_head"] = LibHelp{in: "loc_string", out: "bool", action: "Makes a HEAD request of the given [#i1]loc_string[#i0]. Returns true if retrieved successfully."}
+    slhelp["http_request"] = LibHelp{in: "method_string,url_string[,options_map]", out: "result_map", action: "Performs an HTTP request and returns a map with [#i1].status[#i0], [#i1].status_text[#i0], [#i1].ok[#i0], [#i1].headers[#i0], [#i1].body[#i0], [#i1].json[#i0] (decoded JSON responses), [#i1].size[#i0], [#i1].url[#i0] (after redirects), [#i1].redirects[#i0], [#i1].proto[#i0], [#i1].timing[#i0] (dns_ms, connect_ms, tls_ms, first_byte_ms, total_ms, reused)\n" +
+        "[#SOL]and [#i1].attempts[#i0] (one map per attempt: attempt, status, error, elapsed_ms, wait_ms).\n" +
+        "[#SOL]Options: [#i1].headers[#i0] map, [#i1].query[#i0] map, one of [#i1].body[#i0] string, [#i1].json[#i0] value or [#i1].form[#i0] map, [#i1].timeout[#i0] seconds, [#i1].basic_auth[#i0] [user,pass] or map(.user,.pass), [#i1].bearer[#i0] token, [#i1].user_agent[#i0],\n" +
+        "[#SOL][#i1].insecure[#i0] bool, [#i1].ca_file[#i0], [#i1].cert_file[#i0] and [#i1].key_file[#i0] paths, [#i1].proxy[#i0] URL or \"env\", [#i1].unix_socket[#i0] path (the URL host is then only used for the Host header), [#i1].follow_redirects[#i0] bool and [#i1].max_redirects[#i0] int.\n" +
+        "[#SOL][#i1].retry[#i0] is an attempt count or map(.attempts 3, .backoff 0.2, .max_backoff 10, .jitter 0.5, .on [429,502,503,504], .on_error true, .retry_after true, .max_retry_after 60);\n" +
+        "[#SOL]delays double from .backoff seconds and Retry-After headers are honoured.\n" +
+        "[#SOL][#i1].breaker[#i0] is true or map(.threshold 5, .cooldown 30): after .threshold consecutive failures to a host, requests fail fast until .cooldown seconds pass.\n" +
+        "[#SOL]Non-2xx responses are returned normally; connection failures, timeouts and open breakers raise an error."}
+    stdlib["http_request"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("http_request", args, 2,
+            "3", "string", "string", "map[string]interface {}",
+            "2", "string", "string"); !ok {
+            return nil, err
+        }
+        opts, err := helperOptions("http_request", args, 2)
+        if err != nil {
+            return nil, err
+        }
+        res, err := httpDo(args[0].(string), args[1].(string), opts)
+        if err != nil {
+            return nil, fmt.Errorf("http_request: %v", err)
+        }
+        return res.toMap(), nil
+    }
+
+    slhelp["http_breaker_status"] = LibHelp{in: "[host_string]", out: "map", action: "Returns circuit breaker state for [#i1]host_string[#i0] (host:port as in the URL), or a map of all known hosts.\n" +
+        "[#SOL]Each entry holds [#i1].state[#i0] (closed, open or half-open), [#i1].failures[#i0], [#i1].trips[#i0] and [#i1].retry_in_ms[#i0]."}
+    stdlib["http_breaker_status"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("http_breaker_status", args, 2,
+            "1", "string",
+            "0"); !ok {
+            return nil, err
+        }
+        httpBreakersLock.Lock()
+        defer httpBreakersLock.Unlock()
+        if len(args) == 1 {
+            if b, found := httpBreakers[args[0].(string)]; found {
+                return b.toMap(), nil
+            }
+            return map[string]any{"state": "closed", "failures": 0, "trips": 0, "retry_in_ms": 0.0}, nil
+        }
+        all := make(map[string]any, len(httpBreakers))
+        for host, b := range httpBreakers {
+            all[host] = b.toMap()
+        }
+        return all, nil
+    }
+
+    slhelp["http_breaker_reset"] = LibHelp{in: "[host_string]", out: "", action: "Closes and forgets the circuit breaker for [#i1]host_string[#i0], or for all hosts."}
+    stdlib["http_breaker_reset"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("http_breaker_reset", args, 2,
+            "1", "string",
+            "0"); !ok {
+            return nil, err
+        }
+        httpBreakersLock.Lock()
+        defer httpBreakersLock.Unlock()
+        if len(args) == 1 {
+            delete(httpBreakers, args[0].(string))
+        } else {
+            httpBreakers = make(map[string]*httpBreakerState)
+        }
+        return nil, nil
+    }
+
+    slhelp["web_head"] = LibHelp{in: "loc_string[,options_map]", out: "bool", action: "Makes a HEAD request of the given [#i1]loc_string[#i0]. Returns true if retrieved successfully. [#i1]options_map[#i0] is as for http_request()."}
     stdlib["web_head"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_head", args, 1, "1", "string"); !ok {
+        if ok, err := expect_args("web_head", args, 2,
+            "2", "string", "map[string]interface {}",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        opts, err := helperOptions("web_head", args, 1)
+        if err != nil {
             return nil, err
         }
-        _, down_code := head(args[0].(string))
+        _, down_code := head(args[0].(string), opts)
         if down_code > 299 {
             return false, nil
         }
         return true, nil
     }
 
-    slhelp["web_get"] = LibHelp{in: "loc_string", out: "structure", action: "Returns a [#i1]structure[#i0] with content downloaded from [#i1]loc_string[#i0]. [#i1].result[#i0] is the content string. [#i1].code[#i0] is the status code."}
+    slhelp["web_get"] = LibHelp{in: "loc_string[,options_map]", out: "structure", action: "Returns a [#i1]structure[#i0] with content downloaded from [#i1]loc_string[#i0]. [#i1].result[#i0] is the content string. [#i1].code[#i0] is the status code. [#i1]options_map[#i0] is as for http_request()."}
     stdlib["web_get"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_get", args, 1, "1", "string"); !ok {
+        if ok, err := expect_args("web_get", args, 2,
+            "2", "string", "map[string]interface {}",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        opts, err := helperOptions("web_get", args, 1)
+        if err != nil {
             return nil, err
         }
-        s, down_code, _ := download(args[0].(string))
+        s, down_code, _ := download(args[0].(string), opts)
         if down_code > 299 {
             return web_info{Result: "", Code: int(down_code)}, nil
         }
         return web_info{Result: string(s), Code: int(down_code)}, nil
     }
 
-    slhelp["web_custom"] = LibHelp{in: "method_string,loc_string[,[string]assoc_headers_strings]", out: "string", action: "Returns a [#i1]string[#i0] with content downloaded from [#i1]loc_string[#i0]."}
+    slhelp["web_custom"] = LibHelp{in: "method_string,loc_string[,[string]assoc_headers_strings[,options_map]]", out: "string", action: "Returns a [#i1]string[#i0] with content downloaded from [#i1]loc_string[#i0]. [#i1]options_map[#i0] is as for http_request()."}
     stdlib["web_custom"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_custom", args, 2,
+        if ok, err := expect_args("web_custom", args, 3,
+            "4", "string", "string", "map", "map[string]interface {}",
             "3", "string", "string", "map",
             "2", "string", "string"); !ok {
             return nil, err
@@ -1238,79 +1810,70 @@ func buildWebLib() {
         method_string := args[0].(string)
         loc_string := args[1].(string)
 
-        var headers = make(map[string]string)
-        switch len(args) {
-        case 3:
+        opts, err := helperOptions("web_custom", args, 3)
+        if err != nil {
+            return nil, err
+        }
+        if len(args) > 2 {
             for k, v := range args[2].(map[string]any) {
-                headers[k] = v.(string)
+                opts.headers.Set(k, GetAsString(v))
             }
         }
 
-        // headers
-        request, err := http.NewRequest(method_string, loc_string, nil)
+        res, err := httpDo(method_string, loc_string, opts)
         if err != nil {
-            return []any{"Could not create a new HTTP request in web_custom()", nil, 400}, nil
-        }
-        for k, v := range headers {
-            request.Header.Add(k, v)
-        }
-        // request
-        resp, err := web_client.Do(request)
-        if err == nil {
-            defer resp.Body.Close()
-            if resp.Header.Get("Content-Encoding") == "gzip" {
-                gz, err := gzip.NewReader(resp.Body)
-                if err == nil {
-                    resp.Body = gz
-                    defer gz.Close()
-                }
-            }
-            if resp.StatusCode > 299 {
-                return []any{"", nil, resp.StatusCode}, nil
-            }
-            s, err := ioutil.ReadAll(resp.Body)
-            // pf("web_custom() readall s: %s, err: %+v\n",s,err)
-
-            hmap := make(map[string]any)
-            for k, v := range resp.Header {
-                if len(v) == 1 {
-                    hmap[k] = v[0]
-                } else {
-                    hmap[k] = v
-                }
-            }
-
-            if err == nil {
-                return []any{string(s), hmap, resp.StatusCode}, nil
+            return []any{sf("404 - Not found in web_custom(): %s", loc_string), nil, 404}, nil
+        }
+        if res.status > 299 {
+            return []any{"", nil, res.status}, nil
+        }
+        hmap := make(map[string]any)
+        for k, v := range res.header {
+            if len(v) == 1 {
+                hmap[k] = v[0]
+            } else {
+                hmap[k] = v
             }
         }
-        return []any{sf("404 - Not found in web_custom(): %s", loc_string), nil, 404}, nil
+        return []any{string(res.body), hmap, res.status}, nil
     }
 
-    slhelp["web_post"] = LibHelp{in: "loc_string,[]key_value_list", out: "result_string", action: "Perform a HTTP POST."}
+    slhelp["web_post"] = LibHelp{in: "loc_string,key_value_map[,options_map]", out: "result_string", action: "Perform a HTTP POST of [#i1]key_value_map[#i0] as form data. [#i1]options_map[#i0] is as for http_request()."}
     stdlib["web_post"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_post", args, 1,
+        if ok, err := expect_args("web_post", args, 3,
+            "3", "string", "map[string]interface {}", "map[string]interface {}",
+            "2", "string", "map[string]interface {}",
             "2", "string", "[]interface {}"); !ok {
             return nil, err
         }
+        opts, err := helperOptions("web_post", args, 2)
+        if err != nil {
+            return nil, err
+        }
 
-        s, up_ok := post(args[0].(string), args[1])
+        s, up_ok := post(args[0].(string), args[1], opts)
         if !up_ok {
             return "", errors.New(sf("Could not post to %v", args[0].(string)))
         }
         return string(s), nil
     }
 
-    slhelp["web_raw_send"] = LibHelp{in: "method_string,url_string,headers_map,body_string", out: "[]any", action: "Send HTTP request with custom method, headers, and raw body. Returns [body, headers, status_code]."}
+    slhelp["web_raw_send"] = LibHelp{in: "method_string,url_string,headers_map,body_string[,options_map]", out: "[]any", action: "Send HTTP request with custom method, headers, and raw body. Returns [body, headers, status_code]. [#i1]options_map[#i0] is as for http_request()."}
     stdlib["web_raw_send"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
-        if ok, err := expect_args("web_raw_send", args, 4, "4", "string", "string", "map[string]interface {}", "string"); !ok {
+        if ok, err := expect_args("web_raw_send", args, 2,
+            "5", "string", "string", "map[string]interface {}", "string", "map[string]interface {}",
+            "4", "string", "string", "map[string]interface {}", "string"); !ok {
             return nil, err
         }
         method := args[0].(string)
         url := args[1].(string)
         headers := args[2].(map[string]any)
         body := args[3].(string)
-        return rawSend(method, url, headers, body)
+        opts, err := helperOptions("web_raw_send", args, 4)
+        if err != nil {
+            return nil, err
+        }
+        return rawSend(method, url, headers, body, opts)
     }
 
     slhelp["download"] = LibHelp{in: "url_string", out: "local_name", action: "Downloads from URL [#i1]url_string[#i0] and stores the returned data in the file [#i1]local_name[#i0]. Includes console feedback."}
@@ -1331,7 +1894,7 @@ func buildWebLib() {
         if ok, err := expect_args("web_download", args, 1, "2", "string", "string"); !ok {
             return nil, err
         }
-        cont, down_code, _ := download(args[0].(string))
+        cont, down_code, _ := download(args[0].(string), newHttpOptions())
         if down_code < 300 {
             ioutil.WriteFile(args[1].(string), cont, default_WriteMode)
             return true, nil
@@ -1422,8 +1985,7 @@ func buildWebLib() {
 
 }
 
-func post(loc string, valueMap any) ([]byte, bool) {
-    var s []byte
+func post(loc string, valueMap any, opts httpOptions) ([]byte, bool) {
     vlist := url.Values{}
     switch valueMap := valueMap.(type) {
     case map[string]int:
@@ -1439,84 +2001,56 @@ func post(loc string, valueMap any) ([]byte, bool) {
             vlist.Set(k, sf("%v", val))
         }
     case map[string]any:
-        for k, val := range valueMap {
-            vlist.Set(k, sf("%v", val))
-        }
+        vlist = formValues(valueMap)
     default:
         return []byte{}, false
     }
-    resp, err := web_client.PostForm(loc, vlist)
+    opts.body = []byte(vlist.Encode())
+    opts.hasBody = true
+    opts.contentType = "application/x-www-form-urlencoded"
+    res, err := httpDo("POST", loc, opts)
     if err != nil {
         return []byte{}, false
     }
-    defer resp.Body.Close()
-    if err == nil {
-        s, err = ioutil.ReadAll(resp.Body)
-        if err == nil {
-            return s, true
-        }
-    }
-    return []byte{}, false
+    return res.body, true
 }
 
-func rawSend(method, url string, headers map[string]any, body string) ([]any, error) {
-    req, err := http.NewRequest(method, url, str.NewReader(body))
-    if err != nil {
-        return nil, err
-    }
+func rawSend(method, url string, headers map[string]any, body string, opts httpOptions) ([]any, error) {
     for k, v := range headers {
-        req.Header.Set(k, v.(string))
-    }
-    resp, err := web_client.Do(req)
-    if err != nil {
-        return nil, err
+        opts.headers.Set(k, GetAsString(v))
     }
-    defer resp.Body.Close()
-    respBody, err := ioutil.ReadAll(resp.Body)
+    opts.body = []byte(body)
+    opts.hasBody = true
+    res, err := httpDo(method, url, opts)
     if err != nil {
         return nil, err
     }
     respHeaders := make(map[string]any)
-    for k, v := range resp.Header {
+    for k, v := range res.header {
         respHeaders[k] = str.Join(v, ",")
     }
-    return []any{string(respBody), respHeaders, resp.StatusCode}, nil
+    return []any{string(res.body), respHeaders, res.status}, nil
 }
 
-func download(loc string) ([]byte, int, http.Header) {
-    var s []byte
+func download(loc string, opts httpOptions) ([]byte, int, http.Header) {
     // pf("download() recv request : %s\n",loc)
-    resp, err := web_client.Get(loc)
-    if err == nil {
-        defer resp.Body.Close()
-        if resp.StatusCode > 299 {
-            return []byte{}, resp.StatusCode, nil
-        }
-        s, err = ioutil.ReadAll(resp.Body)
-        if err == nil {
-            // pf("download() response from request : %+v\n",resp)
-            return s, resp.StatusCode, resp.Header
-        }
+    res, err := httpDo("GET", loc, opts)
+    if err != nil {
+        return []byte{}, 404, nil
     }
-    return []byte{}, 404, nil
+    if res.status > 299 {
+        return []byte{}, res.status, nil
+    }
+    return res.body, res.status, res.header
 }
 
-func head(loc string) ([]byte, int) {
-    var s []byte
-
-    resp, err := web_client.Head(loc)
+func head(loc string, opts httpOptions) ([]byte, int) {
+    res, err := httpDo("HEAD", loc, opts)
     if err != nil {
         return []byte{}, 404
     }
-    if err == nil {
-        defer resp.Body.Close()
-        if resp.StatusCode > 299 {
-            return []byte{}, resp.StatusCode
-        }
-        s, err = ioutil.ReadAll(resp.Body)
-        if err == nil {
-            return s, resp.StatusCode
-        }
+    if res.status > 299 {
+        return []byte{}, res.status
     }
-    return []byte{}, resp.StatusCode
+    return res.body, res.status
 }
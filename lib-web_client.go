@@ -0,0 +1,857 @@
+//go:build !test
+
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "net/url"
+    "sort"
+    "strconv"
+    str "strings"
+    "sync"
+    "time"
+)
+
+/*
+   http_request() and the shared client core used by the web_* helpers.
+
+   · every request goes through httpDo(), which takes a parsed httpOptions
+   · transports are shared: requests without TLS or proxy settings use web_tr,
+     the others get a transport cached against their TLS/proxy settings.
+   · non-2xx responses are not errors, only transport failures are.
+   · httpDo() wraps each attempt with the optional retry policy and the
+     per-host circuit breaker. every attempt is recorded in the result.
+*/
+
+type httpOptions struct {
+    headers         http.Header
+    query           url.Values
+    body            []byte
+    hasBody         bool
+    contentType     string
+    timeout         time.Duration
+    basicUser       string
+    basicPass       string
+    hasBasic        bool
+    bearer          string
+    userAgent       string
+    insecure        bool
+    caFile          string
+    certFile        string
+    keyFile         string
+    proxy           string
+    unixSocket      string // dial this unix socket instead of the URL's host
+    followRedirects bool
+    maxRedirects    int
+    retry           httpRetry
+    breaker         *httpBreakerConf
+}
+
+type httpRetry struct {
+    attempts      int
+    backoff       time.Duration
+    maxBackoff    time.Duration
+    jitter        float64
+    on            map[int]bool
+    onError       bool
+    retryAfter    bool
+    maxRetryAfter time.Duration
+}
+
+type httpBreakerConf struct {
+    threshold int
+    cooldown  time.Duration
+}
+
+// httpAttempt is one entry in the attempt history.
+type httpAttempt struct {
+    status  int
+    err     string
+    elapsed time.Duration
+    wait    time.Duration
+}
+
+type httpResult struct {
+    status     int
+    statusText string
+    proto      string
+    header     http.Header
+    body       []byte
+    finalURL   string
+    redirects  []string
+    timing     httpTiming
+    attempts   []httpAttempt
+}
+
+type httpTiming struct {
+    dns       time.Duration
+    connect   time.Duration
+    tls       time.Duration
+    firstByte time.Duration
+    total     time.Duration
+    reused    bool
+}
+
+var httpTransports = make(map[string]*http.Transport)
+var httpTransportsLock sync.Mutex
+
+func newHttpOptions() httpOptions {
+    return httpOptions{headers: make(http.Header), query: url.Values{}, followRedirects: true, maxRedirects: 10, retry: newHttpRetry()}
+}
+
+func newHttpRetry() httpRetry {
+    return httpRetry{
+        attempts:      1,
+        backoff:       200 * time.Millisecond,
+        maxBackoff:    10 * time.Second,
+        jitter:        0.5,
+        on:            map[int]bool{429: true, 502: true, 503: true, 504: true},
+        onError:       true,
+        retryAfter:    true,
+        maxRetryAfter: 60 * time.Second,
+    }
+}
+
+// parseHttpRetry reads the .retry option: either an attempt count or a map.
+func parseHttpRetry(fname string, v any) (httpRetry, error) {
+    r := newHttpRetry()
+    if n, invalid := GetAsInt(v); !invalid {
+        if n < 1 {
+            return r, fmt.Errorf("%s option 'retry' must allow at least 1 attempt", fname)
+        }
+        r.attempts = n
+        return r, nil
+    }
+    m, ok := v.(map[string]any)
+    if !ok {
+        return r, fmt.Errorf("%s option 'retry' must be an attempt count or a map", fname)
+    }
+    r.attempts = 3
+    for k, rv := range m {
+        switch k {
+        case "attempts":
+            n, invalid := GetAsInt(rv)
+            if invalid || n < 1 {
+                return r, fmt.Errorf("%s retry option 'attempts' must be at least 1", fname)
+            }
+            r.attempts = n
+        case "backoff", "max_backoff", "max_retry_after":
+            secs, invalid := GetAsFloat(rv)
+            if invalid || secs < 0 {
+                return r, fmt.Errorf("%s retry option '%s' must be a non-negative number of seconds", fname, k)
+            }
+            d := time.Duration(secs * float64(time.Second))
+            switch k {
+            case "backoff":
+                r.backoff = d
+            case "max_backoff":
+                r.maxBackoff = d
+            case "max_retry_after":
+                r.maxRetryAfter = d
+            }
+        case "jitter":
+            switch j := rv.(type) {
+            case bool:
+                if !j {
+                    r.jitter = 0
+                }
+            default:
+                f, invalid := GetAsFloat(rv)
+                if invalid || f < 0 || f > 1 {
+                    return r, fmt.Errorf("%s retry option 'jitter' must be a bool or a fraction between 0 and 1", fname)
+                }
+                r.jitter = f
+            }
+        case "on":
+            codes, ok := rv.([]any)
+            if !ok {
+                return r, fmt.Errorf("%s retry option 'on' must be a list of status codes", fname)
+            }
+            r.on = make(map[int]bool)
+            for _, c := range codes {
+                n, invalid := GetAsInt(c)
+                if invalid || n < 100 || n > 599 {
+                    return r, fmt.Errorf("%s retry option 'on' contains invalid status code %v", fname, c)
+                }
+                r.on[n] = true
+            }
+        case "on_error", "retry_after":
+            b, ok := rv.(bool)
+            if !ok {
+                return r, fmt.Errorf("%s retry option '%s' must be a boolean", fname, k)
+            }
+            if k == "on_error" {
+                r.onError = b
+            } else {
+                r.retryAfter = b
+            }
+        default:
+            return r, fmt.Errorf("%s: unknown retry option '%s'", fname, k)
+        }
+    }
+    return r, nil
+}
+
+// parseHttpBreaker reads the .breaker option: true for defaults, or a map.
+func parseHttpBreaker(fname string, v any) (*httpBreakerConf, error) {
+    b := &httpBreakerConf{threshold: 5, cooldown: 30 * time.Second}
+    switch v := v.(type) {
+    case bool:
+        if !v {
+            return nil, nil
+        }
+        return b, nil
+    case map[string]any:
+        for k, bv := range v {
+            switch k {
+            case "threshold":
+                n, invalid := GetAsInt(bv)
+                if invalid || n < 1 {
+                    return nil, fmt.Errorf("%s breaker option 'threshold' must be at least 1", fname)
+                }
+                b.threshold = n
+            case "cooldown":
+                secs, invalid := GetAsFloat(bv)
+                if invalid || secs < 0 {
+                    return nil, fmt.Errorf("%s breaker option 'cooldown' must be a non-negative number of seconds", fname)
+                }
+                b.cooldown = time.Duration(secs * float64(time.Second))
+            default:
+                return nil, fmt.Errorf("%s: unknown breaker option '%s'", fname, k)
+            }
+        }
+        return b, nil
+    }
+    return nil, fmt.Errorf("%s option 'breaker' must be a boolean or a map", fname)
+}
+
+// parseHttpOptions converts a Za options map into httpOptions.
+func parseHttpOptions(fname string, arg any) (httpOptions, error) {
+    opts := newHttpOptions()
+    if arg == nil {
+        return opts, nil
+    }
+    m, ok := arg.(map[string]any)
+    if !ok {
+        return opts, fmt.Errorf("%s options must be a map", fname)
+    }
+
+    // keys are visited in order so that body conflicts are reported consistently
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    bodyFrom := ""
+    setBody := func(k string, b []byte, ctype string) error {
+        if bodyFrom != "" {
+            return fmt.Errorf("%s options '%s' and '%s' cannot be used together", fname, bodyFrom, k)
+        }
+        bodyFrom = k
+        opts.body = b
+        opts.hasBody = true
+        opts.contentType = ctype
+        return nil
+    }
+
+    for _, k := range keys {
+        v := m[k]
+        switch k {
+        case "headers":
+            hm, ok := v.(map[string]any)
+            if !ok {
+                return opts, fmt.Errorf("%s option 'headers' must be a map", fname)
+            }
+            for hk, hv := range hm {
+                switch hv := hv.(type) {
+                case []any:
+                    for _, e := range hv {
+                        opts.headers.Add(hk, GetAsString(e))
+                    }
+                case []string:
+                    for _, e := range hv {
+                        opts.headers.Add(hk, e)
+                    }
+                default:
+                    opts.headers.Set(hk, GetAsString(hv))
+                }
+            }
+        case "query":
+            qm, ok := v.(map[string]any)
+            if !ok {
+                return opts, fmt.Errorf("%s option 'query' must be a map", fname)
+            }
+            opts.query = formValues(qm)
+        case "body":
+            switch b := v.(type) {
+            case string:
+                if err := setBody(k, []byte(b), ""); err != nil {
+                    return opts, err
+                }
+            case []byte:
+                if err := setBody(k, b, ""); err != nil {
+                    return opts, err
+                }
+            default:
+                return opts, fmt.Errorf("%s option 'body' must be a string", fname)
+            }
+        case "json":
+            b, err := json.Marshal(v)
+            if err != nil {
+                return opts, fmt.Errorf("%s option 'json' could not be encoded: %v", fname, err)
+            }
+            if err := setBody(k, b, "application/json"); err != nil {
+                return opts, err
+            }
+        case "form":
+            fm, ok := v.(map[string]any)
+            if !ok {
+                return opts, fmt.Errorf("%s option 'form' must be a map", fname)
+            }
+            if err := setBody(k, []byte(formValues(fm).Encode()), "application/x-www-form-urlencoded"); err != nil {
+                return opts, err
+            }
+        case "timeout":
+            secs, invalid := GetAsFloat(v)
+            if invalid || secs < 0 {
+                return opts, fmt.Errorf("%s option 'timeout' must be a non-negative number of seconds", fname)
+            }
+            opts.timeout = time.Duration(secs * float64(time.Second))
+        case "basic_auth":
+            switch a := v.(type) {
+            case map[string]any:
+                if a["user"] != nil {
+                    opts.basicUser = GetAsString(a["user"])
+                }
+                if a["pass"] != nil {
+                    opts.basicPass = GetAsString(a["pass"])
+                }
+            case []any:
+                if len(a) != 2 {
+                    return opts, fmt.Errorf("%s option 'basic_auth' must be [user,pass] or map(.user,.pass)", fname)
+                }
+                opts.basicUser = GetAsString(a[0])
+                opts.basicPass = GetAsString(a[1])
+            default:
+                return opts, fmt.Errorf("%s option 'basic_auth' must be [user,pass] or map(.user,.pass)", fname)
+            }
+            opts.hasBasic = true
+        case "bearer", "user_agent", "ca_file", "cert_file", "key_file", "proxy", "unix_socket":
+            s, ok := v.(string)
+            if !ok {
+                return opts, fmt.Errorf("%s option '%s' must be a string", fname, k)
+            }
+            switch k {
+            case "bearer":
+                opts.bearer = s
+            case "user_agent":
+                opts.userAgent = s
+            case "ca_file":
+                opts.caFile = s
+            case "cert_file":
+                opts.certFile = s
+            case "key_file":
+                opts.keyFile = s
+            case "proxy":
+                opts.proxy = s
+            case "unix_socket":
+                if path, isUnix := unixSocketPath(s); isUnix {
+                    s = path
+                }
+                if s == "" {
+                    return opts, fmt.Errorf("%s option 'unix_socket' must name a socket path", fname)
+                }
+                opts.unixSocket = s
+            }
+        case "insecure", "follow_redirects":
+            b, ok := v.(bool)
+            if !ok {
+                return opts, fmt.Errorf("%s option '%s' must be a boolean", fname, k)
+            }
+            if k == "insecure" {
+                opts.insecure = b
+            } else {
+                opts.followRedirects = b
+            }
+        case "retry":
+            r, err := parseHttpRetry(fname, v)
+            if err != nil {
+                return opts, err
+            }
+            opts.retry = r
+        case "breaker":
+            b, err := parseHttpBreaker(fname, v)
+            if err != nil {
+                return opts, err
+            }
+            opts.breaker = b
+        case "max_redirects":
+            n, invalid := GetAsInt(v)
+            if invalid || n < 0 {
+                return opts, fmt.Errorf("%s option 'max_redirects' must be a non-negative number", fname)
+            }
+            opts.maxRedirects = n
+        default:
+            return opts, fmt.Errorf("%s: unknown option '%s'", fname, k)
+        }
+    }
+
+    if (opts.certFile == "") != (opts.keyFile == "") {
+        return opts, fmt.Errorf("%s options 'cert_file' and 'key_file' must be given together", fname)
+    }
+    if opts.hasBasic && opts.bearer != "" {
+        return opts, fmt.Errorf("%s options 'basic_auth' and 'bearer' cannot be used together", fname)
+    }
+    return opts, nil
+}
+
+// formValues flattens a Za map into url.Values; array values repeat the key.
+func formValues(m map[string]any) url.Values {
+    vals := url.Values{}
+    for k, v := range m {
+        switch v := v.(type) {
+        case []any:
+            for _, e := range v {
+                vals.Add(k, GetAsString(e))
+            }
+        case []string:
+            for _, e := range v {
+                vals.Add(k, e)
+            }
+        default:
+            vals.Set(k, GetAsString(v))
+        }
+    }
+    return vals
+}
+
+// httpTransport returns the transport matching the TLS and proxy settings in opts.
+func httpTransport(opts httpOptions) (*http.Transport, error) {
+    if !opts.insecure && opts.caFile == "" && opts.certFile == "" && opts.proxy == "" && opts.unixSocket == "" {
+        return web_tr, nil
+    }
+
+    key := sf("%v|%s|%s|%s|%s|%s", opts.insecure, opts.caFile, opts.certFile, opts.keyFile, opts.proxy, opts.unixSocket)
+    httpTransportsLock.Lock()
+    defer httpTransportsLock.Unlock()
+    if tr, found := httpTransports[key]; found {
+        return tr, nil
+    }
+
+    tc, err := tlsClientConfig(opts.insecure, opts.caFile, opts.certFile, opts.keyFile, "")
+    if err != nil {
+        return nil, err
+    }
+
+    // keep the shared transport's timeout and connection pool settings
+    tr := web_tr.Clone()
+    tr.TLSClientConfig = tc
+    switch opts.proxy {
+    case "":
+    case "env":
+        tr.Proxy = http.ProxyFromEnvironment
+    default:
+        pu, err := url.Parse(opts.proxy)
+        if err != nil || pu.Host == "" {
+            return nil, fmt.Errorf("invalid proxy URL '%s'", opts.proxy)
+        }
+        tr.Proxy = http.ProxyURL(pu)
+    }
+    if opts.unixSocket != "" {
+        if opts.proxy != "" {
+            return nil, fmt.Errorf("options 'proxy' and 'unix_socket' cannot be used together")
+        }
+        path := opts.unixSocket
+        dialer := &net.Dialer{}
+        tr.Proxy = nil
+        tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+            return dialer.DialContext(ctx, "unix", path)
+        }
+    }
+    httpTransports[key] = tr
+    return tr, nil
+}
+
+// httpDo performs the request described by method, loc and opts, retrying
+// and consulting the circuit breaker as configured.
+func httpDo(method, loc string, opts httpOptions) (*httpResult, error) {
+    method = str.ToUpper(method)
+    u, err := url.Parse(loc)
+    if err != nil {
+        return nil, err
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return nil, fmt.Errorf("unsupported URL scheme in '%s'", loc)
+    }
+
+    var history []httpAttempt
+    var last *httpResult
+    var lastErr error
+    rp := opts.retry
+
+    for n := 1; n <= rp.attempts; n++ {
+        if opts.breaker != nil {
+            if err := httpBreakerAllow(u.Host, opts.breaker); err != nil {
+                if last != nil {
+                    break
+                }
+                if len(history) == 0 {
+                    return nil, err
+                }
+                return nil, fmt.Errorf("%v (after %d attempts: %s)", err, len(history), httpAttemptSummary(history))
+            }
+        }
+
+        start := time.Now()
+        res, err := httpAttemptOnce(method, u, opts)
+        at := httpAttempt{elapsed: time.Since(start)}
+        retryable := false
+        if err != nil {
+            at.err = err.Error()
+            lastErr = err
+            retryable = rp.onError
+        } else {
+            at.status = res.status
+            last = res
+            lastErr = nil
+            retryable = rp.on[res.status]
+        }
+
+        if opts.breaker != nil {
+            httpBreakerRecord(u.Host, opts.breaker, err != nil || res.status >= 500 || rp.on[res.status])
+        }
+
+        again := retryable && n < rp.attempts
+        if again {
+            at.wait = rp.delay(n, res)
+        }
+        history = append(history, at)
+        if !again {
+            break
+        }
+        time.Sleep(at.wait)
+    }
+
+    if lastErr != nil || last == nil {
+        if len(history) > 1 {
+            return nil, fmt.Errorf("%v (after %d attempts: %s)", lastErr, len(history), httpAttemptSummary(history))
+        }
+        return nil, lastErr
+    }
+    last.attempts = history
+    return last, nil
+}
+
+// delay works out the pause before attempt n+1.
+func (rp httpRetry) delay(n int, res *httpResult) time.Duration {
+    if rp.retryAfter && res != nil {
+        if d, ok := parseRetryAfter(res.header.Get("Retry-After")); ok {
+            if d > rp.maxRetryAfter {
+                d = rp.maxRetryAfter
+            }
+            return d
+        }
+    }
+    d := time.Duration(float64(rp.backoff) * math.Pow(2, float64(n-1)))
+    if d > rp.maxBackoff || d < 0 {
+        d = rp.maxBackoff
+    }
+    if rp.jitter > 0 {
+        d -= time.Duration(rand.Float64() * rp.jitter * float64(d))
+    }
+    return d
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string) (time.Duration, bool) {
+    v = str.TrimSpace(v)
+    if v == "" {
+        return 0, false
+    }
+    if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+        return time.Duration(secs) * time.Second, true
+    }
+    if t, err := http.ParseTime(v); err == nil {
+        d := time.Until(t)
+        if d < 0 {
+            d = 0
+        }
+        return d, true
+    }
+    return 0, false
+}
+
+func httpAttemptSummary(history []httpAttempt) string {
+    parts := make([]string, 0, len(history))
+    for _, a := range history {
+        if a.err != "" {
+            parts = append(parts, "error")
+        } else {
+            parts = append(parts, strconv.Itoa(a.status))
+        }
+    }
+    return str.Join(parts, ", ")
+}
+
+// httpAttemptOnce performs a single request.
+func httpAttemptOnce(method string, base *url.URL, opts httpOptions) (*httpResult, error) {
+    u := *base
+    if len(opts.query) > 0 {
+        q := u.Query()
+        for k, vs := range opts.query {
+            q.Del(k)
+            for _, v := range vs {
+                q.Add(k, v)
+            }
+        }
+        u.RawQuery = q.Encode()
+    }
+
+    tr, err := httpTransport(opts)
+    if err != nil {
+        return nil, err
+    }
+
+    var body io.Reader
+    if opts.hasBody {
+        body = bytes.NewReader(opts.body)
+    }
+    req, err := http.NewRequest(method, u.String(), body)
+    if err != nil {
+        return nil, err
+    }
+    for k, vs := range opts.headers {
+        req.Header[k] = vs
+    }
+    if opts.contentType != "" && req.Header.Get("Content-Type") == "" {
+        req.Header.Set("Content-Type", opts.contentType)
+    }
+    if opts.userAgent != "" {
+        req.Header.Set("User-Agent", opts.userAgent)
+    }
+    if opts.hasBasic {
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(opts.basicUser+":"+opts.basicPass)))
+    }
+    if opts.bearer != "" {
+        req.Header.Set("Authorization", "Bearer "+opts.bearer)
+    }
+
+    res := &httpResult{}
+    client := &http.Client{
+        Transport: tr,
+        Timeout:   opts.timeout,
+        CheckRedirect: func(next *http.Request, via []*http.Request) error {
+            if !opts.followRedirects {
+                return http.ErrUseLastResponse
+            }
+            if len(via) > opts.maxRedirects {
+                return fmt.Errorf("stopped after %d redirects", opts.maxRedirects)
+            }
+            res.redirects = append(res.redirects, next.URL.String())
+            // credentials are only forwarded to the original host
+            if next.URL.Host != via[0].URL.Host {
+                next.Header.Del("Authorization")
+            }
+            return nil
+        },
+    }
+
+    var dnsStart, connStart, tlsStart time.Time
+    start := time.Now()
+    trace := &httptrace.ClientTrace{
+        DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+        DNSDone:              func(httptrace.DNSDoneInfo) { res.timing.dns += time.Since(dnsStart) },
+        ConnectStart:         func(string, string) { connStart = time.Now() },
+        ConnectDone:          func(string, string, error) { res.timing.connect += time.Since(connStart) },
+        TLSHandshakeStart:    func() { tlsStart = time.Now() },
+        TLSHandshakeDone:     func(tls.ConnectionState, error) { res.timing.tls += time.Since(tlsStart) },
+        GotConn:              func(ci httptrace.GotConnInfo) { res.timing.reused = ci.Reused },
+        GotFirstResponseByte: func() { res.timing.firstByte = time.Since(start) },
+    }
+    req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    var rd io.Reader = resp.Body
+    // a caller supplied Accept-Encoding disables transparent decompression
+    if !resp.Uncompressed && str.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && method != "HEAD" {
+        if gz, gerr := gzip.NewReader(resp.Body); gerr == nil {
+            defer gz.Close()
+            rd = gz
+        }
+    }
+    res.body, err = io.ReadAll(rd)
+    if err != nil {
+        return nil, err
+    }
+    res.timing.total = time.Since(start)
+
+    res.status = resp.StatusCode
+    res.statusText = str.TrimSpace(str.TrimPrefix(resp.Status, sf("%d", resp.StatusCode)))
+    res.proto = resp.Proto
+    res.header = resp.Header
+    res.finalURL = resp.Request.URL.String()
+    return res, nil
+}
+
+func msecs(d time.Duration) float64 {
+    return float64(d.Microseconds()) / 1000
+}
+
+// toMap builds the Za result for http_request().
+func (r *httpResult) toMap() map[string]any {
+    headers := make(map[string]any, len(r.header))
+    for k, v := range r.header {
+        headers[k] = str.Join(v, ", ")
+    }
+    redirects := make([]any, 0, len(r.redirects))
+    for _, u := range r.redirects {
+        redirects = append(redirects, u)
+    }
+    attempts := make([]any, 0, len(r.attempts))
+    for i, a := range r.attempts {
+        attempts = append(attempts, map[string]any{
+            "attempt":    i + 1,
+            "status":     a.status,
+            "error":      a.err,
+            "elapsed_ms": msecs(a.elapsed),
+            "wait_ms":    msecs(a.wait),
+        })
+    }
+
+    var decoded any
+    if str.Contains(str.ToLower(r.header.Get("Content-Type")), "json") && len(r.body) > 0 {
+        if json.Unmarshal(r.body, &decoded) != nil {
+            decoded = nil
+        }
+    }
+
+    return map[string]any{
+        "status":      r.status,
+        "status_text": r.statusText,
+        "ok":          r.status >= 200 && r.status < 300,
+        "proto":       r.proto,
+        "headers":     headers,
+        "body":        string(r.body),
+        "json":        decoded,
+        "size":        len(r.body),
+        "url":         r.finalURL,
+        "redirects":   redirects,
+        "attempts":    attempts,
+        "timing": map[string]any{
+            "dns_ms":        msecs(r.timing.dns),
+            "connect_ms":    msecs(r.timing.connect),
+            "tls_ms":        msecs(r.timing.tls),
+            "first_byte_ms": msecs(r.timing.firstByte),
+            "total_ms":      msecs(r.timing.total),
+            "reused":        r.timing.reused,
+        },
+    }
+}
+
+// helperOptions parses the optional trailing options map of the web_* helpers.
+func helperOptions(fname string, args []any, pos int) (httpOptions, error) {
+    if len(args) > pos {
+        return parseHttpOptions(fname, args[pos])
+    }
+    return newHttpOptions(), nil
+}
+
+/*
+   per-host circuit breaker
+
+   closed    : requests flow, consecutive failures are counted
+   open      : requests fail fast until the cooldown has passed
+   half-open : a single probe request is let through; success closes
+               the breaker, failure opens it again.
+*/
+
+type httpBreakerState struct {
+    state     string
+    failures  int
+    trips     int
+    openedAt  time.Time
+    openUntil time.Time
+    probing   bool
+}
+
+var httpBreakers = make(map[string]*httpBreakerState)
+var httpBreakersLock sync.Mutex
+
+func httpBreakerAllow(host string, conf *httpBreakerConf) error {
+    httpBreakersLock.Lock()
+    defer httpBreakersLock.Unlock()
+    b, found := httpBreakers[host]
+    if !found {
+        return nil
+    }
+    switch b.state {
+    case "open":
+        if time.Now().Before(b.openUntil) {
+            return fmt.Errorf("circuit breaker open for %s (retry in %.1fs)", host, time.Until(b.openUntil).Seconds())
+        }
+        b.state = "half-open"
+        b.probing = true
+    case "half-open":
+        if b.probing {
+            return fmt.Errorf("circuit breaker half-open for %s, probe in progress", host)
+        }
+        b.probing = true
+    }
+    return nil
+}
+
+func httpBreakerRecord(host string, conf *httpBreakerConf, failed bool) {
+    httpBreakersLock.Lock()
+    defer httpBreakersLock.Unlock()
+    b, found := httpBreakers[host]
+    if !found {
+        if !failed {
+            return
+        }
+        b = &httpBreakerState{state: "closed"}
+        httpBreakers[host] = b
+    }
+    b.probing = false
+    if !failed {
+        b.state = "closed"
+        b.failures = 0
+        return
+    }
+    b.failures++
+    if b.state == "half-open" || b.failures >= conf.threshold {
+        b.state = "open"
+        b.trips++
+        b.openedAt = time.Now()
+        b.openUntil = b.openedAt.Add(conf.cooldown)
+    }
+}
+
+func (b *httpBreakerState) toMap() map[string]any {
+    state := b.state
+    if state == "open" && !time.Now().Before(b.openUntil) {
+        state = "half-open"
+    }
+    m := map[string]any{"state": state, "failures": b.failures, "trips": b.trips, "retry_in_ms": 0.0}
+    if state == "open" {
+        m["retry_in_ms"] = msecs(time.Until(b.openUntil))
+    }
+    return m
+}
@@ -0,0 +1,437 @@
+//go:build !test
+
+package main
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "sort"
+    str "strings"
+)
+
+/*
+   method+path routes for web_serve_start() servers.
+
+   · routes are checked before the web_serve_path() rules. the first route
+     whose method and path both match is serviced.
+   · path patterns are split on '/'. a {name} segment captures one segment,
+     a trailing {name...} captures the remainder of the path.
+   · middleware registered with web_middleware() runs, in registration order,
+     ahead of the matched handler. it may pass, replace the request or answer.
+   · handlers receive a request map and return a string, a response map
+     (see web_response() and web_json()) or nothing.
+   · handlers may stream instead of returning a body, see lib-web_stream.go.
+   · "WS" routes upgrade to a websocket (lib-web_socket.go) and call their
+     handler with the connection handle and request map instead.
+*/
+
+type webRouteSeg struct {
+    literal string
+    param   string
+    rest    bool
+}
+
+type webRoute struct {
+    methods []string // empty for any method
+    pattern string
+    segs    []webRouteSeg
+    fn      string
+    ws      bool     // websocket route, see wsServe()
+    origins []string // extra Origins a websocket route accepts, see wsOriginAllowed()
+}
+
+type webMiddleware struct {
+    prefix string
+    fn     string
+}
+
+// covers reports whether path is the middleware's prefix or lies below it.
+// "/api" covers "/api" and "/api/users" but not "/apix".
+func (mw webMiddleware) covers(path string) bool {
+    return path == mw.prefix || str.HasPrefix(path, str.TrimSuffix(mw.prefix, "/")+"/")
+}
+
+var web_routes = make(map[string][]webRoute)
+var web_middleware = make(map[string][]webMiddleware)
+
+// response map keys understood by webWriteHandlerResult()
+var webResponseKeys = map[string]bool{"status": true, "headers": true, "content_type": true, "body": true, "json": true}
+
+func splitRoutePath(p string) []string {
+    p = str.Trim(p, "/")
+    if p == "" {
+        return []string{}
+    }
+    return str.Split(p, "/")
+}
+
+// parseRoutePattern validates and splits a /path/{param}/{rest...} pattern.
+func parseRoutePattern(pattern string) ([]webRouteSeg, error) {
+    if !str.HasPrefix(pattern, "/") {
+        return nil, fmt.Errorf("route pattern '%s' must begin with /", pattern)
+    }
+    parts := splitRoutePath(pattern)
+    segs := make([]webRouteSeg, 0, len(parts))
+    seen := make(map[string]bool)
+    for i, p := range parts {
+        if !str.HasPrefix(p, "{") || !str.HasSuffix(p, "}") {
+            if str.ContainsAny(p, "{}") {
+                return nil, fmt.Errorf("route pattern '%s': parameters must span a whole segment", pattern)
+            }
+            segs = append(segs, webRouteSeg{literal: p})
+            continue
+        }
+        name := p[1 : len(p)-1]
+        seg := webRouteSeg{}
+        if str.HasSuffix(name, "...") {
+            if i != len(parts)-1 {
+                return nil, fmt.Errorf("route pattern '%s': {%s} must be the last segment", pattern, name)
+            }
+            name = str.TrimSuffix(name, "...")
+            seg.rest = true
+        }
+        if name == "" || str.ContainsAny(name, "{}") {
+            return nil, fmt.Errorf("route pattern '%s': invalid parameter name in '%s'", pattern, p)
+        }
+        if seen[name] {
+            return nil, fmt.Errorf("route pattern '%s': duplicate parameter '%s'", pattern, name)
+        }
+        seen[name] = true
+        seg.param = name
+        segs = append(segs, seg)
+    }
+    return segs, nil
+}
+
+// match compares a request path against the route, returning captured parameters.
+func (rt webRoute) match(path string) (map[string]any, bool) {
+    parts := splitRoutePath(path)
+    params := make(map[string]any)
+    for i, seg := range rt.segs {
+        if seg.rest {
+            params[seg.param] = str.Join(parts[i:], "/")
+            return params, true
+        }
+        if i >= len(parts) {
+            return nil, false
+        }
+        if seg.param != "" {
+            params[seg.param] = parts[i]
+            continue
+        }
+        if seg.literal != parts[i] {
+            return nil, false
+        }
+    }
+    if len(parts) != len(rt.segs) {
+        return nil, false
+    }
+    return params, true
+}
+
+func (rt webRoute) allows(method string) bool {
+    if rt.ws {
+        return method == "GET"
+    }
+    if len(rt.methods) == 0 {
+        return true
+    }
+    for _, m := range rt.methods {
+        if m == method || (method == "HEAD" && m == "GET") {
+            return true
+        }
+    }
+    return false
+}
+
+// webRequestMap builds the request map passed to route handlers and middleware.
+func webRequestMap(r *http.Request, route webRoute, params map[string]any, body []byte) map[string]any {
+    query := make(map[string]any)
+    for k, v := range r.URL.Query() {
+        if len(v) == 1 {
+            query[k] = v[0]
+        } else {
+            query[k] = stringsToAny(v)
+        }
+    }
+    headers := make(map[string]any)
+    for k, v := range r.Header {
+        headers[k] = str.Join(v, ", ")
+    }
+
+    req := map[string]any{
+        "method":  r.Method,
+        "path":    r.URL.Path,
+        "route":   route.pattern,
+        "params":  params,
+        "query":   query,
+        "headers": headers,
+        "body":    string(body),
+        "json":    nil,
+        "form":    map[string]any{},
+        "remote":  r.RemoteAddr,
+        "host":    r.Host,
+        "tls":     r.TLS != nil,
+    }
+    if r.TLS != nil {
+        if peer := tlsPeerInfo(r.TLS); peer != nil {
+            req["client_cert"] = peer
+        }
+    }
+
+    ctype := str.ToLower(r.Header.Get("Content-Type"))
+    switch {
+    case str.Contains(ctype, "json") && len(body) > 0:
+        var v any
+        if err := json.Unmarshal(body, &v); err != nil {
+            req["json_error"] = err.Error()
+        } else {
+            req["json"] = v
+        }
+    case str.HasPrefix(ctype, "application/x-www-form-urlencoded"):
+        if vals, err := parseFormBody(string(body)); err == nil {
+            req["form"] = vals
+        }
+    }
+    return req
+}
+
+func stringsToAny(v []string) []any {
+    l := make([]any, len(v))
+    for i, s := range v {
+        l[i] = s
+    }
+    return l
+}
+
+func parseFormBody(body string) (map[string]any, error) {
+    vals, err := url.ParseQuery(body)
+    if err != nil {
+        return nil, err
+    }
+    form := make(map[string]any)
+    for k, v := range vals {
+        if len(v) == 1 {
+            form[k] = v[0]
+        } else {
+            form[k] = stringsToAny(v)
+        }
+    }
+    return form, nil
+}
+
+// webReadBody reads the request body, up to the server's .max_body limit.
+func webReadBody(w http.ResponseWriter, r *http.Request, handle string) ([]byte, error) {
+    weblock.RLock()
+    limit := web_handles[handle].maxBody
+    weblock.RUnlock()
+    if limit <= 0 {
+        limit = WEB_MAX_BODY
+    }
+    return io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+}
+
+// webDispatchRoute services r from the routes registered on handle.
+// It returns the matched pattern and whether the request was handled.
+func webDispatchRoute(w http.ResponseWriter, r *http.Request, handle string, evalfs uint32, use_gzip bool) (string, bool) {
+    webrulelock.RLock()
+    routes := web_routes[handle]
+    mws := web_middleware[handle]
+    webrulelock.RUnlock()
+    if len(routes) == 0 {
+        return "", false
+    }
+
+    var allowed []string
+    for _, rt := range routes {
+        params, ok := rt.match(r.URL.Path)
+        if !ok {
+            continue
+        }
+        if !rt.allows(r.Method) {
+            if rt.ws {
+                allowed = append(allowed, "GET")
+            } else {
+                allowed = append(allowed, rt.methods...)
+            }
+            continue
+        }
+        if rt.ws && !isWebSocketUpgrade(r) {
+            w.Header().Set("Upgrade", "websocket")
+            w.Header().Set("Connection", "Upgrade")
+            http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+            return rt.pattern, true
+        }
+        if rt.ws && !wsOriginAllowed(r, rt.origins) {
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            return rt.pattern, true
+        }
+
+        body, err := webReadBody(w, r, handle)
+        if err != nil {
+            var tooBig *http.MaxBytesError
+            if errors.As(err, &tooBig) {
+                http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+            } else {
+                http.Error(w, "Bad Request", http.StatusBadRequest)
+            }
+            return rt.pattern, true
+        }
+        req := webRequestMap(r, rt, params, body)
+        for _, mw := range mws {
+            if !mw.covers(r.URL.Path) {
+                continue
+            }
+            res, err := zaCallFn(mw.fn, evalfs, ciLnet, req)
+            if err != nil {
+                webHandlerFailed(w, fmt.Errorf("error in web handler %s: %v", mw.fn, err))
+                return rt.pattern, true
+            }
+            if res == nil {
+                continue
+            }
+            if m, ok := res.(map[string]any); ok {
+                if next, found := m["request"]; found && len(m) == 1 {
+                    if nreq, ok := next.(map[string]any); ok {
+                        req = nreq
+                        continue
+                    }
+                }
+            }
+            webWriteHandlerResult(w, r, res, use_gzip)
+            return rt.pattern, true
+        }
+
+        if rt.ws {
+            wsServe(w, r, rt, handle, evalfs, req)
+            return rt.pattern, true
+        }
+        st := newWebStream(w, r)
+        res, err := zaCallFnWith(rt.fn, evalfs, ciLnet, func(loc uint32) func() {
+            webStreamBind(loc, st)
+            return func() { webStreamUnbind(loc, st) }
+        }, req)
+        if st.isStarted() {
+            if err != nil {
+                wlog_with_status(http.StatusInternalServerError, "error in web handler %s: %v\n", rt.fn, err)
+            }
+            return rt.pattern, true
+        }
+        if err != nil {
+            webHandlerFailed(w, fmt.Errorf("error in web handler %s: %v", rt.fn, err))
+            return rt.pattern, true
+        }
+        webWriteHandlerResult(w, r, res, use_gzip)
+        return rt.pattern, true
+    }
+
+    if len(allowed) > 0 {
+        sort.Strings(allowed)
+        w.Header().Set("Allow", str.Join(allowed, ", "))
+        http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+        return "<method not allowed>", true
+    }
+    return "", false
+}
+
+func webHandlerFailed(w http.ResponseWriter, err error) {
+    wlog_with_status(http.StatusInternalServerError, "%v\n", err)
+    http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// webWriteHandlerResult converts a handler or middleware return value into an HTTP response.
+func webWriteHandlerResult(w http.ResponseWriter, r *http.Request, res any, use_gzip bool) {
+    status := http.StatusOK
+    var data []byte
+    ctype := ""
+
+    switch rv := res.(type) {
+    case nil:
+        w.WriteHeader(http.StatusNoContent)
+        return
+    case string:
+        data = []byte(rv)
+    case []byte:
+        data = rv
+    case map[string]any:
+        for k := range rv {
+            if !webResponseKeys[k] {
+                webHandlerFailed(w, fmt.Errorf("web handler returned a response map with unknown key '%s'", k))
+                return
+            }
+        }
+        if sv, found := rv["status"]; found {
+            n, invalid := GetAsInt(sv)
+            if invalid || n < 100 || n > 999 {
+                webHandlerFailed(w, fmt.Errorf("web handler returned invalid status %v", sv))
+                return
+            }
+            status = n
+        }
+        if hv, found := rv["headers"]; found {
+            hm, ok := hv.(map[string]any)
+            if !ok {
+                webHandlerFailed(w, fmt.Errorf("web handler response .headers must be a map"))
+                return
+            }
+            for k, v := range hm {
+                switch v := v.(type) {
+                case []any:
+                    for _, e := range v {
+                        w.Header().Add(k, GetAsString(e))
+                    }
+                default:
+                    w.Header().Set(k, GetAsString(v))
+                }
+            }
+        }
+        if jv, found := rv["json"]; found {
+            b, err := json.Marshal(jv)
+            if err != nil {
+                webHandlerFailed(w, fmt.Errorf("web handler response could not be encoded as JSON: %v", err))
+                return
+            }
+            data = b
+            ctype = "application/json"
+        } else if bv, found := rv["body"]; found && bv != nil {
+            switch bv := bv.(type) {
+            case []byte:
+                data = bv
+            default:
+                data = []byte(GetAsString(bv))
+            }
+        }
+        if cv, found := rv["content_type"]; found {
+            ctype = GetAsString(cv)
+        }
+    default:
+        data = []byte(GetAsString(rv))
+    }
+
+    if ctype == "" && w.Header().Get("Content-Type") == "" && len(data) > 0 {
+        ctype = http.DetectContentType(data)
+    }
+    if ctype != "" {
+        w.Header().Set("Content-Type", ctype)
+    }
+
+    bodyless := status == http.StatusNoContent || status == http.StatusNotModified || r.Method == "HEAD"
+    if use_gzip && len(data) > 0 && !bodyless {
+        w.Header().Set("Content-Encoding", "gzip")
+        w.Header().Del("Content-Length")
+        w.WriteHeader(status)
+        gz := gzip.NewWriter(w)
+        gz.Write(data)
+        gz.Close()
+        return
+    }
+    w.WriteHeader(status)
+    if !bodyless {
+        w.Write(data)
+    }
+}
@@ -0,0 +1,691 @@
+//go:build !test
+
+package main
+
+import (
+    "bufio"
+    crand "crypto/rand"
+    "crypto/sha1"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    str "strings"
+    "sync"
+    "time"
+)
+
+/*
+   RFC 6455 websockets for web_route() "WS" routes and ws_connect() clients.
+
+   · each connection has a reader goroutine. it answers pings, collects pongs,
+     reassembles fragmented messages and queues them for ws_receive().
+   · writes are serialised per connection, so a handler, ws_broadcast() and
+     the reader's control replies may all write concurrently.
+   · server side connections belong to their web_serve_start() handle and are
+     closed (1001 going away) when that server stops.
+   · upgrades carrying an Origin from another host get a 403 unless the route
+     lists that origin in its .origins option.
+*/
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+    wsOpContinuation = 0x0
+    wsOpText         = 0x1
+    wsOpBinary       = 0x2
+    wsOpClose        = 0x8
+    wsOpPing         = 0x9
+    wsOpPong         = 0xA
+)
+
+const (
+    wsCloseNormal    = 1000
+    wsCloseGoingAway = 1001
+    wsCloseProtocol  = 1002
+    wsCloseNoStatus  = 1005
+    wsCloseAbnormal  = 1006
+    wsCloseTooBig    = 1009
+    wsCloseInternal  = 1011
+)
+
+// largest message accepted from a peer
+var wsMaxMessage int64 = 16 << 20
+
+type wsMessage struct {
+    typ  string // text, binary or close
+    data []byte
+}
+
+type wsConn struct {
+    id      string
+    conn    net.Conn
+    br      *bufio.Reader
+    client  bool   // client side connections mask their frames
+    server  string // owning web server handle, empty for clients
+    url     string
+    remote  string
+    wmu     sync.Mutex
+    msgs    chan wsMessage
+    pongs   chan []byte
+    done    chan struct{}
+    mu      sync.Mutex
+    closing bool // a close frame has been sent
+    closed  bool
+    code    int
+    reason  string
+    sent    int64
+    recv    int64
+    opened  time.Time
+}
+
+var (
+    wsConns = make(map[string]*wsConn)
+    wsLock  sync.RWMutex
+)
+
+func wsAcceptKey(key string) string {
+    h := sha1.New()
+    h.Write([]byte(key + wsGUID))
+    return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+    for _, v := range h.Values(name) {
+        for _, t := range str.Split(v, ",") {
+            if str.EqualFold(str.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// isWebSocketUpgrade reports whether r asks for a websocket upgrade.
+func isWebSocketUpgrade(r *http.Request) bool {
+    return r.Method == "GET" && headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func newWsConn(conn net.Conn, br *bufio.Reader, client bool, server, loc string) *wsConn {
+    ws := &wsConn{
+        id:     generateHandleID(),
+        conn:   conn,
+        br:     br,
+        client: client,
+        server: server,
+        url:    loc,
+        remote: conn.RemoteAddr().String(),
+        msgs:   make(chan wsMessage, 64),
+        pongs:  make(chan []byte, 1),
+        done:   make(chan struct{}),
+        opened: time.Now(),
+    }
+    wsLock.Lock()
+    wsConns[ws.id] = ws
+    wsLock.Unlock()
+    go ws.readLoop()
+    return ws
+}
+
+// wsOriginAllowed checks the Origin header of a websocket upgrade. Requests
+// without one (non-browser clients) are let through. Otherwise the origin's
+// host must be the request's own Host or be listed in allowed, either as a
+// full origin (scheme://host[:port]) or a bare host[:port]. "*" allows any.
+func wsOriginAllowed(r *http.Request, allowed []string) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    u, err := url.Parse(origin)
+    if err != nil || u.Host == "" {
+        return false
+    }
+    if str.EqualFold(u.Host, r.Host) {
+        return true
+    }
+    for _, a := range allowed {
+        a = str.TrimSuffix(a, "/")
+        if a == "*" || str.EqualFold(a, origin) || str.EqualFold(a, u.Host) {
+            return true
+        }
+    }
+    return false
+}
+
+// wsUpgrade completes the server side handshake on a routed request.
+func wsUpgrade(w http.ResponseWriter, r *http.Request, server string) (*wsConn, error) {
+    if r.Header.Get("Sec-WebSocket-Version") != "13" {
+        w.Header().Set("Sec-WebSocket-Version", "13")
+        http.Error(w, "Unsupported WebSocket Version", http.StatusBadRequest)
+        return nil, errors.New("unsupported websocket version")
+    }
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if key == "" {
+        http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+        return nil, errors.New("missing Sec-WebSocket-Key")
+    }
+    if rec, ok := w.(*webResponseRecorder); ok {
+        rec.status = http.StatusSwitchingProtocols
+    }
+    conn, brw, err := http.NewResponseController(w).Hijack()
+    if err != nil {
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return nil, fmt.Errorf("websocket hijack failed: %v", err)
+    }
+    conn.SetDeadline(time.Time{})
+    resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+        "Upgrade: websocket\r\n" +
+        "Connection: Upgrade\r\n" +
+        "Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+    if _, err := conn.Write([]byte(resp)); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    scheme := "ws"
+    if r.TLS != nil {
+        scheme = "wss"
+    }
+    return newWsConn(conn, brw.Reader, false, server, scheme+"://"+r.Host+r.URL.RequestURI()), nil
+}
+
+// wsDial opens a client connection to a ws:// or wss:// url.
+func wsDial(loc string, opts *httpOptions) (*wsConn, error) {
+    u, err := url.Parse(loc)
+    if err != nil {
+        return nil, err
+    }
+    secure := false
+    switch u.Scheme {
+    case "ws", "http":
+    case "wss", "https":
+        secure = true
+    default:
+        return nil, fmt.Errorf("unsupported scheme '%s' (want ws or wss)", u.Scheme)
+    }
+    addr := u.Host
+    if u.Port() == "" {
+        if secure {
+            addr = net.JoinHostPort(u.Hostname(), "443")
+        } else {
+            addr = net.JoinHostPort(u.Hostname(), "80")
+        }
+    }
+
+    timeout := opts.timeout
+    if timeout == 0 {
+        timeout = 30 * time.Second
+    }
+    dialer := &net.Dialer{Timeout: timeout}
+    var conn net.Conn
+    if secure {
+        tc, err := tlsClientConfig(opts.insecure, opts.caFile, opts.certFile, opts.keyFile, u.Hostname())
+        if err != nil {
+            return nil, err
+        }
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, tc)
+        if err != nil {
+            return nil, err
+        }
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+        if err != nil {
+            return nil, err
+        }
+    }
+    conn.SetDeadline(time.Now().Add(timeout))
+
+    nonce := make([]byte, 16)
+    crand.Read(nonce)
+    key := base64.StdEncoding.EncodeToString(nonce)
+
+    path := u.RequestURI()
+    if q := opts.query.Encode(); q != "" {
+        if u.RawQuery == "" {
+            path += "?" + q
+        } else {
+            path += "&" + q
+        }
+    }
+    req := &http.Request{Method: "GET", URL: &url.URL{Opaque: path}, Host: u.Host, Header: opts.headers.Clone(),
+        Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1}
+    if req.Header == nil {
+        req.Header = make(http.Header)
+    }
+    req.Header.Set("Upgrade", "websocket")
+    req.Header.Set("Connection", "Upgrade")
+    req.Header.Set("Sec-WebSocket-Key", key)
+    req.Header.Set("Sec-WebSocket-Version", "13")
+    switch {
+    case opts.hasBasic:
+        req.SetBasicAuth(opts.basicUser, opts.basicPass)
+    case opts.bearer != "":
+        req.Header.Set("Authorization", "Bearer "+opts.bearer)
+    }
+    if opts.userAgent != "" {
+        req.Header.Set("User-Agent", opts.userAgent)
+    }
+    if err := req.Write(conn); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    br := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(br, req)
+    if err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("bad handshake response: %v", err)
+    }
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        conn.Close()
+        return nil, fmt.Errorf("handshake refused: %s", resp.Status)
+    }
+    if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+        conn.Close()
+        return nil, errors.New("handshake failed: bad Sec-WebSocket-Accept")
+    }
+    conn.SetDeadline(time.Time{})
+    return newWsConn(conn, br, true, "", loc), nil
+}
+
+// writeFrame sends a single, unfragmented frame.
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+    hdr := make([]byte, 2, 14)
+    hdr[0] = 0x80 | op
+    n := len(payload)
+    switch {
+    case n < 126:
+        hdr[1] = byte(n)
+    case n <= 0xFFFF:
+        hdr[1] = 126
+        hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+    default:
+        hdr[1] = 127
+        hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+    }
+    data := payload
+    if ws.client {
+        hdr[1] |= 0x80
+        var mask [4]byte
+        crand.Read(mask[:])
+        hdr = append(hdr, mask[:]...)
+        data = make([]byte, n)
+        for i := range payload {
+            data[i] = payload[i] ^ mask[i%4]
+        }
+    }
+    ws.wmu.Lock()
+    defer ws.wmu.Unlock()
+    ws.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+    if _, err := ws.conn.Write(append(hdr, data...)); err != nil {
+        return err
+    }
+    return nil
+}
+
+// readFrame reads one frame, unmasking it as required.
+func (ws *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+    var h [2]byte
+    if _, err = io.ReadFull(ws.br, h[:]); err != nil {
+        return
+    }
+    fin = h[0]&0x80 != 0
+    op = h[0] & 0x0F
+    if h[0]&0x70 != 0 {
+        return fin, op, nil, errWsProtocol("reserved bits set")
+    }
+    masked := h[1]&0x80 != 0
+    if masked == ws.client {
+        if ws.client {
+            return fin, op, nil, errWsProtocol("masked frame from server")
+        }
+        return fin, op, nil, errWsProtocol("unmasked frame from client")
+    }
+    n := int64(h[1] & 0x7F)
+    switch n {
+    case 126:
+        var b [2]byte
+        if _, err = io.ReadFull(ws.br, b[:]); err != nil {
+            return
+        }
+        n = int64(binary.BigEndian.Uint16(b[:]))
+    case 127:
+        var b [8]byte
+        if _, err = io.ReadFull(ws.br, b[:]); err != nil {
+            return
+        }
+        n = int64(binary.BigEndian.Uint64(b[:]))
+    }
+    if op >= wsOpClose && (n > 125 || !fin) {
+        return fin, op, nil, errWsProtocol("invalid control frame")
+    }
+    if n < 0 || n > wsMaxMessage {
+        return fin, op, nil, wsError{wsCloseTooBig, "message too big"}
+    }
+    var mask [4]byte
+    if masked {
+        if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+            return
+        }
+    }
+    payload = make([]byte, n)
+    if _, err = io.ReadFull(ws.br, payload); err != nil {
+        return
+    }
+    if masked {
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+    }
+    return
+}
+
+type wsError struct {
+    code   int
+    reason string
+}
+
+func (e wsError) Error() string { return e.reason }
+
+func errWsProtocol(reason string) error {
+    return wsError{wsCloseProtocol, reason}
+}
+
+func (ws *wsConn) readLoop() {
+    defer close(ws.done)
+    defer close(ws.msgs)
+
+    var partial []byte
+    partialOp := byte(0)
+    for {
+        fin, op, payload, err := ws.readFrame()
+        if err != nil {
+            var we wsError
+            if errors.As(err, &we) {
+                ws.sendClose(we.code, we.reason)
+                ws.finish(we.code, we.reason)
+            } else {
+                ws.finish(wsCloseAbnormal, err.Error())
+            }
+            return
+        }
+
+        switch op {
+        case wsOpPing:
+            ws.writeFrame(wsOpPong, payload)
+            continue
+        case wsOpPong:
+            select {
+            case ws.pongs <- payload:
+            default:
+            }
+            continue
+        case wsOpClose:
+            code, reason := wsCloseNoStatus, ""
+            if len(payload) >= 2 {
+                code = int(binary.BigEndian.Uint16(payload[:2]))
+                reason = string(payload[2:])
+            }
+            // echo the close unless we started the closing handshake
+            ws.sendClose(code, "")
+            ws.finish(code, reason)
+            return
+        case wsOpText, wsOpBinary:
+            if partialOp != 0 {
+                ws.sendClose(wsCloseProtocol, "expected continuation frame")
+                ws.finish(wsCloseProtocol, "expected continuation frame")
+                return
+            }
+            partialOp, partial = op, payload
+        case wsOpContinuation:
+            if partialOp == 0 {
+                ws.sendClose(wsCloseProtocol, "unexpected continuation frame")
+                ws.finish(wsCloseProtocol, "unexpected continuation frame")
+                return
+            }
+            if int64(len(partial)+len(payload)) > wsMaxMessage {
+                ws.sendClose(wsCloseTooBig, "message too big")
+                ws.finish(wsCloseTooBig, "message too big")
+                return
+            }
+            partial = append(partial, payload...)
+        default:
+            ws.sendClose(wsCloseProtocol, "unknown opcode")
+            ws.finish(wsCloseProtocol, "unknown opcode")
+            return
+        }
+
+        if !fin {
+            continue
+        }
+        typ := "text"
+        if partialOp == wsOpBinary {
+            typ = "binary"
+        }
+        ws.mu.Lock()
+        ws.recv++
+        ws.mu.Unlock()
+        ws.msgs <- wsMessage{typ: typ, data: partial}
+        partialOp, partial = 0, nil
+    }
+}
+
+// sendClose starts (or answers) the closing handshake. Only the first call sends a frame.
+func (ws *wsConn) sendClose(code int, reason string) error {
+    ws.mu.Lock()
+    if ws.closing {
+        ws.mu.Unlock()
+        return nil
+    }
+    ws.closing = true
+    ws.mu.Unlock()
+
+    var payload []byte
+    if code != wsCloseNoStatus && code != wsCloseAbnormal {
+        payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+        if len(reason) > 123 {
+            reason = reason[:123]
+        }
+        payload = append(payload, reason...)
+    }
+    return ws.writeFrame(wsOpClose, payload)
+}
+
+// finish records why the connection ended and releases the socket.
+func (ws *wsConn) finish(code int, reason string) {
+    ws.mu.Lock()
+    if !ws.closed {
+        ws.closed = true
+        ws.code = code
+        ws.reason = reason
+    }
+    ws.mu.Unlock()
+    ws.conn.Close()
+}
+
+// close performs the closing handshake, waiting briefly for the peer's reply.
+func (ws *wsConn) close(code int, reason string) {
+    if err := ws.sendClose(code, reason); err == nil {
+        select {
+        case <-ws.done:
+        case <-time.After(2 * time.Second):
+        }
+    }
+    ws.finish(code, reason)
+    // unblock the reader if nobody is consuming messages
+    for range ws.msgs {
+    }
+    wsLock.Lock()
+    delete(wsConns, ws.id)
+    wsLock.Unlock()
+}
+
+func (ws *wsConn) isOpen() bool {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    return !ws.closing && !ws.closed
+}
+
+func (ws *wsConn) send(binaryFrame bool, data []byte) error {
+    if !ws.isOpen() {
+        return errors.New("connection is closed")
+    }
+    op := byte(wsOpText)
+    if binaryFrame {
+        op = wsOpBinary
+    }
+    if err := ws.writeFrame(op, data); err != nil {
+        return err
+    }
+    ws.mu.Lock()
+    ws.sent++
+    ws.mu.Unlock()
+    return nil
+}
+
+// receive waits up to timeout (forever when zero) for the next message.
+func (ws *wsConn) receive(timeout time.Duration) map[string]any {
+    var expired <-chan time.Time
+    if timeout > 0 {
+        t := time.NewTimer(timeout)
+        defer t.Stop()
+        expired = t.C
+    }
+    select {
+    case m, ok := <-ws.msgs:
+        if ok {
+            return map[string]any{"type": m.typ, "data": string(m.data), "code": 0, "error": ""}
+        }
+    case <-expired:
+        return map[string]any{"type": "timeout", "data": "", "code": 0, "error": ""}
+    }
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    errStr := ""
+    if ws.code != wsCloseNormal && ws.code != wsCloseGoingAway && ws.code != wsCloseNoStatus {
+        errStr = ws.reason
+    }
+    return map[string]any{"type": "close", "data": ws.reason, "code": ws.code, "error": errStr}
+}
+
+// ping sends a ping and waits for the matching pong, returning the round trip time.
+func (ws *wsConn) ping(timeout time.Duration) (time.Duration, error) {
+    if !ws.isOpen() {
+        return 0, errors.New("connection is closed")
+    }
+    select {
+    case <-ws.pongs:
+    default:
+    }
+    token := make([]byte, 8)
+    crand.Read(token)
+    start := time.Now()
+    if err := ws.writeFrame(wsOpPing, token); err != nil {
+        return 0, err
+    }
+    expired := time.After(timeout)
+    for {
+        select {
+        case p := <-ws.pongs:
+            if string(p) == string(token) {
+                return time.Since(start), nil
+            }
+        case <-ws.done:
+            return 0, errors.New("connection closed")
+        case <-expired:
+            return 0, errors.New("timed out waiting for pong")
+        }
+    }
+}
+
+func (ws *wsConn) toMap() map[string]any {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+    return map[string]any{
+        "open":         !ws.closing && !ws.closed,
+        "client":       ws.client,
+        "server":       ws.server,
+        "url":          ws.url,
+        "remote":       ws.remote,
+        "sent":         ws.sent,
+        "received":     ws.recv,
+        "close_code":   ws.code,
+        "close_reason": ws.reason,
+        "opened":       ws.opened.Unix(),
+    }
+}
+
+func wsLookup(fname, id string) (*wsConn, error) {
+    wsLock.RLock()
+    ws, found := wsConns[id]
+    wsLock.RUnlock()
+    if !found {
+        return nil, fmt.Errorf("%s: unknown websocket handle '%s'", fname, id)
+    }
+    return ws, nil
+}
+
+// wsServerConns lists the open connections accepted by a web server.
+func wsServerConns(server string) []*wsConn {
+    var l []*wsConn
+    wsLock.RLock()
+    for _, ws := range wsConns {
+        if ws.server == server {
+            l = append(l, ws)
+        }
+    }
+    wsLock.RUnlock()
+    return l
+}
+
+// wsCloseServer closes every websocket belonging to a stopping web server.
+func wsCloseServer(server string) {
+    var wg sync.WaitGroup
+    for _, ws := range wsServerConns(server) {
+        wg.Add(1)
+        go func(ws *wsConn) {
+            defer wg.Done()
+            ws.close(wsCloseGoingAway, "server shutting down")
+        }(ws)
+    }
+    wg.Wait()
+}
+
+// wsServe upgrades a request matched by a WS route and runs its handler for the life of the connection.
+func wsServe(w http.ResponseWriter, r *http.Request, rt webRoute, handle string, evalfs uint32, req map[string]any) {
+    ws, err := wsUpgrade(w, r, handle)
+    if err != nil {
+        wlog_with_status(http.StatusBadRequest, "websocket upgrade for %s failed: %v\n", r.URL.Path, err)
+        return
+    }
+    if _, err := zaCallFn(rt.fn, evalfs, ciLnet, ws.id, req); err != nil {
+        wlog_with_status(http.StatusInternalServerError, "error in web handler %s: %v\n", rt.fn, err)
+        ws.close(wsCloseInternal, "internal error")
+        return
+    }
+    ws.close(wsCloseNormal, "")
+}
+
+// http_request() options that also apply to the websocket handshake
+var wsConnectKeys = map[string]bool{"headers": true, "query": true, "timeout": true, "basic_auth": true, "bearer": true,
+    "user_agent": true, "ca_file": true, "cert_file": true, "key_file": true, "insecure": true}
+
+func wsConnectOptions(arg any) (httpOptions, error) {
+    if m, ok := arg.(map[string]any); ok {
+        for k := range m {
+            if !wsConnectKeys[k] {
+                return httpOptions{}, fmt.Errorf("ws_connect: option '%s' is not supported for websockets", k)
+            }
+        }
+    }
+    return parseHttpOptions("ws_connect", arg)
+}
+
+func wsSeconds(v any) time.Duration {
+    f, _ := GetAsFloat(v)
+    return time.Duration(f * float64(time.Second))
+}
@@ -0,0 +1,186 @@
+//go:build !test
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    str "strings"
+    "sync"
+)
+
+/*
+   streaming responses and server-sent events for web handlers.
+
+   · route and 'f' rule handlers are called with a stream slot bound to their
+     function space. calling web_stream() or web_sse() from the handler sends
+     the headers at once and returns a stream handle for web_stream_write()
+     and web_sse_send().
+   · once a stream has started the handler's return value is ignored.
+   · the handle stops working when the handler returns, as the response is
+     then complete. streamed bodies are never gzipped.
+*/
+
+type webStream struct {
+    id      string
+    w       http.ResponseWriter
+    r       *http.Request
+    rc      *http.ResponseController
+    mu      sync.Mutex
+    started bool
+    sse     bool
+    done    bool // handler has returned
+    failed  bool // a write to the client failed
+    written int64
+}
+
+var (
+    webStreamSlots = make(map[uint32]*webStream) // by handler function space
+    webStreams     = make(map[string]*webStream) // started streams, by handle
+    webStreamLock  sync.Mutex
+)
+
+func newWebStream(w http.ResponseWriter, r *http.Request) *webStream {
+    return &webStream{w: w, r: r, rc: http.NewResponseController(w)}
+}
+
+func webStreamBind(loc uint32, st *webStream) {
+    webStreamLock.Lock()
+    webStreamSlots[loc] = st
+    webStreamLock.Unlock()
+}
+
+// webStreamUnbind detaches the slot once the handler has returned, ending the stream.
+func webStreamUnbind(loc uint32, st *webStream) {
+    webStreamLock.Lock()
+    delete(webStreamSlots, loc)
+    if st.id != "" {
+        delete(webStreams, st.id)
+    }
+    webStreamLock.Unlock()
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    st.done = true
+}
+
+// webStreamBegin sends the status and headers for a stream started from the handler running in evalfs.
+func webStreamBegin(fname string, evalfs uint32, ctype string, status int, headers map[string]any, sse bool) (string, error) {
+    webStreamLock.Lock()
+    st, found := webStreamSlots[evalfs]
+    webStreamLock.Unlock()
+    if !found {
+        return "", fmt.Errorf("%s must be called from a web handler", fname)
+    }
+
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    if st.started {
+        return "", fmt.Errorf("%s: response is already streaming", fname)
+    }
+    if status < 100 || status > 999 {
+        return "", fmt.Errorf("%s: invalid status %d", fname, status)
+    }
+    h := st.w.Header()
+    for k, v := range headers {
+        h.Set(k, GetAsString(v))
+    }
+    h.Set("Content-Type", ctype)
+    h.Del("Content-Length")
+    if sse {
+        h.Set("Cache-Control", "no-cache")
+        h.Set("X-Accel-Buffering", "no")
+    }
+    st.w.WriteHeader(status)
+    st.rc.Flush()
+
+    st.started = true
+    st.sse = sse
+    st.id = generateHandleID()
+    webStreamLock.Lock()
+    webStreams[st.id] = st
+    webStreamLock.Unlock()
+    return st.id, nil
+}
+
+func webStreamLookup(fname, id string) (*webStream, error) {
+    webStreamLock.Lock()
+    st, found := webStreams[id]
+    webStreamLock.Unlock()
+    if !found {
+        return nil, fmt.Errorf("%s: unknown or finished stream '%s'", fname, id)
+    }
+    return st, nil
+}
+
+// write sends and flushes a chunk, returning false once the client has gone.
+func (st *webStream) write(data []byte) bool {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    if st.done || st.failed || st.r.Context().Err() != nil {
+        return false
+    }
+    if st.r.Method == "HEAD" {
+        return true
+    }
+    n, err := st.w.Write(data)
+    st.written += int64(n)
+    if err == nil {
+        err = st.rc.Flush()
+    }
+    if err != nil {
+        st.failed = true
+        return false
+    }
+    return true
+}
+
+func (st *webStream) isStarted() bool {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.started
+}
+
+func (st *webStream) closed() bool {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    return st.done || st.failed || st.r.Context().Err() != nil
+}
+
+// sseEvent formats one server-sent event. Multi-line data becomes several data: fields.
+func sseEvent(data, event, id string, retry int) []byte {
+    var b str.Builder
+    if event != "" {
+        b.WriteString("event: " + sseField(event) + "\n")
+    }
+    if id != "" {
+        b.WriteString("id: " + sseField(id) + "\n")
+    }
+    if retry > 0 {
+        b.WriteString("retry: " + strconv.Itoa(retry) + "\n")
+    }
+    data = str.ReplaceAll(data, "\r\n", "\n")
+    for _, line := range str.Split(data, "\n") {
+        b.WriteString("data: " + line + "\n")
+    }
+    b.WriteString("\n")
+    return []byte(b.String())
+}
+
+// sseField strips line breaks, which would end the field early.
+func sseField(s string) string {
+    return str.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// sseData renders a web_sse_send() payload, encoding non-strings as JSON.
+func sseData(v any) (string, error) {
+    if s, ok := v.(string); ok {
+        return s, nil
+    }
+    b, err := json.Marshal(v)
+    if err != nil {
+        return "", err
+    }
+    return string(b), nil
+}
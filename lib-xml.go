@@ -0,0 +1,1119 @@
+//go:build !test
+
+package main
+
+import (
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// XML documents are represented as node maps:
+//
+//   .name      qualified element name as written (e.g. "soap:Envelope")
+//   .attrs     map of attribute name -> string value (xmlns declarations included)
+//   .text      the element's own character data, trimmed unless .trim false is given
+//   .children  array of child node maps, in document order
+//
+// Element names keep their prefixes so that xml_marshal reproduces them, and
+// xml_query name tests without a prefix match on the local part of the name.
+
+func buildXmlLib() {
+    features["xml"] = Feature{version: 1, category: "data"}
+    categories["xml"] = []string{"xml_parse", "xml_query", "xml_marshal"}
+
+    slhelp["xml_parse"] = LibHelp{in: "xml_string[,options]", out: "map",
+        action: "Parses XML into a node map with fields .name, .attrs, .text and .children, for the root element.\n" +
+            "[#SOL]Options: map(.trim true, .strict true). Non-strict mode accepts common HTML entities and unclosed tags."}
+    stdlib["xml_parse"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("xml_parse", args, 2,
+            "2", "string", "map",
+            "1", "string"); !ok {
+            return nil, err
+        }
+        trim, strict := true, true
+        if len(args) == 2 {
+            for k, v := range args[1].(map[string]any) {
+                b, isBool := v.(bool)
+                if !isBool {
+                    return nil, fmt.Errorf("xml_parse option '%s' must be a boolean", k)
+                }
+                switch k {
+                case "trim":
+                    trim = b
+                case "strict":
+                    strict = b
+                default:
+                    return nil, fmt.Errorf("unknown xml_parse option '%s'", k)
+                }
+            }
+        }
+        root, err := parseXML(args[0].(string), trim, strict)
+        if err != nil {
+            return nil, fmt.Errorf("xml_parse error: %v", err)
+        }
+        return root, nil
+    }
+
+    slhelp["xml_query"] = LibHelp{in: "node,xpath", out: "[]any",
+        action: "Evaluates an XPath subset against [#i1]node[#i0] and returns the matching nodes (or strings, for @attribute\n" +
+            "[#SOL]and text() steps). Supports / // . .. * name prefix:name @name @* text() node(), | unions and predicates\n" +
+            "[#SOL]with positions, last(), position(), count(), name(), local-name(), contains(), starts-with(), ends-with(),\n" +
+            "[#SOL]string-length(), normalize-space(), not(), comparisons (= != < <= > >=), and, or.\n" +
+            "[#SOL]Relative paths start at [#i1]node[#i0]; absolute paths start at the document containing it."}
+    stdlib["xml_query"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("xml_query", args, 1, "2", "map", "string"); !ok {
+            return nil, err
+        }
+        expr, err := parseXPath(args[1].(string))
+        if err != nil {
+            return nil, fmt.Errorf("xml_query: %v", err)
+        }
+        root := &xctx{node: args[0].(map[string]any)}
+        items, err := expr.eval(root, 1, 1)
+        if err != nil {
+            return nil, fmt.Errorf("xml_query: %v", err)
+        }
+        set, isSet := items.([]xitem)
+        if !isSet {
+            return []any{items}, nil
+        }
+        result := make([]any, len(set))
+        for i, it := range set {
+            if it.ctx != nil {
+                result[i] = it.ctx.node
+            } else {
+                result[i] = it.str
+            }
+        }
+        return result, nil
+    }
+
+    slhelp["xml_marshal"] = LibHelp{in: "node[,options]", out: "string",
+        action: "Serialises a node map (as returned by xml_parse) to XML text.\n" +
+            "[#SOL]Options: map(.indent \"  \", .declaration false). Use .indent \"\" for compact output."}
+    stdlib["xml_marshal"] = func(ns string, evalfs uint32, ident *[]Variable, args ...any) (ret any, err error) {
+        if ok, err := expect_args("xml_marshal", args, 2,
+            "2", "map", "map",
+            "1", "map"); !ok {
+            return nil, err
+        }
+        indent, declaration := "  ", false
+        if len(args) == 2 {
+            for k, v := range args[1].(map[string]any) {
+                switch k {
+                case "indent":
+                    s, isStr := v.(string)
+                    if !isStr {
+                        return nil, errors.New("xml_marshal option 'indent' must be a string")
+                    }
+                    indent = s
+                case "declaration":
+                    b, isBool := v.(bool)
+                    if !isBool {
+                        return nil, errors.New("xml_marshal option 'declaration' must be a boolean")
+                    }
+                    declaration = b
+                default:
+                    return nil, fmt.Errorf("unknown xml_marshal option '%s'", k)
+                }
+            }
+        }
+        var sb strings.Builder
+        if declaration {
+            sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+            sb.WriteString("\n")
+        }
+        if err := xmlWriteNode(&sb, args[0].(map[string]any), indent, 0); err != nil {
+            return nil, fmt.Errorf("xml_marshal: %v", err)
+        }
+        if indent != "" {
+            sb.WriteString("\n")
+        }
+        return sb.String(), nil
+    }
+}
+
+func xmlQName(n xml.Name) string {
+    if n.Space == "" {
+        return n.Local
+    }
+    return n.Space + ":" + n.Local
+}
+
+// parseXML builds the node tree for the document's root element.
+func parseXML(input string, trim, strict bool) (map[string]any, error) {
+    d := xml.NewDecoder(strings.NewReader(input))
+    d.Strict = strict
+    if !strict {
+        d.AutoClose = xml.HTMLAutoClose
+        d.Entity = xml.HTMLEntity
+    }
+
+    type open struct {
+        node map[string]any
+        kids []any
+        text strings.Builder
+    }
+    var stack []*open
+    var root map[string]any
+
+    closeTop := func() {
+        top := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        text := top.text.String()
+        if trim {
+            text = strings.TrimSpace(text)
+        }
+        top.node["text"] = text
+        top.node["children"] = top.kids
+        if len(stack) > 0 {
+            parent := stack[len(stack)-1]
+            parent.kids = append(parent.kids, top.node)
+        } else if root == nil {
+            root = top.node
+        }
+    }
+
+    for {
+        // RawToken keeps namespace prefixes as written; tags are matched below.
+        tok, err := d.RawToken()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            attrs := make(map[string]any, len(t.Attr))
+            for _, a := range t.Attr {
+                attrs[xmlQName(a.Name)] = a.Value
+            }
+            node := map[string]any{"name": xmlQName(t.Name), "attrs": attrs}
+            stack = append(stack, &open{node: node, kids: []any{}})
+        case xml.EndElement:
+            name := xmlQName(t.Name)
+            if len(stack) == 0 {
+                return nil, fmt.Errorf("unexpected end element </%s> on line %d", name, xmlLine(d, input))
+            }
+            if !strict {
+                // close any elements left open inside this one
+                for len(stack) > 1 && stack[len(stack)-1].node["name"] != name {
+                    closeTop()
+                }
+            } else if top := stack[len(stack)-1].node["name"]; top != name {
+                return nil, fmt.Errorf("element <%s> closed by </%s> on line %d", top, name, xmlLine(d, input))
+            }
+            closeTop()
+        case xml.CharData:
+            if len(stack) > 0 {
+                stack[len(stack)-1].text.Write(t)
+            }
+        }
+    }
+    if len(stack) > 0 {
+        if strict {
+            return nil, fmt.Errorf("element <%s> is not closed", stack[len(stack)-1].node["name"])
+        }
+        for len(stack) > 0 {
+            closeTop()
+        }
+    }
+    if root == nil {
+        return nil, errors.New("no root element")
+    }
+    return root, nil
+}
+
+func xmlLine(d *xml.Decoder, input string) int {
+    off := int(d.InputOffset())
+    if off > len(input) {
+        off = len(input)
+    }
+    return strings.Count(input[:off], "\n") + 1
+}
+
+func xmlNodeName(m map[string]any) string {
+    s, _ := m["name"].(string)
+    return s
+}
+
+func xmlNodeText(m map[string]any) string {
+    s, _ := m["text"].(string)
+    return s
+}
+
+func xmlNodeAttrs(m map[string]any) map[string]any {
+    a, _ := m["attrs"].(map[string]any)
+    return a
+}
+
+// xmlNodeChildren returns the element children of m, skipping anything that is not a node map.
+func xmlNodeChildren(m map[string]any) []map[string]any {
+    var kids []map[string]any
+    switch c := m["children"].(type) {
+    case []any:
+        for _, k := range c {
+            if km, ok := k.(map[string]any); ok {
+                kids = append(kids, km)
+            }
+        }
+    case []map[string]any:
+        kids = c
+    }
+    return kids
+}
+
+// xmlStringValue is the XPath string-value of an element: all descendant text.
+func xmlStringValue(m map[string]any) string {
+    var sb strings.Builder
+    sb.WriteString(xmlNodeText(m))
+    for _, k := range xmlNodeChildren(m) {
+        sb.WriteString(xmlStringValue(k))
+    }
+    return sb.String()
+}
+
+func xmlLocalName(name string) string {
+    if i := strings.LastIndexByte(name, ':'); i >= 0 {
+        return name[i+1:]
+    }
+    return name
+}
+
+func xmlEscape(s string, attr bool) string {
+    var sb strings.Builder
+    for _, r := range s {
+        switch r {
+        case '&':
+            sb.WriteString("&amp;")
+        case '<':
+            sb.WriteString("&lt;")
+        case '>':
+            sb.WriteString("&gt;")
+        case '"':
+            if attr {
+                sb.WriteString("&quot;")
+            } else {
+                sb.WriteRune(r)
+            }
+        case '\n', '\r', '\t':
+            if attr {
+                fmt.Fprintf(&sb, "&#x%X;", r)
+            } else {
+                sb.WriteRune(r)
+            }
+        default:
+            sb.WriteRune(r)
+        }
+    }
+    return sb.String()
+}
+
+func xmlWriteNode(sb *strings.Builder, node map[string]any, indent string, depth int) error {
+    name := xmlNodeName(node)
+    if name == "" {
+        return errors.New("node has no .name")
+    }
+    pad := strings.Repeat(indent, depth)
+    sb.WriteString(pad + "<" + name)
+
+    attrs := xmlNodeAttrs(node)
+    keys := make([]string, 0, len(attrs))
+    for k := range attrs {
+        keys = append(keys, k)
+    }
+    // namespace declarations first, then attributes by name
+    sort.Slice(keys, func(i, j int) bool {
+        xi, xj := strings.HasPrefix(keys[i], "xmlns"), strings.HasPrefix(keys[j], "xmlns")
+        if xi != xj {
+            return xi
+        }
+        return keys[i] < keys[j]
+    })
+    for _, k := range keys {
+        sb.WriteString(" " + k + `="` + xmlEscape(GetAsString(attrs[k]), true) + `"`)
+    }
+
+    text := xmlNodeText(node)
+    kids := xmlNodeChildren(node)
+    switch {
+    case len(kids) == 0 && text == "":
+        sb.WriteString("/>")
+        return nil
+    case len(kids) == 0:
+        sb.WriteString(">" + xmlEscape(text, false) + "</" + name + ">")
+        return nil
+    }
+
+    sb.WriteString(">")
+    if text != "" {
+        if indent != "" {
+            sb.WriteString("\n" + pad + indent)
+        }
+        sb.WriteString(xmlEscape(text, false))
+    }
+    for _, k := range kids {
+        if indent != "" {
+            sb.WriteString("\n")
+        }
+        if err := xmlWriteNode(sb, k, indent, depth+1); err != nil {
+            return err
+        }
+    }
+    if indent != "" {
+        sb.WriteString("\n" + pad)
+    }
+    sb.WriteString("</" + name + ">")
+    return nil
+}
+
+// XPath subset
+
+// xctx is an element in the context of a query; parent is nil for the
+// node passed to xml_query and for the virtual document node.
+type xctx struct {
+    node   map[string]any
+    parent *xctx
+    isDoc  bool
+}
+
+// xitem is one member of a node-set: an element, or a string from @attr/text().
+type xitem struct {
+    ctx *xctx
+    str string
+}
+
+func (it xitem) stringValue() string {
+    if it.ctx != nil {
+        return xmlStringValue(it.ctx.node)
+    }
+    return it.str
+}
+
+const (
+    xaxisChild = iota
+    xaxisDescOrSelf
+    xaxisSelf
+    xaxisParent
+    xaxisAttr
+    xaxisText
+    xaxisNode
+)
+
+type xstep struct {
+    axis  int
+    test  string // name test: "*", "name" or "prefix:name"
+    preds []*xexpr
+}
+
+type xpath struct {
+    abs   bool
+    steps []xstep
+}
+
+const (
+    xeOr = iota
+    xeAnd
+    xeCmp
+    xeStr
+    xeNum
+    xeFunc
+    xePath
+    xeUnion
+)
+
+type xexpr struct {
+    kind int
+    op   string
+    args []*xexpr
+    str  string
+    num  float64
+    path *xpath
+}
+
+type xtoken struct {
+    kind string // "op", "str", "num", "name"
+    val  string
+}
+
+func lexXPath(s string) ([]xtoken, error) {
+    var toks []xtoken
+    i := 0
+    for i < len(s) {
+        c := s[i]
+        switch {
+        case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+            i++
+        case strings.HasPrefix(s[i:], "//"), strings.HasPrefix(s[i:], ".."), strings.HasPrefix(s[i:], "!="),
+            strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="), strings.HasPrefix(s[i:], "::"):
+            toks = append(toks, xtoken{"op", s[i : i+2]})
+            i += 2
+        case c == '.' && (i+1 >= len(s) || s[i+1] < '0' || s[i+1] > '9'):
+            toks = append(toks, xtoken{"op", "."})
+            i++
+        case strings.IndexByte("/[]()@,|*=<>", c) >= 0:
+            toks = append(toks, xtoken{"op", string(c)})
+            i++
+        case c == '"' || c == '\'':
+            end := strings.IndexByte(s[i+1:], c)
+            if end < 0 {
+                return nil, fmt.Errorf("unterminated string in '%s'", s)
+            }
+            toks = append(toks, xtoken{"str", s[i+1 : i+1+end]})
+            i += end + 2
+        case (c >= '0' && c <= '9') || c == '.':
+            j := i
+            for j < len(s) && ((s[j] >= '0' && s[j] <= '9') || s[j] == '.') {
+                j++
+            }
+            toks = append(toks, xtoken{"num", s[i:j]})
+            i = j
+        case c == '_' || c >= 0x80 || (c|0x20 >= 'a' && c|0x20 <= 'z'):
+            j := i
+        name:
+            for j < len(s) {
+                d := s[j]
+                switch {
+                case d == '_' || d == '-' || d == '.' || d >= 0x80 || (d >= '0' && d <= '9') || (d|0x20 >= 'a' && d|0x20 <= 'z'):
+                    j++
+                case d == ':' && strings.HasPrefix(s[j:], ":*"):
+                    j += 2 // prefix:*
+                    break name
+                case d == ':' && j+1 < len(s) && s[j+1] != ':':
+                    j++ // prefix:name
+                default:
+                    break name
+                }
+            }
+            toks = append(toks, xtoken{"name", s[i:j]})
+            i = j
+        default:
+            return nil, fmt.Errorf("unexpected '%c' in '%s'", c, s)
+        }
+    }
+    return toks, nil
+}
+
+type xparser struct {
+    toks []xtoken
+    pos  int
+    src  string
+}
+
+func (p *xparser) peek() xtoken {
+    if p.pos < len(p.toks) {
+        return p.toks[p.pos]
+    }
+    return xtoken{}
+}
+
+func (p *xparser) peekAt(n int) xtoken {
+    if p.pos+n < len(p.toks) {
+        return p.toks[p.pos+n]
+    }
+    return xtoken{}
+}
+
+func (p *xparser) isOp(v string) bool {
+    t := p.peek()
+    return t.kind == "op" && t.val == v
+}
+
+func (p *xparser) expect(v string) error {
+    if !p.isOp(v) {
+        return fmt.Errorf("expected '%s' in '%s'", v, p.src)
+    }
+    p.pos++
+    return nil
+}
+
+func parseXPath(s string) (*xexpr, error) {
+    toks, err := lexXPath(s)
+    if err != nil {
+        return nil, err
+    }
+    if len(toks) == 0 {
+        return nil, errors.New("empty path")
+    }
+    p := &xparser{toks: toks, src: s}
+    e, err := p.parseOr()
+    if err != nil {
+        return nil, err
+    }
+    if p.pos < len(p.toks) {
+        return nil, fmt.Errorf("unexpected '%s' in '%s'", p.peek().val, s)
+    }
+    return e, nil
+}
+
+func (p *xparser) parseOr() (*xexpr, error) {
+    left, err := p.parseAnd()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek().kind == "name" && p.peek().val == "or" {
+        p.pos++
+        right, err := p.parseAnd()
+        if err != nil {
+            return nil, err
+        }
+        left = &xexpr{kind: xeOr, args: []*xexpr{left, right}}
+    }
+    return left, nil
+}
+
+func (p *xparser) parseAnd() (*xexpr, error) {
+    left, err := p.parseCmp()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek().kind == "name" && p.peek().val == "and" {
+        p.pos++
+        right, err := p.parseCmp()
+        if err != nil {
+            return nil, err
+        }
+        left = &xexpr{kind: xeAnd, args: []*xexpr{left, right}}
+    }
+    return left, nil
+}
+
+func (p *xparser) parseCmp() (*xexpr, error) {
+    left, err := p.parseUnion()
+    if err != nil {
+        return nil, err
+    }
+    if t := p.peek(); t.kind == "op" {
+        switch t.val {
+        case "=", "!=", "<", "<=", ">", ">=":
+            p.pos++
+            right, err := p.parseUnion()
+            if err != nil {
+                return nil, err
+            }
+            return &xexpr{kind: xeCmp, op: t.val, args: []*xexpr{left, right}}, nil
+        }
+    }
+    return left, nil
+}
+
+func (p *xparser) parseUnion() (*xexpr, error) {
+    left, err := p.parsePrimary()
+    if err != nil {
+        return nil, err
+    }
+    for p.isOp("|") {
+        p.pos++
+        right, err := p.parsePrimary()
+        if err != nil {
+            return nil, err
+        }
+        left = &xexpr{kind: xeUnion, args: []*xexpr{left, right}}
+    }
+    return left, nil
+}
+
+func (p *xparser) parsePrimary() (*xexpr, error) {
+    t := p.peek()
+    switch {
+    case t.kind == "str":
+        p.pos++
+        return &xexpr{kind: xeStr, str: t.val}, nil
+    case t.kind == "num":
+        p.pos++
+        n, err := strconv.ParseFloat(t.val, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid number '%s'", t.val)
+        }
+        return &xexpr{kind: xeNum, num: n}, nil
+    case t.kind == "op" && t.val == "(":
+        p.pos++
+        e, err := p.parseOr()
+        if err != nil {
+            return nil, err
+        }
+        return e, p.expect(")")
+    case t.kind == "name" && p.peekAt(1).kind == "op" && p.peekAt(1).val == "(" && t.val != "text" && t.val != "node":
+        p.pos += 2
+        fn := &xexpr{kind: xeFunc, op: t.val}
+        for !p.isOp(")") {
+            arg, err := p.parseOr()
+            if err != nil {
+                return nil, err
+            }
+            fn.args = append(fn.args, arg)
+            if !p.isOp(",") {
+                break
+            }
+            p.pos++
+        }
+        return fn, p.expect(")")
+    }
+    path, err := p.parsePath()
+    if err != nil {
+        return nil, err
+    }
+    return &xexpr{kind: xePath, path: path}, nil
+}
+
+func (p *xparser) parsePath() (*xpath, error) {
+    path := &xpath{}
+    if p.isOp("/") {
+        path.abs = true
+        p.pos++
+        // a lone "/" selects the document
+        if t := p.peek(); t.kind == "" || (t.kind == "op" && t.val != "." && t.val != ".." && t.val != "@" && t.val != "*") {
+            return path, nil
+        }
+    } else if p.isOp("//") {
+        path.abs = true
+        p.pos++
+        path.steps = append(path.steps, xstep{axis: xaxisDescOrSelf})
+    }
+    for {
+        step, err := p.parseStep()
+        if err != nil {
+            return nil, err
+        }
+        path.steps = append(path.steps, step)
+        switch {
+        case p.isOp("/"):
+            p.pos++
+        case p.isOp("//"):
+            p.pos++
+            path.steps = append(path.steps, xstep{axis: xaxisDescOrSelf})
+        default:
+            return path, nil
+        }
+    }
+}
+
+func (p *xparser) parseStep() (xstep, error) {
+    var step xstep
+    t := p.peek()
+    switch {
+    case t.kind == "op" && t.val == ".":
+        p.pos++
+        step.axis = xaxisSelf
+    case t.kind == "op" && t.val == "..":
+        p.pos++
+        step.axis = xaxisParent
+    case t.kind == "op" && t.val == "@":
+        p.pos++
+        n := p.peek()
+        if !(n.kind == "name" || (n.kind == "op" && n.val == "*")) {
+            return step, fmt.Errorf("expected attribute name after '@' in '%s'", p.src)
+        }
+        p.pos++
+        step.axis, step.test = xaxisAttr, n.val
+    case t.kind == "op" && t.val == "*":
+        p.pos++
+        step.axis, step.test = xaxisChild, "*"
+    case t.kind == "name":
+        p.pos++
+        if p.isOp("(") {
+            if err := p.expect("("); err != nil {
+                return step, err
+            }
+            if err := p.expect(")"); err != nil {
+                return step, err
+            }
+            if t.val == "text" {
+                step.axis = xaxisText
+            } else {
+                step.axis = xaxisNode
+            }
+        } else {
+            step.axis, step.test = xaxisChild, t.val
+        }
+    default:
+        return step, fmt.Errorf("expected a path step in '%s'", p.src)
+    }
+    for p.isOp("[") {
+        p.pos++
+        pred, err := p.parseOr()
+        if err != nil {
+            return step, err
+        }
+        if err := p.expect("]"); err != nil {
+            return step, err
+        }
+        step.preds = append(step.preds, pred)
+    }
+    return step, nil
+}
+
+func xnameMatch(test, name string) bool {
+    if test == "*" || test == name {
+        return true
+    }
+    if strings.HasSuffix(test, ":*") {
+        return strings.HasPrefix(name, strings.TrimSuffix(test, "*"))
+    }
+    return !strings.Contains(test, ":") && xmlLocalName(name) == test
+}
+
+func (c *xctx) children() []*xctx {
+    var out []*xctx
+    for _, k := range xmlNodeChildren(c.node) {
+        out = append(out, &xctx{node: k, parent: c})
+    }
+    return out
+}
+
+func (c *xctx) descendantsOrSelf(out []*xctx) []*xctx {
+    out = append(out, c)
+    for _, k := range c.children() {
+        out = k.descendantsOrSelf(out)
+    }
+    return out
+}
+
+// applyStep evaluates one step from a single context, including its predicates.
+func (s xstep) apply(c *xctx) ([]xitem, error) {
+    var cands []xitem
+    switch s.axis {
+    case xaxisChild, xaxisNode:
+        for _, k := range c.children() {
+            if s.axis == xaxisNode || xnameMatch(s.test, xmlNodeName(k.node)) {
+                cands = append(cands, xitem{ctx: k})
+            }
+        }
+    case xaxisDescOrSelf:
+        for _, d := range c.descendantsOrSelf(nil) {
+            cands = append(cands, xitem{ctx: d})
+        }
+    case xaxisSelf:
+        cands = append(cands, xitem{ctx: c})
+    case xaxisParent:
+        if c.parent != nil {
+            cands = append(cands, xitem{ctx: c.parent})
+        }
+    case xaxisAttr:
+        if c.isDoc {
+            break
+        }
+        attrs := xmlNodeAttrs(c.node)
+        keys := make([]string, 0, len(attrs))
+        for k := range attrs {
+            if xnameMatch(s.test, k) {
+                keys = append(keys, k)
+            }
+        }
+        sort.Strings(keys)
+        for _, k := range keys {
+            cands = append(cands, xitem{str: GetAsString(attrs[k])})
+        }
+    case xaxisText:
+        if t := xmlNodeText(c.node); t != "" && !c.isDoc {
+            cands = append(cands, xitem{str: t})
+        }
+    }
+
+    for _, pred := range s.preds {
+        var kept []xitem
+        for i, it := range cands {
+            ctx := it.ctx
+            if ctx == nil {
+                // predicates on strings see them as a text-only node
+                ctx = &xctx{node: map[string]any{"text": it.str}}
+            }
+            v, err := pred.eval(ctx, i+1, len(cands))
+            if err != nil {
+                return nil, err
+            }
+            if n, isNum := v.(float64); isNum {
+                if n == float64(i+1) {
+                    kept = append(kept, it)
+                }
+            } else if xbool(v) {
+                kept = append(kept, it)
+            }
+        }
+        cands = kept
+    }
+    return cands, nil
+}
+
+func (xp *xpath) eval(c *xctx) ([]xitem, error) {
+    start := c
+    if xp.abs {
+        for start.parent != nil {
+            start = start.parent
+        }
+        if !start.isDoc {
+            // re-parent the query root so .. from it reaches the document
+            doc := &xctx{node: map[string]any{"children": []any{start.node}}, isDoc: true}
+            start.parent = doc
+            start = doc
+        }
+    }
+    set := []xitem{{ctx: start}}
+    for _, step := range xp.steps {
+        var next []xitem
+        seen := make(map[uintptr]bool)
+        for _, it := range set {
+            if it.ctx == nil {
+                continue // no steps below a string
+            }
+            out, err := step.apply(it.ctx)
+            if err != nil {
+                return nil, err
+            }
+            for _, o := range out {
+                if o.ctx != nil {
+                    id := reflect.ValueOf(o.ctx.node).Pointer()
+                    if seen[id] {
+                        continue
+                    }
+                    seen[id] = true
+                }
+                next = append(next, o)
+            }
+        }
+        set = next
+    }
+    // the document node itself is not a result
+    var result []xitem
+    for _, it := range set {
+        if it.ctx == nil || !it.ctx.isDoc {
+            result = append(result, it)
+        }
+    }
+    return result, nil
+}
+
+// eval returns a string, float64, bool or []xitem.
+func (e *xexpr) eval(c *xctx, pos, size int) (any, error) {
+    switch e.kind {
+    case xeStr:
+        return e.str, nil
+    case xeNum:
+        return e.num, nil
+    case xePath:
+        return e.path.eval(c)
+    case xeUnion:
+        l, err := e.args[0].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        r, err := e.args[1].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        ls, lok := l.([]xitem)
+        rs, rok := r.([]xitem)
+        if !lok || !rok {
+            return nil, errors.New("'|' needs node-sets on both sides")
+        }
+        return append(ls, rs...), nil
+    case xeOr, xeAnd:
+        l, err := e.args[0].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        if xbool(l) == (e.kind == xeOr) {
+            return e.kind == xeOr, nil
+        }
+        r, err := e.args[1].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        return xbool(r), nil
+    case xeCmp:
+        l, err := e.args[0].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        r, err := e.args[1].eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        return xcompare(e.op, l, r), nil
+    case xeFunc:
+        return e.call(c, pos, size)
+    }
+    return nil, errors.New("invalid expression")
+}
+
+func (e *xexpr) call(c *xctx, pos, size int) (any, error) {
+    var args []any
+    for _, a := range e.args {
+        v, err := a.eval(c, pos, size)
+        if err != nil {
+            return nil, err
+        }
+        args = append(args, v)
+    }
+    want := func(n int) error {
+        if len(args) != n {
+            return fmt.Errorf("%s() takes %d argument(s)", e.op, n)
+        }
+        return nil
+    }
+    // functions taking an optional node-set default to the context node
+    ctxString := func() string {
+        if len(args) > 0 {
+            return xstring(args[0])
+        }
+        return xmlStringValue(c.node)
+    }
+    switch e.op {
+    case "last":
+        return float64(size), want(0)
+    case "position":
+        return float64(pos), want(0)
+    case "count":
+        if err := want(1); err != nil {
+            return nil, err
+        }
+        set, ok := args[0].([]xitem)
+        if !ok {
+            return nil, errors.New("count() needs a node-set")
+        }
+        return float64(len(set)), nil
+    case "name", "local-name":
+        name := xmlNodeName(c.node)
+        if len(args) == 1 {
+            set, ok := args[0].([]xitem)
+            if !ok || len(set) == 0 || set[0].ctx == nil {
+                return "", nil
+            }
+            name = xmlNodeName(set[0].ctx.node)
+        }
+        if e.op == "local-name" {
+            name = xmlLocalName(name)
+        }
+        return name, nil
+    case "contains", "starts-with", "ends-with":
+        if err := want(2); err != nil {
+            return nil, err
+        }
+        a, b := xstring(args[0]), xstring(args[1])
+        switch e.op {
+        case "contains":
+            return strings.Contains(a, b), nil
+        case "starts-with":
+            return strings.HasPrefix(a, b), nil
+        }
+        return strings.HasSuffix(a, b), nil
+    case "string-length":
+        return float64(len([]rune(ctxString()))), nil
+    case "normalize-space":
+        return strings.Join(strings.Fields(ctxString()), " "), nil
+    case "string":
+        return ctxString(), nil
+    case "number":
+        return xnumber(ctxString()), nil
+    case "not":
+        if err := want(1); err != nil {
+            return nil, err
+        }
+        return !xbool(args[0]), nil
+    case "true", "false":
+        return e.op == "true", want(0)
+    }
+    return nil, fmt.Errorf("unsupported function %s()", e.op)
+}
+
+func xbool(v any) bool {
+    switch t := v.(type) {
+    case bool:
+        return t
+    case float64:
+        return t != 0 && !math.IsNaN(t)
+    case string:
+        return t != ""
+    case []xitem:
+        return len(t) > 0
+    }
+    return false
+}
+
+func xstring(v any) string {
+    switch t := v.(type) {
+    case string:
+        return t
+    case float64:
+        return strconv.FormatFloat(t, 'f', -1, 64)
+    case bool:
+        return strconv.FormatBool(t)
+    case []xitem:
+        if len(t) > 0 {
+            return t[0].stringValue()
+        }
+    }
+    return ""
+}
+
+func xnumber(v any) float64 {
+    switch t := v.(type) {
+    case float64:
+        return t
+    case bool:
+        if t {
+            return 1
+        }
+        return 0
+    }
+    n, err := strconv.ParseFloat(strings.TrimSpace(xstring(v)), 64)
+    if err != nil {
+        return math.NaN()
+    }
+    return n
+}
+
+// xcompare follows XPath 1.0: a node-set compares true if any member does.
+func xcompare(op string, l, r any) bool {
+    if ls, ok := l.([]xitem); ok {
+        for _, it := range ls {
+            if xcompare(op, it.stringValue(), r) {
+                return true
+            }
+        }
+        return false
+    }
+    if rs, ok := r.([]xitem); ok {
+        for _, it := range rs {
+            if xcompare(op, l, it.stringValue()) {
+                return true
+            }
+        }
+        return false
+    }
+    switch op {
+    case "=", "!=":
+        var eq bool
+        _, lb := l.(bool)
+        _, rb := r.(bool)
+        _, ln := l.(float64)
+        _, rn := r.(float64)
+        switch {
+        case lb || rb:
+            eq = xbool(l) == xbool(r)
+        case ln || rn:
+            eq = xnumber(l) == xnumber(r)
+        default:
+            eq = xstring(l) == xstring(r)
+        }
+        return eq == (op == "=")
+    }
+    a, b := xnumber(l), xnumber(r)
+    switch op {
+    case "<":
+        return a < b
+    case "<=":
+        return a <= b
+    case ">":
+        return a > b
+    }
+    return a >= b
+}
@@ -35,10 +35,16 @@ func buildStandardLib() {
     buildTuiLib()
     buildErrorLib()
     buildYamlLib()
+    buildCsvLib()
+    buildTomlLib()
+    buildXmlLib()
     buildZipLib()
     buildGzipLib()
     buildSmtpLib()
     buildCronLib()
+    buildChanLib()
+    buildPoolLib()
+    buildSyncLib()
     buildSystemLib()
     buildFfiLib()
 }
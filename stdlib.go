@@ -37,11 +37,17 @@ func buildStandardLib() {
     buildTuiLib()
     buildErrorLib()
     buildYamlLib()
+    buildCsvLib()
+    buildTomlLib()
+    buildXmlLib()
     buildZipLib()
     buildGzipLib()
     buildUuidLib()
     buildSmtpLib()
     buildCronLib()
+    buildChanLib()
+    buildPoolLib()
+    buildSyncLib()
     buildFfiLib()
     buildMetricsLib()
 
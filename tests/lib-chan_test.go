@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChanCloseDrains(t *testing.T) {
+	ch := newZaChannel(2)
+	ch.send(1, -1)
+	ch.send(2, -1)
+	if !ch.close() || ch.close() {
+		t.Fatal("close should succeed exactly once")
+	}
+	if err := ch.send(3, 0); err != errChanClosed {
+		t.Errorf("send after close: %v", err)
+	}
+	var got []any
+	for {
+		v, more, err := ch.loopNext()
+		if err != nil || !more {
+			break
+		}
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("drained %v", got)
+	}
+}
+
+func TestChanTimeouts(t *testing.T) {
+	ch := newZaChannel(0)
+	start := time.Now()
+	if _, err := ch.receive(50 * time.Millisecond); err != errChanTimeout {
+		t.Errorf("receive: %v", err)
+	}
+	if err := ch.send("x", 0); err != errChanTimeout {
+		t.Errorf("unbuffered send with no receiver: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("timeouts took %v", time.Since(start))
+	}
+
+	// a blocked receiver wakes when the channel closes
+	done := make(chan error)
+	go func() {
+		_, err := ch.receive(-1)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ch.close()
+	if err := <-done; err != errChanClosed {
+		t.Errorf("woken receiver: %v", err)
+	}
+}
+
+func TestChanConcurrentProducers(t *testing.T) {
+	ch := newZaChannel(4)
+	var wg sync.WaitGroup
+	for p := 0; p < 8; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ch.send(p*100+i, -1)
+			}
+		}(p)
+	}
+	go func() { wg.Wait(); ch.close() }()
+	seen := make(map[any]bool)
+	for {
+		v, more, _ := ch.loopNext()
+		if !more {
+			break
+		}
+		seen[v] = true
+	}
+	if info := ch.toMap(); len(seen) != 800 || info["sent"] != 800 || info["received"] != 800 {
+		t.Errorf("received %d distinct values, info %v", len(seen), info)
+	}
+}
+
+func TestChanSelect(t *testing.T) {
+	a, b := newZaChannel(1), newZaChannel(1)
+	b.send("hello", 0)
+	r, err := chanSelect([]any{map[string]any{"recv": a}, map[string]any{"recv": b}}, time.Second)
+	if err != nil || r["index"] != 1 || r["value"] != "hello" {
+		t.Errorf("recv case: %v %v", r, err)
+	}
+	r, _ = chanSelect([]any{map[string]any{"send": a, "value": nil}}, time.Second)
+	if r["index"] != 0 || r["ok"] != true {
+		t.Errorf("send case: %v", r)
+	}
+	if v, _ := a.receive(0); v != nil {
+		t.Errorf("sent nil, received %v", v)
+	}
+	if r, _ = chanSelect([]any{map[string]any{"recv": a}}, 0); r["index"] != -1 {
+		t.Errorf("default case: %v", r)
+	}
+	a.close()
+	if r, _ = chanSelect([]any{map[string]any{"recv": a}}, -1); r["index"] != 0 || r["error"] != "closed" {
+		t.Errorf("closed recv case: %v", r)
+	}
+	if r, _ = chanSelect([]any{map[string]any{"send": a, "value": 1}}, -1); r["error"] != "closed" {
+		t.Errorf("closed send case: %v", r)
+	}
+	if _, err = chanSelect([]any{map[string]any{"peek": b}}, 0); err == nil {
+		t.Errorf("unknown case accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSpecForms(t *testing.T) {
+	for spec, want := range map[string]string{
+		"@hourly":     "0 * * * *",
+		"@DAILY":      "0 0 * * *",
+		"*/5 * * * *": "*/5 * * * *",
+	} {
+		got, every, err := cronSpec(spec)
+		if err != nil || got != want || every != 0 {
+			t.Errorf("%s: got %q %v %v", spec, got, every, err)
+		}
+	}
+	if _, every, err := cronSpec("@every 1m30s"); err != nil || every != 90*time.Second {
+		t.Errorf("@every: %v %v", every, err)
+	}
+	for _, bad := range []string{"@every 5ms", "@every soon", "@fortnightly", "* * *", "60 * * * *"} {
+		if _, _, err := cronSpec(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestCronTimezone(t *testing.T) {
+	j, err := newCronJob("30 9 * * *", "main::f", 0, nil, map[string]any{"timezone": "Asia/Tokyo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-03-01 23:00 UTC is 08:00 on 2 March in Tokyo
+	from := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	next, err := j.nextAfter(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 2, 0, 30, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next run %v, want %v", next.UTC(), want)
+	}
+}
+
+func TestCronMissedFires(t *testing.T) {
+	for _, policy := range []string{"skip", "run_once"} {
+		j, err := newCronJob("*/10 * * * *", "main::f", 0, nil, map[string]any{"missed": policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		due := time.Date(2026, 5, 4, 10, 0, 0, 0, time.Local)
+
+		run, _ := j.fired(due, due.Add(2*time.Second))
+		if !run || j.missedN != 0 {
+			t.Errorf("%s: on-time fire run=%v missed=%d", policy, run, j.missedN)
+		}
+
+		// woken 35 minutes late: 10:00, 10:10, 10:20 and 10:30 all passed
+		run, _ = j.fired(due, due.Add(35*time.Minute))
+		if run != (policy == "run_once") || j.missedN != 4 {
+			t.Errorf("%s: late fire run=%v missed=%d", policy, run, j.missedN)
+		}
+		if want := due.Add(40 * time.Minute); !j.next.Equal(want) {
+			t.Errorf("%s: next %v, want %v", policy, j.next, want)
+		}
+	}
+}
+
+func TestCronOverlap(t *testing.T) {
+	for policy, want := range map[string][3]int{"skip": {1, 2, 0}, "queue": {1, 0, 2}} {
+		j, _ := newCronJob("@every 1s", "main::f", 0, nil, map[string]any{"overlap": policy})
+		j.running = 1 // a run in progress
+		j.dispatch()
+		j.dispatch()
+		if got := [3]int{j.running, j.skipped, j.queued}; got != want {
+			t.Errorf("%s: running/skipped/queued %v, want %v", policy, got, want)
+		}
+	}
+}
+
+func TestCronOptions(t *testing.T) {
+	for _, opts := range []map[string]any{
+		{"overlap": "never"},
+		{"missed": "all"},
+		{"timezone": "Nowhere/Special"},
+		{"name": 5},
+		{"retries": "3"},
+	} {
+		if _, err := newCronJob("@daily", "main::f", 0, nil, opts); err == nil {
+			t.Errorf("options %v accepted", opts)
+		}
+	}
+}
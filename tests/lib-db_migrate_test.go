@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	script := `-- leading comment
+CREATE TABLE a (id INTEGER);
+INSERT INTO a VALUES ('x;y');
+/* block; comment */ UPDATE a SET id = 2;
+-- trailing comment only
+`
+	got := splitSQLStatements(script)
+	want := []string{
+		"-- leading comment\nCREATE TABLE a (id INTEGER)",
+		"INSERT INTO a VALUES ('x;y')",
+		"/* block; comment */ UPDATE a SET id = 2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitSQLStatements()\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestLoadMigrationsOrderAndPairs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"010_later.sql", "002_second.up.sql", "002_second.down.sql", "001_first.up.sql", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migs, err := loadMigrations(dir)
+	if err != nil {
+		t.Fatalf("loadMigrations() failed: %v", err)
+	}
+	if len(migs) != 3 {
+		t.Fatalf("expected 3 migrations, got %d", len(migs))
+	}
+	if migs[0].version != 1 || migs[1].version != 2 || migs[2].version != 10 {
+		t.Errorf("unexpected order: %d, %d, %d", migs[0].version, migs[1].version, migs[2].version)
+	}
+	if migs[1].downFile == "" || migs[0].downFile != "" {
+		t.Errorf("down files not paired correctly: %+v", migs)
+	}
+}
+
+func TestLoadMigrationsRejectsOrphanDown(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "003_x.down.sql"), []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadMigrations(dir); err == nil {
+		t.Error("expected an error for a down file without an up file")
+	}
+}
+
+func TestDBPlaceholderUsesOpenedDriver(t *testing.T) {
+	pg, err := dbOpenDriver("postgres", "postgres://u@localhost/none?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+	lite, err := dbOpenDriver("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lite.Close()
+	if got := dbPlaceholder(pg, 2); got != "$2" {
+		t.Errorf("postgres placeholder = %q", got)
+	}
+	if got := dbPlaceholder(lite, 2); got != "?" {
+		t.Errorf("sqlite3 placeholder = %q", got)
+	}
+}
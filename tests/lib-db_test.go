@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoerceToFieldIntegerWidths(t *testing.T) {
+	widths := map[string]reflect.Type{
+		"zt_int8":   reflect.TypeOf(int8(0)),
+		"zt_int16":  reflect.TypeOf(int16(0)),
+		"zt_int32":  reflect.TypeOf(int32(0)),
+		"zt_uint16": reflect.TypeOf(uint16(0)),
+	}
+	for name, typ := range widths {
+		Typemap[name] = typ
+		defer delete(Typemap, name)
+	}
+
+	for _, tc := range []struct {
+		raw, field string
+		want       any
+	}{
+		{"-128", "zt_int8", int8(-128)},
+		{" 127 ", "zt_int8", int8(127)},
+		{"-32768", "zt_int16", int16(-32768)},
+		{"2147483647", "zt_int32", int32(2147483647)},
+		{"65535", "zt_uint16", uint16(65535)},
+		{"255", "uint8", uint8(255)},
+		{"4294967295", "ulong", uint32(4294967295)},
+		{"-9223372036854775808", "int", int(-9223372036854775808)},
+	} {
+		got, err := coerceToField(tc.raw, tc.field)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %q = %v (%T), %v; want %v (%T)", tc.field, tc.raw, got, got, err, tc.want, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ raw, field string }{
+		{"128", "zt_int8"},
+		{"-129", "zt_int8"},
+		{"32768", "zt_int16"},
+		{"2147483648", "zt_int32"},
+		{"65536", "zt_uint16"},
+		{"-1", "zt_uint16"},
+		{"256", "uint8"},
+		{"4294967296", "ulong"},
+		{"-1", "uint64"},
+		{"1.5", "zt_int32"},
+	} {
+		if got, err := coerceToField(tc.raw, tc.field); err == nil {
+			t.Errorf("%s %q accepted as %v", tc.field, tc.raw, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelEachBoundsWorkers(t *testing.T) {
+	var running, peak, calls int32
+	seen := make([]bool, 50)
+	err := parallelEach(len(seen), 4, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		seen[i] = true
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	if err != nil || calls != 50 || peak > 4 || peak < 2 {
+		t.Errorf("err %v, calls %d, peak %d", err, calls, peak)
+	}
+	for i, s := range seen {
+		if !s {
+			t.Errorf("index %d skipped", i)
+		}
+	}
+}
+
+func TestParallelEachFirstError(t *testing.T) {
+	var calls int32
+	err := parallelEach(100, 2, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond)
+		if i == 5 || i == 6 {
+			return fmt.Errorf("failed at %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "failed at 5" {
+		t.Errorf("got %v, want the lowest failing index", err)
+	}
+	if calls > 10 {
+		t.Errorf("%d calls made after the failure", calls)
+	}
+
+	if err := parallelEach(0, 4, func(int) error { return errors.New("called") }); err != nil {
+		t.Errorf("empty run: %v", err)
+	}
+}
+
+func TestParallelListArgs(t *testing.T) {
+	items, err := listItems("pmap", []int{1, 2, 3})
+	if err != nil || len(items) != 3 || items[2] != 3 {
+		t.Errorf("typed list: %v %v", items, err)
+	}
+	if _, err := listItems("pmap", map[string]any{}); err == nil {
+		t.Error("map accepted as a list")
+	}
+	if n, err := listWorkers("pmap", []any{nil, nil, 6}, 2); err != nil || n != 6 {
+		t.Errorf("workers %d %v", n, err)
+	}
+	if _, err := listWorkers("pmap", []any{nil, nil, 0}, 2); err == nil {
+		t.Error("zero workers accepted")
+	}
+	if _, err := listFnArg("pmap", "main", 3.5); err == nil {
+		t.Error("float accepted as a function")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dnsStandIn answers queries on 127.0.0.1 over udp and tcp from a fixed zone.
+// Names in answers are compressed against the question at offset 12.
+type dnsStandIn struct {
+	udp     *net.UDPConn
+	tcp     *net.TCPListener
+	addr    string
+	tcpHits atomic.Int32
+}
+
+func startDnsStandIn(t *testing.T) *dnsStandIn {
+	t.Helper()
+	var s dnsStandIn
+	for i := 0; i < 20; i++ {
+		u, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: u.LocalAddr().(*net.UDPAddr).Port})
+		if err != nil {
+			u.Close()
+			continue
+		}
+		s.udp, s.tcp, s.addr = u, l, u.LocalAddr().String()
+		break
+	}
+	if s.udp == nil {
+		t.Fatal("no free port for stand-in server")
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := s.udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.udp.WriteTo(s.reply(buf[:n], false), from)
+		}
+	}()
+	go func() {
+		for {
+			c, err := s.tcp.Accept()
+			if err != nil {
+				return
+			}
+			s.tcpHits.Add(1)
+			var l [2]byte
+			io.ReadFull(c, l[:])
+			q := make([]byte, binary.BigEndian.Uint16(l[:]))
+			io.ReadFull(c, q)
+			r := s.reply(q, true)
+			c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(r))), r...))
+			c.Close()
+		}
+	}()
+	t.Cleanup(func() { s.udp.Close(); s.tcp.Close() })
+	return &s
+}
+
+func (s *dnsStandIn) reply(q []byte, tcp bool) []byte {
+	qend := 12
+	for q[qend] != 0 {
+		qend += int(q[qend]) + 1
+	}
+	qname := string(q[12:qend])
+	qtype := binary.BigEndian.Uint16(q[qend+1:])
+	qend += 5
+
+	rr := func(rtype uint16, ttl uint32, rdata []byte) []byte {
+		b := []byte{0xC0, 12}
+		b = binary.BigEndian.AppendUint16(b, rtype)
+		b = binary.BigEndian.AppendUint16(b, 1)
+		b = binary.BigEndian.AppendUint32(b, ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+		return append(b, rdata...)
+	}
+	var answers [][]byte
+	rcode := uint16(0)
+	truncated := false
+	switch qname {
+	case "\x03www\x07example\x04test":
+		answers = append(answers, rr(1, 300, []byte{192, 0, 2, 10}))
+	case "\x07example\x04test":
+		switch qtype {
+		case 15:
+			answers = append(answers, rr(15, 3600, []byte{0, 10, 4, 'm', 'a', 'i', 'l', 0xC0, 12}))
+		case 6:
+			rd := []byte{2, 'n', 's', 0xC0, 12, 10, 'h', 'o', 's', 't', 'm', 'a', 's', 't', 'e', 'r', 0xC0, 12}
+			for _, v := range []uint32{2024010101, 7200, 900, 1209600, 60} {
+				rd = binary.BigEndian.AppendUint32(rd, v)
+			}
+			answers = append(answers, rr(6, 86400, rd))
+		case 16:
+			answers = append(answers, rr(16, 60, []byte("\x06v=spf1\x05 -all")))
+		}
+	case "\x05_http\x04_tcp\x07example\x04test":
+		answers = append(answers, rr(33, 120, []byte{0, 1, 0, 5, 0x1F, 0x90, 3, 'w', 'e', 'b', 0, 0}))
+	case "\x03big\x07example\x04test":
+		if !tcp {
+			truncated = true
+			break
+		}
+		for i := byte(1); i <= 40; i++ {
+			answers = append(answers, rr(1, 30, []byte{198, 51, 100, i}))
+		}
+	default:
+		rcode = 3
+	}
+
+	flags := uint16(0x8000|0x0400|0x0080) | rcode | binary.BigEndian.Uint16(q[2:])&0x0100
+	if truncated {
+		flags |= 0x0200
+	}
+	b := make([]byte, 12)
+	copy(b, q[:2])
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	b = append(b, q[12:qend]...)
+	for _, a := range answers {
+		b = append(b, a...)
+	}
+	return b
+}
+
+func standInQuery(t *testing.T, s *dnsStandIn, name, qtype string, opts map[string]any) map[string]any {
+	t.Helper()
+	if opts == nil {
+		opts = map[string]any{}
+	}
+	opts["server"] = s.addr
+	o, err := parseDnsOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := dnsQuery(name, dnsTypes[qtype], o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["error"] != "" {
+		t.Fatalf("%s %s: %v", name, qtype, res["error"])
+	}
+	return res
+}
+
+func TestDnsQueryRecords(t *testing.T) {
+	s := startDnsStandIn(t)
+
+	r := standInQuery(t, s, "www.example.test", "A", nil)
+	a := r["answers"].([]any)
+	if r["rcode"] != "NOERROR" || r["authoritative"] != true || len(a) != 1 {
+		t.Fatalf("A reply %v", r)
+	}
+	if rec := a[0].(map[string]any); rec["data"] != "192.0.2.10" || rec["ttl"] != 300 || rec["name"] != "www.example.test." {
+		t.Errorf("A record %v", rec)
+	}
+
+	mx := standInQuery(t, s, "example.test", "MX", nil)["answers"].([]any)[0].(map[string]any)
+	if mx["preference"] != 10 || mx["exchange"] != "mail.example.test." {
+		t.Errorf("MX record %v", mx)
+	}
+
+	soa := standInQuery(t, s, "example.test.", "SOA", nil)["answers"].([]any)[0].(map[string]any)
+	if soa["mname"] != "ns.example.test." || soa["rname"] != "hostmaster.example.test." || soa["serial"] != 2024010101 || soa["minimum"] != 60 {
+		t.Errorf("SOA record %v", soa)
+	}
+
+	txt := standInQuery(t, s, "example.test", "TXT", nil)["answers"].([]any)[0].(map[string]any)
+	if txt["data"] != "v=spf1 -all" || len(txt["strings"].([]any)) != 2 {
+		t.Errorf("TXT record %v", txt)
+	}
+
+	srv := standInQuery(t, s, "_http._tcp.example.test", "SRV", nil)["answers"].([]any)[0].(map[string]any)
+	if srv["priority"] != 1 || srv["weight"] != 5 || srv["port"] != 8080 || srv["target"] != "web." {
+		t.Errorf("SRV record %v", srv)
+	}
+
+	if r := standInQuery(t, s, "nope.example.test", "A", nil); r["rcode"] != "NXDOMAIN" || len(r["answers"].([]any)) != 0 {
+		t.Errorf("NXDOMAIN reply %v", r)
+	}
+}
+
+func TestDnsQueryTcpFallback(t *testing.T) {
+	s := startDnsStandIn(t)
+	r := standInQuery(t, s, "big.example.test", "A", nil)
+	if r["protocol"] != "tcp" || len(r["answers"].([]any)) != 40 || s.tcpHits.Load() != 1 {
+		t.Errorf("fallback reply %v, tcp hits %d", r["protocol"], s.tcpHits.Load())
+	}
+	r = standInQuery(t, s, "www.example.test", "A", map[string]any{"tcp": true})
+	if r["protocol"] != "tcp" || len(r["answers"].([]any)) != 1 {
+		t.Errorf("forced tcp reply %v", r)
+	}
+}
+
+func TestDnsQueryTimeout(t *testing.T) {
+	u, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer u.Close()
+	o, _ := parseDnsOptions(map[string]any{"server": u.LocalAddr().String(), "timeout": 0.1, "retries": 0})
+	start := time.Now()
+	r, err := dnsQuery("example.test", 1, o)
+	if err != nil || r["error"] == "" || time.Since(start) > time.Second {
+		t.Errorf("expected timeout error, got %v %v", r, err)
+	}
+}
+
+func TestDnsReverseName(t *testing.T) {
+	if n := dnsReverseName(net.ParseIP("192.0.2.1")); n != "1.2.0.192.in-addr.arpa." {
+		t.Errorf("ipv4 reverse %s", n)
+	}
+	if n := dnsReverseName(net.ParseIP("2001:db8::1")); n != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa." {
+		t.Errorf("ipv6 reverse %s", n)
+	}
+}
+
+func TestDnsMalformedReply(t *testing.T) {
+	// a name pointer that points at itself must not loop
+	msg := []byte{0, 7, 0x80, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0xC0, 12}
+	if _, err := dnsParseReply(msg, 7); err == nil {
+		t.Errorf("looping pointer accepted")
+	}
+	if _, err := dnsParseReply([]byte{0, 7, 0x80}, 7); err == nil {
+		t.Errorf("short header accepted")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTcpReadFramedKeepsRemainder(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	h := &tcpClientHandle{conn: b, connected: true}
+
+	go a.Write([]byte("GET key\r\n$3\r\nfoo"))
+	line, err := h.readFramed(time.Second, scanUntil([]byte("\n"), tcpLineMax, true))
+	if err != nil || string(line) != "GET key" {
+		t.Fatalf("line %q %v", line, err)
+	}
+	hdr, _ := h.readFramed(time.Second, scanUntil([]byte("\n"), tcpLineMax, true))
+	body, err := h.readFramed(time.Second, scanCount(3))
+	if string(hdr) != "$3" || string(body) != "foo" || err != nil {
+		t.Errorf("bulk %q %q %v", hdr, body, err)
+	}
+
+	go a.Write([]byte("partial"))
+	if _, err := h.readFramed(50*time.Millisecond, scanUntil([]byte("\n"), tcpLineMax, true)); tcpReadError(err) != "timeout" {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if h.buffered() != 7 {
+		t.Errorf("partial line not kept, %d buffered", h.buffered())
+	}
+	if got := h.readBuffered(4); string(got) != "part" || h.buffered() != 3 {
+		t.Errorf("readBuffered %q", got)
+	}
+}
+
+func TestTcpFramePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		opts map[string]any
+		n    int
+		want []byte
+	}{
+		{nil, 5, []byte{0, 0, 0, 5}},
+		{map[string]any{"size": 2, "order": "little"}, 0x0102, []byte{2, 1}},
+		{map[string]any{"size": 1}, 255, []byte{255}},
+		{map[string]any{"size": 8}, 1, []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		o, err := parseFrameOptions("test", tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := framePrefix(o, tc.n)
+		if err != nil || !bytes.Equal(p, tc.want) {
+			t.Errorf("%v: prefix %v %v, want %v", tc.opts, p, err, tc.want)
+		}
+		frame, used, ok, _ := scanPrefixed(o)(append(append(p, bytes.Repeat([]byte("z"), tc.n)...), 'x'))
+		if !ok || len(frame) != tc.n || used != len(p)+tc.n {
+			t.Errorf("%v: scanned %d bytes, used %d", tc.opts, len(frame), used)
+		}
+	}
+	o, _ := parseFrameOptions("test", map[string]any{"size": 1})
+	if _, err := framePrefix(o, 256); err == nil {
+		t.Errorf("256 bytes fitted a 1 byte prefix")
+	}
+	for _, bad := range []map[string]any{{"size": 3}, {"order": "middle"}, {"max": -1}, {"length": 4}} {
+		if _, err := parseFrameOptions("test", bad); err == nil {
+			t.Errorf("options %v accepted", bad)
+		}
+	}
+}
+
+func TestTcpScanLimits(t *testing.T) {
+	if _, _, _, err := scanUntil([]byte("\r\n"), 4, false)([]byte("abcdefg")); err == nil {
+		t.Errorf("overlong unterminated data accepted")
+	}
+	if _, _, ok, err := scanUntil([]byte("\r\n"), 4, false)([]byte("abcd\r")); ok || err != nil {
+		t.Errorf("delimiter split across reads should wait for more data")
+	}
+	hdr := binary.BigEndian.AppendUint32(nil, 1<<30)
+	if _, _, _, err := scanPrefixed(tcpFrameOptions{size: 4, order: binary.BigEndian, max: tcpFrameMax})(hdr); err == nil {
+		t.Errorf("oversized frame accepted")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUdpListenOptions(t *testing.T) {
+	for _, opts := range []map[string]any{
+		{"buffer": 0},
+		{"buffer": 70000},
+		{"host": 1},
+		{"reuse": true},
+	} {
+		if h, err := udpListen(0, opts); err == nil {
+			h.close()
+			t.Errorf("options %v should be rejected", opts)
+		}
+	}
+}
+
+func TestUdpRoundTrip(t *testing.T) {
+	a, err := udpListen(0, map[string]any{"host": "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	b, err := udpListen(0, map[string]any{"host": "127.0.0.1", "buffer": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+
+	if _, err := a.sendTo("127.0.0.1", b.toMap()["port"].(int), []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	r := b.receive(time.Second)
+	if r["content"] != "hel" || r["port"] != a.toMap()["port"] {
+		t.Errorf("received %v", r)
+	}
+	if r := b.receive(10 * time.Millisecond); r["error"] != "timeout" {
+		t.Errorf("expected timeout, got %v", r)
+	}
+}
+
+func TestUdpMembershipChecks(t *testing.T) {
+	h, err := udpListen(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	if err := h.membership("udp_join_group", "192.168.1.1", "", true); err == nil {
+		t.Errorf("unicast group accepted")
+	}
+	if err := h.membership("udp_leave_group", "239.9.9.9", "", false); err == nil {
+		t.Errorf("leaving an unjoined group succeeded")
+	}
+	if err := h.membership("udp_join_group", "239.9.9.9", "no-such-if0", true); err == nil {
+		t.Errorf("unknown interface accepted")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	if p, ok := unixSocketPath("unix:/run/x.sock"); !ok || p != "/run/x.sock" {
+		t.Errorf("unix:/run/x.sock parsed as %q %v", p, ok)
+	}
+	for _, a := range []string{"unix:", "/run/x.sock", "localhost"} {
+		if _, ok := unixSocketPath(a); ok {
+			t.Errorf("%q should not be a unix address", a)
+		}
+	}
+}
+
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leave the file behind, as a crashed server would
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln2, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer ln2.Close()
+	if _, err := listenUnix(path); err == nil {
+		t.Errorf("live socket was taken over")
+	}
+
+	regular := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(regular, []byte("x"), 0600)
+	if _, err := listenUnix(regular); err == nil {
+		t.Errorf("regular file was replaced by a socket")
+	}
+}
+
+func TestHttpRequestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.URL.Path))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	for _, sock := range []string{path, "unix:" + path} {
+		res := httpRequest(t, "GET", "http://docker/v1.41/info", map[string]any{"unix_socket": sock})
+		if res["body"] != "docker /v1.41/info" {
+			t.Errorf("unix_socket %s: body = %v", sock, res["body"])
+		}
+	}
+	if _, err := stdlib["http_request"]("", 0, nil, "GET", "http://x/", map[string]any{"unix_socket": path, "proxy": "env"}); err == nil {
+		t.Errorf("proxy with unix_socket should be rejected")
+	}
+}
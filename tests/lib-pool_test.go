@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolCancelQueued(t *testing.T) {
+	p := newWorkerPool(1, 0)
+	p.sem <- struct{}{} // hold the only slot
+	a := p.submit("main::pool_test_missing", 0, nil, -1)
+	b := p.submit("main::pool_test_missing", 0, nil, -1)
+	if n := p.cancelAll(); n != 2 {
+		t.Fatalf("cancelled %d tasks, want 2", n)
+	}
+	if a.stop("cancelled") {
+		t.Error("second cancel succeeded")
+	}
+	res := p.wait(-1)
+	if len(res) != 2 || res[0].(map[string]any)["id"] != a.id || res[1].(map[string]any)["status"] != "cancelled" {
+		t.Errorf("wait returned %v", res)
+	}
+	r := (<-b.result).(struct {
+		l uint32
+		r any
+	}).r.(map[string]any)
+	if r["status"] != "cancelled" {
+		t.Errorf("await result %v", r)
+	}
+	<-p.sem
+	time.Sleep(20 * time.Millisecond)
+	if info := p.toMap(); info["queued"] != 0 || info["running"] != 0 || info["cancelled"] != 2 || info["uncollected"] != 0 {
+		t.Errorf("pool info %v", info)
+	}
+}
+
+func TestPoolPartialWait(t *testing.T) {
+	p := newWorkerPool(1, 0)
+	p.sem <- struct{}{}
+	task := p.submit("main::pool_test_missing", 0, nil, -1)
+	start := time.Now()
+	if res := p.wait(50 * time.Millisecond); len(res) != 0 {
+		t.Errorf("wait with a queued task returned %v", res)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("wait took %v", d)
+	}
+	<-p.sem // free the slot: the task runs and fails to find its function
+	res := p.wait(-1)
+	if len(res) != 1 || res[0].(map[string]any)["status"] != "error" || task.toMap()["error"] == "" {
+		t.Errorf("wait returned %v", res)
+	}
+	if res = p.wait(0); len(res) != 0 {
+		t.Errorf("collected tasks reported again: %v", res)
+	}
+}
+
+func TestPoolSettleOnce(t *testing.T) {
+	p := newWorkerPool(1, time.Second)
+	p.sem <- struct{}{}
+	task := p.submit("main::pool_test_missing", 0, nil, -1)
+	if task.timeout != time.Second {
+		t.Errorf("pool default timeout not applied: %v", task.timeout)
+	}
+	if !task.stop("timed_out") || task.settle("done", 1, "") {
+		t.Error("a settled task settled again")
+	}
+	if !task.isCancelled() || task.toMap()["status"] != "timed_out" {
+		t.Errorf("task %v", task.toMap())
+	}
+	<-p.sem
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockMutualExclusion(t *testing.T) {
+	l := newZaLock("", false)
+	total := 0
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				l.acquire(true, -1)
+				total++
+				l.release(true)
+			}
+		}()
+	}
+	wg.Wait()
+	if total != 4000 || l.toMap()["acquisitions"] != 4000 {
+		t.Errorf("total %d, info %v", total, l.toMap())
+	}
+	if err := l.release(true); err == nil {
+		t.Error("released a lock that was not held")
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	l := newZaLock("", false)
+	l.acquire(true, -1)
+	if l.acquire(true, 0) {
+		t.Fatal("took a held lock without waiting")
+	}
+	start := time.Now()
+	if l.acquire(true, 50*time.Millisecond) {
+		t.Fatal("took a held lock")
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("timed out after %v", d)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l.release(true)
+	}()
+	if !l.acquire(true, time.Second) {
+		t.Error("lock not taken after release")
+	}
+}
+
+func TestLockReadersAndWriters(t *testing.T) {
+	l := newZaLock("", true)
+	if !l.acquire(false, 0) || !l.acquire(false, 0) {
+		t.Fatal("readers should share the lock")
+	}
+	if l.acquire(true, 0) {
+		t.Fatal("writer took a read-locked lock")
+	}
+
+	// a waiting writer holds off new readers until it has had its turn
+	got := make(chan bool)
+	go func() { got <- l.acquire(true, time.Second) }()
+	time.Sleep(20 * time.Millisecond)
+	if l.acquire(false, 0) {
+		t.Error("reader overtook a waiting writer")
+	}
+	l.release(false)
+	l.release(false)
+	if !<-got {
+		t.Fatal("writer not admitted after readers left")
+	}
+	if l.acquire(false, 0) {
+		t.Error("reader took a write-locked lock")
+	}
+	l.release(true)
+
+	// a writer that gives up lets the readers it held off through
+	l.acquire(false, -1)
+	go func() { got <- l.acquire(true, 30*time.Millisecond) }()
+	time.Sleep(10 * time.Millisecond)
+	reader := make(chan bool)
+	go func() { reader <- l.acquire(false, time.Second) }()
+	if <-got {
+		t.Error("writer took a read-locked lock")
+	}
+	if !<-reader {
+		t.Error("reader still held off after the writer gave up")
+	}
+}
+
+func TestNamedLocks(t *testing.T) {
+	a, err := namedLock("lib-sync-test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := namedLock("lib-sync-test", true); b != a {
+		t.Error("same name returned a different lock")
+	}
+	if _, err := namedLock("lib-sync-test", false); err == nil {
+		t.Error("kind mismatch accepted")
+	}
+	if l, err := lockArg("lock", "lib-sync-test"); err != nil || l != a {
+		t.Errorf("lookup by name: %v %v", l, err)
+	}
+	if _, err := lockArg("lock", "lib-sync-missing"); err == nil {
+		t.Error("unknown name accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestTlsSelfSignedChain(t *testing.T) {
+	caPEM, caKeyPEM, err := tlsSelfSigned([]string{"test ca"}, "", 1, nil, nil)
+	if err != nil {
+		t.Fatalf("CA generation failed: %v", err)
+	}
+	pair, err := tls.X509KeyPair(caPEM, caKeyPEM)
+	if err != nil {
+		t.Fatalf("CA key pair invalid: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(pair.Certificate[0])
+	if !ca.IsCA || ca.Subject.CommonName != "test ca" {
+		t.Fatalf("CA certificate: IsCA=%v CN=%q", ca.IsCA, ca.Subject.CommonName)
+	}
+
+	leafPEM, _, err := tlsSelfSigned([]string{"127.0.0.1", "svc.local"}, "svc", 1, ca, pair.PrivateKey)
+	if err != nil {
+		t.Fatalf("leaf generation failed: %v", err)
+	}
+	block, _ := pem.Decode(leafPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("leaf parse failed: %v", err)
+	}
+	if leaf.IsCA || len(leaf.IPAddresses) != 1 || len(leaf.DNSNames) != 1 {
+		t.Errorf("leaf SANs/CA flag wrong: %v %v %v", leaf.IsCA, leaf.IPAddresses, leaf.DNSNames)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "svc.local", KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Errorf("leaf does not verify against CA for usage %v: %v", usage, err)
+		}
+	}
+}
+
+func TestTlsServerConfigErrors(t *testing.T) {
+	for _, opts := range []map[string]any{
+		{},
+		{"cert_file": "/nonexistent.crt", "key_file": "/nonexistent.key"},
+		{"cert_file": 1, "key_file": "x"},
+		{"cert_file": "a", "key_file": "b", "bogus": "c"},
+	} {
+		if _, err := tlsServerConfig("test", opts); err == nil {
+			t.Errorf("options %v should be rejected", opts)
+		}
+	}
+}
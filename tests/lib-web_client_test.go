@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	buildStandardLib()
+}
+
+// echoServer reflects the interesting parts of each request back as JSON.
+func echoServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		user, pass, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"method": r.Method,
+			"query":  r.URL.RawQuery,
+			"ctype":  r.Header.Get("Content-Type"),
+			"auth":   r.Header.Get("Authorization"),
+			"user":   user,
+			"pass":   pass,
+			"agent":  r.Header.Get("User-Agent"),
+			"x":      r.Header.Get("X-Test"),
+			"body":   string(body),
+		})
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func httpRequest(t *testing.T, method, url string, opts map[string]any) map[string]any {
+	t.Helper()
+	res, err := stdlib["http_request"]("", 0, nil, method, url, opts)
+	if err != nil {
+		t.Fatalf("http_request(%s %s) failed: %v", method, url, err)
+	}
+	return res.(map[string]any)
+}
+
+func TestHttpRequestJsonBodyAndHeaders(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	res := httpRequest(t, "POST", srv.URL+"/echo", map[string]any{
+		"json":       map[string]any{"name": "za", "n": 2},
+		"headers":    map[string]any{"X-Test": "yes"},
+		"query":      map[string]any{"page": 3},
+		"user_agent": "za-test",
+		"bearer":     "tok",
+	})
+	if res["status"] != 200 || res["ok"] != true {
+		t.Fatalf("unexpected status %v", res["status"])
+	}
+	echo, ok := res["json"].(map[string]any)
+	if !ok {
+		t.Fatalf("json field not decoded: %#v", res["json"])
+	}
+	if echo["method"] != "POST" || echo["query"] != "page=3" || echo["ctype"] != "application/json" {
+		t.Errorf("request line not as sent: %v", echo)
+	}
+	if echo["body"] != `{"n":2,"name":"za"}` {
+		t.Errorf("json body = %v", echo["body"])
+	}
+	if echo["auth"] != "Bearer tok" || echo["agent"] != "za-test" || echo["x"] != "yes" {
+		t.Errorf("headers not as sent: %v", echo)
+	}
+}
+
+func TestHttpRequestFormAndBasicAuth(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	res := httpRequest(t, "PUT", srv.URL+"/echo", map[string]any{
+		"form":       map[string]any{"a": "1", "b": []any{"x", "y"}},
+		"basic_auth": []any{"bob", "s3cret"},
+	})
+	echo := res["json"].(map[string]any)
+	if echo["body"] != "a=1&b=x&b=y" || echo["ctype"] != "application/x-www-form-urlencoded" {
+		t.Errorf("form body = %v (%v)", echo["body"], echo["ctype"])
+	}
+	if echo["user"] != "bob" || echo["pass"] != "s3cret" {
+		t.Errorf("basic auth = %v:%v", echo["user"], echo["pass"])
+	}
+}
+
+func TestHttpRequestRedirects(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	res := httpRequest(t, "GET", srv.URL+"/hop", nil)
+	redirects := res["redirects"].([]any)
+	if res["status"] != 200 || res["url"] != srv.URL+"/echo" || len(redirects) != 1 {
+		t.Errorf("followed redirect: status=%v url=%v redirects=%v", res["status"], res["url"], redirects)
+	}
+
+	res = httpRequest(t, "GET", srv.URL+"/hop", map[string]any{"follow_redirects": false})
+	headers := res["headers"].(map[string]any)
+	if res["status"] != 302 || headers["Location"] != "/echo" {
+		t.Errorf("unfollowed redirect: status=%v location=%v", res["status"], headers["Location"])
+	}
+
+	_, err := stdlib["http_request"]("", 0, nil, "GET", srv.URL+"/hop", map[string]any{"max_redirects": 0})
+	if err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("max_redirects 0 should fail, got %v", err)
+	}
+}
+
+func TestHttpRequestTimeout(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	_, err := stdlib["http_request"]("", 0, nil, "GET", srv.URL+"/slow", map[string]any{"timeout": 0.1})
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	res := httpRequest(t, "GET", srv.URL+"/slow", map[string]any{"timeout": 5})
+	if res["body"] != "late" {
+		t.Errorf("body = %v", res["body"])
+	}
+}
+
+func TestHttpRequestBadOptions(t *testing.T) {
+	for _, opts := range []map[string]any{
+		{"nope": 1},
+		{"timeout": "soon"},
+		{"cert_file": "/tmp/x.pem"},
+		{"bearer": "t", "basic_auth": []any{"a", "b"}},
+		{"proxy": "::bad"},
+	} {
+		if _, err := stdlib["http_request"]("", 0, nil, "GET", "http://127.0.0.1:1/", opts); err == nil {
+			t.Errorf("options %v should be rejected", opts)
+		}
+	}
+}
+
+func TestHttpRequestRetryAfter(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	res := httpRequest(t, "GET", srv.URL, map[string]any{"retry": map[string]any{"attempts": 2, "backoff": 0.01}})
+	attempts := res["attempts"].([]any)
+	if res["body"] != "ok" || len(attempts) != 2 {
+		t.Fatalf("expected success on second attempt, got %v %v", res["status"], attempts)
+	}
+	first := attempts[0].(map[string]any)
+	if first["status"] != 429 || first["wait_ms"].(float64) != 1000 {
+		t.Errorf("Retry-After not honoured: %v", first)
+	}
+}
+
+func TestHttpRetryDelay(t *testing.T) {
+	rp := newHttpRetry()
+	rp.backoff = 100 * time.Millisecond
+	rp.maxBackoff = 300 * time.Millisecond
+	rp.jitter = 0.5
+	for n, max := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 6: 300} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := rp.delay(n, nil)
+			if d > max || d < max/2 {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", n, d, max/2, max)
+			}
+		}
+	}
+
+	if d, ok := parseRetryAfter(time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat)); !ok || d < time.Second || d > 3*time.Second {
+		t.Errorf("HTTP-date Retry-After parsed as %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Errorf("invalid Retry-After accepted")
+	}
+}
+
+func TestHttpTransportKeepsDefaults(t *testing.T) {
+	for _, opts := range []httpOptions{{insecure: true}, {proxy: "http://proxy.local:3128"}, {unixSocket: "/tmp/zt.sock"}} {
+		tr, err := httpTransport(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr == web_tr {
+			t.Fatalf("%+v shared the default transport", opts)
+		}
+		if tr.TLSHandshakeTimeout != web_tr.TLSHandshakeTimeout || tr.IdleConnTimeout != web_tr.IdleConnTimeout ||
+			tr.MaxIdleConns != web_tr.MaxIdleConns || tr.TLSHandshakeTimeout == 0 {
+			t.Errorf("%+v lost the default timeouts or pool settings", opts)
+		}
+		if (tr.Proxy != nil) != (opts.proxy != "") {
+			t.Errorf("%+v: proxy func set = %v", opts, tr.Proxy != nil)
+		}
+	}
+	if web_tr.Proxy != nil {
+		t.Error("default transport honours environment proxies")
+	}
+	tr, _ := httpTransport(httpOptions{insecure: true})
+	if !tr.TLSClientConfig.InsecureSkipVerify || web_tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("TLS settings not applied to the copy only")
+	}
+}
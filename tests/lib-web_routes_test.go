@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutePatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		ok      bool
+		params  map[string]string
+	}{
+		{"/", "/", true, nil},
+		{"/users", "/users/", true, nil},
+		{"/users/{id}", "/users/42", true, map[string]string{"id": "42"}},
+		{"/users/{id}", "/users", false, nil},
+		{"/users/{id}", "/users/42/posts", false, nil},
+		{"/users/{id}/posts/{post}", "/users/7/posts/9", true, map[string]string{"id": "7", "post": "9"}},
+		{"/files/{rest...}", "/files/a/b/c.txt", true, map[string]string{"rest": "a/b/c.txt"}},
+		{"/files/{rest...}", "/files", true, map[string]string{"rest": ""}},
+		{"/files/{rest...}", "/other/a", false, nil},
+	}
+	for _, c := range cases {
+		segs, err := parseRoutePattern(c.pattern)
+		if err != nil {
+			t.Fatalf("parseRoutePattern(%q) failed: %v", c.pattern, err)
+		}
+		params, ok := webRoute{pattern: c.pattern, segs: segs}.match(c.path)
+		if ok != c.ok {
+			t.Errorf("%q against %q: matched=%v, want %v", c.pattern, c.path, ok, c.ok)
+			continue
+		}
+		for k, v := range c.params {
+			if params[k] != v {
+				t.Errorf("%q against %q: param %s = %v, want %q", c.pattern, c.path, k, params[k], v)
+			}
+		}
+	}
+}
+
+func TestRoutePatternInvalid(t *testing.T) {
+	for _, p := range []string{"users", "/a/{}", "/a/{id}x", "/a/{rest...}/b", "/a/{id}/{id}"} {
+		if _, err := parseRoutePattern(p); err == nil {
+			t.Errorf("pattern %q should be rejected", p)
+		}
+	}
+}
+
+func TestRouteMethods(t *testing.T) {
+	rt := webRoute{methods: []string{"GET", "POST"}}
+	if !rt.allows("HEAD") || !rt.allows("POST") || rt.allows("DELETE") {
+		t.Errorf("method filtering wrong for %v", rt.methods)
+	}
+	if !(webRoute{}).allows("PATCH") {
+		t.Errorf("route without methods should allow any method")
+	}
+}
+
+func TestWebReadBodyLimit(t *testing.T) {
+	weblock.Lock()
+	web_handles["body-limit-test"] = web_table_entry{maxBody: 8}
+	weblock.Unlock()
+	defer func() {
+		weblock.Lock()
+		delete(web_handles, "body-limit-test")
+		weblock.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader("12345678"))
+	if body, err := webReadBody(w, r, "body-limit-test"); err != nil || string(body) != "12345678" {
+		t.Errorf("body at the limit: %q %v", body, err)
+	}
+	r = httptest.NewRequest("POST", "/", strings.NewReader("123456789"))
+	var tooBig *http.MaxBytesError
+	if _, err := webReadBody(w, r, "body-limit-test"); !errors.As(err, &tooBig) {
+		t.Errorf("body over the limit: %v", err)
+	}
+}
+
+func TestMiddlewarePrefixBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, path string
+		want         bool
+	}{
+		{"/", "/anything", true},
+		{"/api", "/api", true},
+		{"/api", "/api/users", true},
+		{"/api/", "/api/users", true},
+		{"/api", "/apix", false},
+		{"/api", "/api-internal/x", false},
+		{"/api/", "/api", false},
+		{"/api/v1", "/api/v10", false},
+	} {
+		if got := (webMiddleware{prefix: tc.prefix}).covers(tc.path); got != tc.want {
+			t.Errorf("prefix %q path %q = %v, want %v", tc.prefix, tc.path, got, tc.want)
+		}
+	}
+}
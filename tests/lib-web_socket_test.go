@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func wsPair(t *testing.T) (*wsConn, *wsConn, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	srv := newWsConn(a, bufio.NewReader(a), false, "test-server", "")
+	cli := newWsConn(b, bufio.NewReader(b), true, "", "ws://pipe/")
+	return srv, cli, b
+}
+
+// rawFrame builds a masked client frame with an explicit FIN bit.
+func rawFrame(fin bool, op byte, payload []byte) []byte {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	f := []byte{b0, 0x80 | byte(len(payload))}
+	f = append(f, mask...)
+	for i, c := range payload {
+		f = append(f, c^mask[i%4])
+	}
+	return f
+}
+
+func TestWsAcceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	if got := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept key = %s", got)
+	}
+}
+
+func TestWsMessagesBothWays(t *testing.T) {
+	srv, cli, _ := wsPair(t)
+	defer srv.close(wsCloseNormal, "")
+
+	big := strings.Repeat("z", 70000) // needs the 64 bit length form
+	if err := cli.send(true, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	m := srv.receive(time.Second)
+	if m["type"] != "binary" || m["data"] != big {
+		t.Fatalf("server got type %v, %d bytes", m["type"], len(m["data"].(string)))
+	}
+
+	if err := srv.send(false, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if m := cli.receive(time.Second); m["type"] != "text" || m["data"] != "hi" {
+		t.Fatalf("client got %v", m)
+	}
+	if rtt, err := cli.ping(time.Second); err != nil || rtt <= 0 {
+		t.Errorf("ping = %v, %v", rtt, err)
+	}
+	if m := cli.receive(50 * time.Millisecond); m["type"] != "timeout" {
+		t.Errorf("expected timeout, got %v", m)
+	}
+}
+
+func TestWsFragmentsAndControlFrames(t *testing.T) {
+	a, b := net.Pipe()
+	srv := newWsConn(a, bufio.NewReader(a), false, "test-server", "")
+	go func() {
+		b.Write(rawFrame(false, wsOpText, []byte("frag")))
+		// a ping may arrive between fragments
+		b.Write(rawFrame(true, wsOpPing, []byte("p")))
+		b.Write(rawFrame(true, wsOpContinuation, []byte("mented")))
+	}()
+	// drain the pong the server sends back
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	if m := srv.receive(time.Second); m["type"] != "text" || m["data"] != "fragmented" {
+		t.Fatalf("reassembled message = %v", m)
+	}
+
+	b.Write(rawFrame(true, wsOpClose, []byte{0x03, 0xE8, 'o', 'k'}))
+	m := srv.receive(time.Second)
+	if m["type"] != "close" || m["code"] != 1000 || m["data"] != "ok" {
+		t.Errorf("close = %v", m)
+	}
+	if srv.isOpen() {
+		t.Errorf("connection still open after close")
+	}
+	srv.close(wsCloseNormal, "")
+}
+
+func TestWsRejectsUnmaskedClientFrames(t *testing.T) {
+	a, b := net.Pipe()
+	srv := newWsConn(a, bufio.NewReader(a), false, "test-server", "")
+	go func() {
+		b.Write([]byte{0x81, 0x02, 'h', 'i'})
+		buf := make([]byte, 64)
+		for {
+			if _, err := b.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	m := srv.receive(time.Second)
+	if m["type"] != "close" || m["code"] != wsCloseProtocol || m["error"] == "" {
+		t.Errorf("unmasked frame gave %v", m)
+	}
+	srv.close(wsCloseNormal, "")
+}
+
+func TestWsOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com/", "partner.example.org:8443"}
+	for _, tc := range []struct {
+		origin string
+		list   []string
+		want   bool
+	}{
+		{"", nil, true},
+		{"http://chat.local:8080", nil, true},
+		{"HTTP://CHAT.LOCAL:8080", nil, true},
+		{"https://evil.example.net", nil, false},
+		{"null", nil, false},
+		{"https://app.example.com", allowed, true},
+		{"http://app.example.com", allowed, false},
+		{"wss://partner.example.org:8443", allowed, true},
+		{"https://partner.example.org", allowed, false},
+		{"https://evil.example.net", []string{"*"}, true},
+	} {
+		r := httptest.NewRequest("GET", "http://chat.local:8080/live", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := wsOriginAllowed(r, tc.list); got != tc.want {
+			t.Errorf("origin %q with %v = %v, want %v", tc.origin, tc.list, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSseEventFraming(t *testing.T) {
+	cases := []struct {
+		data, event, id string
+		retry           int
+		want            string
+	}{
+		{"hi", "", "", 0, "data: hi\n\n"},
+		{"a\r\nb", "tick", "3", 0, "event: tick\nid: 3\ndata: a\ndata: b\n\n"},
+		{"", "", "", 1500, "retry: 1500\ndata: \n\n"},
+		{"x", "bad\nname", "", 0, "event: badname\ndata: x\n\n"},
+	}
+	for _, c := range cases {
+		if got := string(sseEvent(c.data, c.event, c.id, c.retry)); got != c.want {
+			t.Errorf("sseEvent(%q, %q, %q, %d) = %q, want %q", c.data, c.event, c.id, c.retry, got, c.want)
+		}
+	}
+	if s, _ := sseData(map[string]any{"n": 1}); s != `{"n":1}` {
+		t.Errorf("sseData(map) = %s", s)
+	}
+}
+
+func TestWebStreamLifecycle(t *testing.T) {
+	rec := httptest.NewRecorder()
+	st := newWebStream(rec, httptest.NewRequest("GET", "/", nil))
+	const loc = 1 << 30
+	webStreamBind(loc, st)
+
+	id, err := webStreamBegin("web_sse", loc, "text/event-stream", 200, map[string]any{"X-Job": "9"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := webStreamBegin("web_stream", loc, "text/plain", 200, nil, false); err == nil {
+		t.Errorf("second start should fail")
+	}
+	if !rec.Flushed || rec.Header().Get("X-Job") != "9" || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("headers not flushed: %v", rec.Header())
+	}
+	if ok, _ := stdlib["web_sse_send"]("", 0, nil, id, "one"); ok != true {
+		t.Errorf("send failed")
+	}
+
+	webStreamUnbind(loc, st)
+	if ok, _ := stdlib["web_stream_closed"]("", 0, nil, id); ok != true {
+		t.Errorf("stream should be closed after the handler returns")
+	}
+	if st.write([]byte("late")) {
+		t.Errorf("write after handler return succeeded")
+	}
+	if rec.Body.String() != "data: one\n\n" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	if _, err := webStreamBegin("web_stream", 12345, "text/plain", 200, nil, false); err == nil {
+		t.Errorf("start outside a handler should fail")
+	}
+}
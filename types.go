@@ -281,6 +281,16 @@ type enum_s struct {
     namespace string
 }
 
+// loopSource is implemented by library handles which produce FOREACH
+// values on demand rather than from a pre-built collection (e.g. db cursors).
+// loopNext returns false once the source is exhausted. loopClose is called
+// whenever the loop is left, including by BREAK, RETURN or an error, so the
+// source can release what it holds; it must be safe to call more than once.
+type loopSource interface {
+    loopNext() (any, bool, error)
+    loopClose()
+}
+
 // struct for loop internals
 type s_loop struct {
     loopVar          string           // name of counter
@@ -0,0 +1,6 @@
+-- users table
+CREATE TABLE users (
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL
+);
+INSERT INTO users (name) VALUES ('alice; the first');
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN email TEXT;
+/* backfill; keep it simple */
+UPDATE users SET email = 'alice@example.com';